@@ -17,10 +17,147 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/apikeys": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": [],
+                        "OAuth2AccessCode": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "apikeys"
+                ],
+                "summary": "lists issued API keys, revoked ones included",
+                "responses": {
+                    "200": {
+                        "description": "Keys without their secrets",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/data.ApiKeyResponse"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": [],
+                        "OAuth2AccessCode": []
+                    }
+                ],
+                "description": "The key is shown only once. Send it in the X-API-Key header. A key can only get scopes and groups the caller has, and act as the caller or as itself unless the caller has the impersonate:apikeys scope",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "apikeys"
+                ],
+                "summary": "issues an API key for services that can't sign in interactively",
+                "parameters": [
+                    {
+                        "description": "What the key is for and what it may do",
+                        "name": "apikey",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/data.ApiKeyRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Key along with its details",
+                        "schema": {
+                            "$ref": "#/definitions/data.CreatedApiKeyResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Scopes or groups the caller doesn't have, or another subject",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/apikeys/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": [],
+                        "OAuth2AccessCode": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "apikeys"
+                ],
+                "summary": "stops accepting an API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "API key ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Revoked key",
+                        "schema": {
+                            "$ref": "#/definitions/data.ApiKeyResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Key not found or already revoked",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
         "/counter": {
             "post": {
                 "security": [
                     {
+                        "ApiKeyAuth": [],
                         "OAuth2AccessCode": []
                     }
                 ],
@@ -60,6 +197,7 @@ const docTemplate = `{
             "get": {
                 "security": [
                     {
+                        "ApiKeyAuth": [],
                         "OAuth2AccessCode": []
                     }
                 ],
@@ -106,6 +244,7 @@ const docTemplate = `{
             "patch": {
                 "security": [
                     {
+                        "ApiKeyAuth": [],
                         "OAuth2AccessCode": []
                     }
                 ],
@@ -142,25 +281,62 @@ const docTemplate = `{
                         "description": "ID of the created counter object",
                         "schema": {
                             "$ref": "#/definitions/data.PatchCounterResponse"
+                        },
+                        "headers": {
+                            "RateLimit-Remaining": {
+                                "type": "integer",
+                                "description": "Requests left until the limit"
+                            }
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/errors.HTTPError"
+                        },
+                        "headers": {
+                            "RateLimit-Remaining": {
+                                "type": "integer",
+                                "description": "Requests left until the limit"
+                            }
                         }
                     },
                     "404": {
                         "description": "Counter not found",
                         "schema": {
                             "$ref": "#/definitions/errors.HTTPError"
+                        },
+                        "headers": {
+                            "RateLimit-Remaining": {
+                                "type": "integer",
+                                "description": "Requests left until the limit"
+                            }
+                        }
+                    },
+                    "429": {
+                        "description": "Too many patches, see the Retry-After header",
+                        "headers": {
+                            "RateLimit-Remaining": {
+                                "type": "integer",
+                                "description": "Requests left until the limit"
+                            },
+                            "Retry-After": {
+                                "type": "integer",
+                                "description": "Seconds until the next request is allowed"
+                            }
                         }
                     }
                 }
             }
         },
-        "/panic/{type}": {
+        "/counter/{id}/access": {
             "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": [],
+                        "OAuth2AccessCode": []
+                    }
+                ],
                 "consumes": [
                     "application/json"
                 ],
@@ -168,63 +344,956 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "panic"
+                    "counter"
                 ],
-                "summary": "throws a panic",
+                "summary": "lists the owner and the grants of a counter",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Type of panic",
-                        "name": "type",
+                        "description": "Counter ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Owner and grants",
+                        "schema": {
+                            "$ref": "#/definitions/data.CounterAccessResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Counter not found or the caller is not its admin",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": [],
+                        "OAuth2AccessCode": []
+                    }
+                ],
+                "description": "Replaces the previous grant of the same user or group. Write access includes read, admin includes write and sharing",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "counter"
+                ],
+                "summary": "grants a user or a group access to a counter",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Counter ID",
+                        "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "Who gets which access",
+                        "name": "grant",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/data.GrantAccessRequest"
+                        }
                     }
                 ],
                 "responses": {
+                    "200": {
+                        "description": "Owner and grants",
+                        "schema": {
+                            "$ref": "#/definitions/data.CounterAccessResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Counter not found or the caller is not its admin",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    },
                     "409": {
-                        "description": "Conflict",
+                        "description": "Access keeps changing concurrently",
                         "schema": {
                             "$ref": "#/definitions/errors.HTTPError"
                         }
                     },
                     "422": {
-                        "description": "Unprocessable Entity",
+                        "description": "The owner can't be granted access",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/counter/{id}/access/{kind}/{principal}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": [],
+                        "OAuth2AccessCode": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "counter"
+                ],
+                "summary": "revokes the access of a user or a group to a counter",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Counter ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "user",
+                            "group"
+                        ],
+                        "type": "string",
+                        "description": "Principal kind",
+                        "name": "kind",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Subject or group name",
+                        "name": "principal",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Owner and grants",
+                        "schema": {
+                            "$ref": "#/definitions/data.CounterAccessResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/errors.HTTPError"
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "404": {
+                        "description": "Counter or grant not found",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Access keeps changing concurrently",
                         "schema": {
                             "$ref": "#/definitions/errors.HTTPError"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "data.CounterResponse": {
-            "type": "object",
-            "properties": {
-                "counter": {
-                    "type": "integer",
-                    "example": 5
-                },
-                "createdAt": {
-                    "type": "string",
-                    "example": "2022-02-30T12:00:00Z"
-                },
+        },
+        "/counter/{id}/stats": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": [],
+                        "OAuth2AccessCode": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "stats"
+                ],
+                "summary": "retrieves counter statistics projected from its events",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Counter ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Counter statistics",
+                        "schema": {
+                            "$ref": "#/definitions/data.CounterStatsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "No statistics for the counter",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/dev/token": {
+            "post": {
+                "description": "Only available with AUTH_PROVIDER=dev, which the API refuses in production",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "dev"
+                ],
+                "summary": "mints a token for any subject and scopes",
+                "parameters": [
+                    {
+                        "description": "Who the token is for",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.DevTokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.DevTokenResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "TTL is out of range",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/panic/{type}": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "panic"
+                ],
+                "summary": "throws a panic",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Type of panic",
+                        "name": "type",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/stats/daily": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": [],
+                        "OAuth2AccessCode": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "stats"
+                ],
+                "summary": "retrieves daily numbers of created and updated counters",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "2022-02-01",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "2022-02-28",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Daily statistics",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/data.DailyStatsResponse"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": [],
+                        "OAuth2AccessCode": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "lists webhook subscriptions created by the caller",
+                "responses": {
+                    "200": {
+                        "description": "Subscriptions",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/data.WebhookResponse"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": [],
+                        "OAuth2AccessCode": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "subscribes an URL to counter events, scoped to a counter id, an event type or both",
+                "parameters": [
+                    {
+                        "description": "Where and what to deliver",
+                        "name": "webhook",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/data.WebhookRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Subscription along with its signing secret, shown only once",
+                        "schema": {
+                            "$ref": "#/definitions/data.CreatedWebhookResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Counter not found",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Subscription has no scope or its URL is not public",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": [],
+                        "OAuth2AccessCode": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "stops delivering events to the subscription",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Subscription not found",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/{id}/deliveries": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": [],
+                        "OAuth2AccessCode": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "retrieves the latest delivery attempts of the subscription",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Latest deliveries first",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/data.WebhookDelivery"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Subscription not found",
+                        "schema": {
+                            "$ref": "#/definitions/errors.HTTPError"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "data.AccessGrant": {
+            "type": "object",
+            "properties": {
+                "access": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/data.AccessLevel"
+                        }
+                    ],
+                    "example": "write"
+                },
+                "grantedAt": {
+                    "type": "string",
+                    "example": "2022-02-30T12:00:00Z"
+                },
+                "grantedBy": {
+                    "type": "string",
+                    "example": "auth0|5f8d0d55b54764421b7156c9"
+                },
+                "kind": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/data.PrincipalKind"
+                        }
+                    ],
+                    "example": "user"
+                },
+                "principal": {
+                    "type": "string",
+                    "example": "auth0|60c7c02ea38e3c3c4426c1bd"
+                }
+            }
+        },
+        "data.AccessLevel": {
+            "type": "string",
+            "enum": [
+                "read",
+                "write",
+                "admin"
+            ],
+            "x-enum-varnames": [
+                "ReadAccess",
+                "WriteAccess",
+                "AdminAccess"
+            ]
+        },
+        "data.ApiKeyRequest": {
+            "type": "object",
+            "required": [
+                "name",
+                "scopes"
+            ],
+            "properties": {
+                "groups": {
+                    "description": "Only groups the caller belongs to",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "team-a"
+                    ]
+                },
+                "name": {
+                    "type": "string",
+                    "example": "nightly-export"
+                },
+                "scopes": {
+                    "type": "array",
+                    "minItems": 1,
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "read:counter"
+                    ]
+                },
+                "subject": {
+                    "description": "Defaults to apikey|\u003ckey id\u003e. Only the caller's own subject is accepted, unless it has the impersonate:apikeys scope",
+                    "type": "string",
+                    "example": "svc|nightly-export"
+                },
+                "ttlSeconds": {
+                    "description": "Keys without a TTL don't expire",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 2592000
+                }
+            }
+        },
+        "data.ApiKeyResponse": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string",
+                    "example": "2022-02-30T12:00:00Z"
+                },
+                "createdBy": {
+                    "type": "string",
+                    "example": "auth0|5f8d0d55b54764421b7156c9"
+                },
+                "expiresAt": {
+                    "type": "string",
+                    "example": "2022-03-30T12:00:00Z"
+                },
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "team-a"
+                    ]
+                },
+                "id": {
+                    "type": "string",
+                    "example": "60c7c02ea38e3c3c4426c1bd"
+                },
+                "lastUsedAt": {
+                    "type": "string",
+                    "example": "2022-03-01T12:00:00Z"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "nightly-export"
+                },
+                "prefix": {
+                    "type": "string",
+                    "example": "gk_q3Zt9xW2"
+                },
+                "revokedAt": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "read:counter"
+                    ]
+                },
+                "subject": {
+                    "type": "string",
+                    "example": "svc|nightly-export"
+                }
+            }
+        },
+        "data.CounterAccessResponse": {
+            "type": "object",
+            "properties": {
+                "grants": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/data.AccessGrant"
+                    }
+                },
+                "owner": {
+                    "type": "string",
+                    "example": "auth0|5f8d0d55b54764421b7156c9"
+                }
+            }
+        },
+        "data.CounterResponse": {
+            "type": "object",
+            "properties": {
+                "counter": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "createdAt": {
+                    "type": "string",
+                    "example": "2022-02-30T12:00:00Z"
+                },
                 "id": {
                     "type": "string",
                     "example": "60c7c02ea38e3c3c4426c1bd"
                 },
+                "owner": {
+                    "type": "string",
+                    "example": "auth0|5f8d0d55b54764421b7156c9"
+                },
                 "updatedAt": {
                     "type": "string",
                     "example": "2022-02-30T12:00:00Z"
                 }
             }
         },
+        "data.CounterStatsResponse": {
+            "type": "object",
+            "properties": {
+                "counterId": {
+                    "type": "string",
+                    "example": "60c7c02ea38e3c3c4426c1bd"
+                },
+                "createdAt": {
+                    "type": "string",
+                    "example": "2022-02-30T12:00:00Z"
+                },
+                "firstUpdateAt": {
+                    "type": "string",
+                    "example": "2022-02-30T12:00:00Z"
+                },
+                "lastUpdateAt": {
+                    "type": "string",
+                    "example": "2022-02-30T12:00:00Z"
+                },
+                "totalUpdates": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "users": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/data.UserUpdates"
+                    }
+                }
+            }
+        },
+        "data.CreatedApiKeyResponse": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string",
+                    "example": "2022-02-30T12:00:00Z"
+                },
+                "createdBy": {
+                    "type": "string",
+                    "example": "auth0|5f8d0d55b54764421b7156c9"
+                },
+                "expiresAt": {
+                    "type": "string",
+                    "example": "2022-03-30T12:00:00Z"
+                },
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "team-a"
+                    ]
+                },
+                "id": {
+                    "type": "string",
+                    "example": "60c7c02ea38e3c3c4426c1bd"
+                },
+                "key": {
+                    "type": "string",
+                    "example": "gk_q3Zt9xW2hY0mR7cV1bN4kP8sD6fG5jL3aQ2wE1rT0yU"
+                },
+                "lastUsedAt": {
+                    "type": "string",
+                    "example": "2022-03-01T12:00:00Z"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "nightly-export"
+                },
+                "prefix": {
+                    "type": "string",
+                    "example": "gk_q3Zt9xW2"
+                },
+                "revokedAt": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "read:counter"
+                    ]
+                },
+                "subject": {
+                    "type": "string",
+                    "example": "svc|nightly-export"
+                }
+            }
+        },
+        "data.CreatedWebhookResponse": {
+            "type": "object",
+            "properties": {
+                "consecutiveFailures": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "counterId": {
+                    "type": "string",
+                    "example": "60c7c02ea38e3c3c4426c1bd"
+                },
+                "createdAt": {
+                    "type": "string",
+                    "example": "2022-02-30T12:00:00Z"
+                },
+                "disabled": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "eventType": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/data.EventType"
+                        }
+                    ],
+                    "example": "Updated"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "65a0ae4d4b4e5b1f4c1f7a2c"
+                },
+                "secret": {
+                    "type": "string",
+                    "example": "whsec_5f2b6c0e9d..."
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://partner.example.com/hooks/gokube"
+                }
+            }
+        },
+        "data.DailyStatsResponse": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "day": {
+                    "type": "string",
+                    "example": "2022-02-28"
+                },
+                "updated": {
+                    "type": "integer",
+                    "example": 15
+                }
+            }
+        },
+        "data.EventType": {
+            "type": "string",
+            "enum": [
+                "Created",
+                "Updated",
+                "AccessGranted",
+                "AccessRevoked"
+            ],
+            "x-enum-varnames": [
+                "CounterCreated",
+                "CounterUpdated",
+                "AccessGranted",
+                "AccessRevoked"
+            ]
+        },
+        "data.GrantAccessRequest": {
+            "type": "object",
+            "required": [
+                "access",
+                "kind",
+                "principal"
+            ],
+            "properties": {
+                "access": {
+                    "enum": [
+                        "read",
+                        "write",
+                        "admin"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/data.AccessLevel"
+                        }
+                    ],
+                    "example": "write"
+                },
+                "kind": {
+                    "enum": [
+                        "user",
+                        "group"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/data.PrincipalKind"
+                        }
+                    ],
+                    "example": "user"
+                },
+                "principal": {
+                    "type": "string",
+                    "example": "auth0|60c7c02ea38e3c3c4426c1bd"
+                }
+            }
+        },
         "data.PatchCounterResponse": {
             "type": "object",
             "properties": {
@@ -247,21 +1316,244 @@ const docTemplate = `{
                 }
             }
         },
+        "data.PrincipalKind": {
+            "type": "string",
+            "enum": [
+                "user",
+                "group"
+            ],
+            "x-enum-varnames": [
+                "UserPrincipal",
+                "GroupPrincipal"
+            ]
+        },
+        "data.UserUpdates": {
+            "type": "object",
+            "properties": {
+                "updates": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "who": {
+                    "type": "string",
+                    "example": "John (auth0|65a0ae4d4b4e5b1f4c1f7a2c)"
+                }
+            }
+        },
+        "data.WebhookDelivery": {
+            "type": "object",
+            "properties": {
+                "attempt": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "deliveredAt": {
+                    "type": "string",
+                    "example": "2022-02-30T12:00:00Z"
+                },
+                "duration": {
+                    "type": "integer",
+                    "example": 120000000
+                },
+                "error": {
+                    "type": "string"
+                },
+                "eventId": {
+                    "type": "string",
+                    "example": "65a0ae4d4b4e5b1f4c1f7a2e"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "65a0ae4d4b4e5b1f4c1f7a2d"
+                },
+                "statusCode": {
+                    "type": "integer",
+                    "example": 200
+                },
+                "subscriptionId": {
+                    "type": "string",
+                    "example": "65a0ae4d4b4e5b1f4c1f7a2c"
+                },
+                "succeeded": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "data.WebhookRequest": {
+            "type": "object",
+            "required": [
+                "url"
+            ],
+            "properties": {
+                "counterId": {
+                    "type": "string",
+                    "example": "60c7c02ea38e3c3c4426c1bd"
+                },
+                "eventType": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/data.EventType"
+                        }
+                    ],
+                    "example": "Updated"
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://partner.example.com/hooks/gokube"
+                }
+            }
+        },
+        "data.WebhookResponse": {
+            "type": "object",
+            "properties": {
+                "consecutiveFailures": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "counterId": {
+                    "type": "string",
+                    "example": "60c7c02ea38e3c3c4426c1bd"
+                },
+                "createdAt": {
+                    "type": "string",
+                    "example": "2022-02-30T12:00:00Z"
+                },
+                "disabled": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "eventType": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/data.EventType"
+                        }
+                    ],
+                    "example": "Updated"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "65a0ae4d4b4e5b1f4c1f7a2c"
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://partner.example.com/hooks/gokube"
+                }
+            }
+        },
         "errors.HTTPError": {
             "type": "object",
             "properties": {
-                "code": {
+                "detail": {
+                    "type": "string",
+                    "example": "Document {Counter} with id: {60c7c02ea38e3c3c4426c1bd}"
+                },
+                "instance": {
+                    "type": "string",
+                    "example": "/api/counter/60c7c02ea38e3c3c4426c1bd"
+                },
+                "invalidParams": {
+                    "description": "Only requests that failed validation list their invalid fields",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/errors.InvalidParam"
+                    }
+                },
+                "requestId": {
+                    "type": "string",
+                    "example": "3f2a9c1e5b7d4f60a8e2c4b6d8f0a1c3"
+                },
+                "status": {
+                    "type": "integer",
+                    "example": 404
+                },
+                "title": {
+                    "type": "string",
+                    "example": "Not found"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "urn:gokube:problem:not-found"
+                }
+            }
+        },
+        "errors.InvalidParam": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string",
+                    "example": "Principal"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "required"
+                }
+            }
+        },
+        "handlers.DevTokenRequest": {
+            "type": "object",
+            "required": [
+                "subject"
+            ],
+            "properties": {
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "team-a"
+                    ]
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "read:counter",
+                        "create:counter",
+                        "update:counter"
+                    ]
+                },
+                "subject": {
+                    "type": "string",
+                    "example": "dev|alice"
+                },
+                "tenant": {
+                    "type": "string",
+                    "example": "acme"
+                },
+                "ttlSeconds": {
+                    "description": "Defaults to an hour, at most a day",
                     "type": "integer",
-                    "example": 400
+                    "example": 3600
+                }
+            }
+        },
+        "handlers.DevTokenResponse": {
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "type": "string"
+                },
+                "expiresAt": {
+                    "type": "string"
                 },
-                "message": {
+                "token_type": {
                     "type": "string",
-                    "example": "status bad request"
+                    "example": "Bearer"
                 }
             }
         }
     },
     "securityDefinitions": {
+        "ApiKeyAuth": {
+            "description": "Key issued with POST /apikeys, for services that can't sign in interactively",
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
+        },
         "OAuth2AccessCode": {
             "description": "OAuth protections",
             "type": "oauth2",
@@ -270,7 +1562,10 @@ const docTemplate = `{
             "tokenUrl": "https://gokube.eu.auth0.com/oauth/token",
             "scopes": {
                 "create:counter": "\t\t\t\t\tGrants access to counter post request",
+                "manage:apikeys": "\t\t\t\t\tGrants access to issuing and revoking API keys",
+                "manage:webhooks": "\t\t\t\t\tGrants access to webhook subscriptions",
                 "read:counter": "\t\t\t\t\t\tGrants access to counter get request",
+                "share:counter": "\t\t\t\t\tGrants access to sharing counters with other users and groups",
                 "update:counter": "\t\t\t\t\tGrants access to counter patch request"
             }
         }
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steadfastie/gokube/data"
+	domainErrors "github.com/steadfastie/gokube/data/errors"
+	"github.com/steadfastie/gokube/data/repositories"
+	"go.uber.org/zap"
+)
+
+type ApiKeyController struct {
+	Repository repositories.ApiKeyRepository `container:"type"`
+	Logger     *zap.Logger                   `container:"type"`
+}
+
+// CreateHandler Issues an API key
+//
+//	@Summary		issues an API key for services that can't sign in interactively
+//	@Description	The key is shown only once. Send it in the X-API-Key header. A key can only get scopes and groups the caller has, and act as the caller or as itself unless the caller has the impersonate:apikeys scope
+//	@Tags			apikeys
+//	@Accept			json
+//	@Produce		json
+//	@Security		OAuth2AccessCode || ApiKeyAuth
+//	@Param			apikey	body		data.ApiKeyRequest				true	"What the key is for and what it may do"
+//	@Success		200		{object}	data.CreatedApiKeyResponse	"Key along with its details"
+//	@Failure		400		{object}	errors.HTTPError
+//	@Failure		422		{object}	errors.HTTPError	"Scopes or groups the caller doesn't have, or another subject"
+//	@Router			/apikeys [post]
+func (controller *ApiKeyController) CreateHandler(gc *gin.Context) {
+	var request data.ApiKeyRequest
+	if err := gc.ShouldBindJSON(&request); err != nil {
+		gc.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	if err := authorizeApiKey(gc, &request); err != nil {
+		gc.Error(err)
+		return
+	}
+
+	key, err := newApiKey()
+	if err != nil {
+		gc.Error(err)
+		return
+	}
+	apiKey := data.NewApiKeyDocument(key, &request, gc.GetString("user"), data.TenantOrDefault(gc), time.Now().UTC())
+
+	resultChan := make(chan *data.ApiKeyDocument)
+	errChan := make(chan error)
+
+	defer close(resultChan)
+	defer close(errChan)
+
+	go controller.Repository.Create(gc, apiKey, resultChan, errChan)
+
+	select {
+	case created := <-resultChan:
+		controller.Logger.Info("API key issued", zap.String("id", created.Id.Hex()), zap.String("subject", created.Subject), zap.String("by", created.CreatedBy))
+		gc.JSON(200, created.MapToCreatedResponseModel(key))
+	case err := <-errChan:
+		gc.Error(err)
+	}
+}
+
+// A key can't do anything its issuer can't, or act as anyone else
+func authorizeApiKey(gc *gin.Context, request *data.ApiKeyRequest) error {
+	granted, _ := gc.Value("scopes").(map[string]struct{})
+	for _, scope := range request.Scopes {
+		if _, ok := granted[scope]; !ok {
+			return domainErrors.NewBusinessRuleError("Keys can't get scope " + scope + " the caller doesn't have")
+		}
+	}
+
+	if request.Subject != "" && request.Subject != gc.GetString("user") {
+		if _, ok := granted[data.ApiKeyImpersonateScope]; !ok {
+			return domainErrors.NewBusinessRuleError("Keys can't act as another subject without scope " + data.ApiKeyImpersonateScope)
+		}
+	}
+
+	groups := gc.GetStringSlice("groups")
+	for _, group := range request.Groups {
+		if !slices.Contains(groups, group) {
+			return domainErrors.NewBusinessRuleError("Keys can't get group " + group + " the caller isn't a member of")
+		}
+	}
+	return nil
+}
+
+// ListHandler Lists API keys
+//
+//	@Summary	lists issued API keys, revoked ones included
+//	@Tags		apikeys
+//	@Accept		json
+//	@Produce	json
+//	@Security	OAuth2AccessCode || ApiKeyAuth
+//	@Success	200	{array}		data.ApiKeyResponse	"Keys without their secrets"
+//	@Failure	400	{object}	errors.HTTPError
+//	@Router		/apikeys [get]
+func (controller *ApiKeyController) ListHandler(gc *gin.Context) {
+	resultChan := make(chan []data.ApiKeyDocument)
+	errChan := make(chan error)
+
+	defer close(resultChan)
+	defer close(errChan)
+
+	go controller.Repository.List(gc, resultChan, errChan)
+
+	select {
+	case keys := <-resultChan:
+		response := make([]*data.ApiKeyResponse, len(keys))
+		for i := range keys {
+			response[i] = keys[i].MapToResponseModel()
+		}
+		gc.JSON(200, response)
+	case err := <-errChan:
+		gc.Error(err)
+	}
+}
+
+// RevokeHandler Revokes an API key
+//
+//	@Summary	stops accepting an API key
+//	@Tags		apikeys
+//	@Accept		json
+//	@Produce	json
+//	@Security	OAuth2AccessCode || ApiKeyAuth
+//	@Param		id	path		string				true	"API key ID"
+//	@Success	200	{object}	data.ApiKeyResponse	"Revoked key"
+//	@Failure	400	{object}	errors.HTTPError
+//	@Failure	404	{object}	errors.HTTPError	"Key not found or already revoked"
+//	@Router		/apikeys/{id} [delete]
+func (controller *ApiKeyController) RevokeHandler(gc *gin.Context) {
+	resultChan := make(chan *data.ApiKeyDocument)
+	errChan := make(chan error)
+
+	defer close(resultChan)
+	defer close(errChan)
+
+	go controller.Repository.Revoke(gc, gc.Param("id"), resultChan, errChan)
+
+	select {
+	case revoked := <-resultChan:
+		if revoked == nil {
+			gc.Error(domainErrors.NewNotFoundError("ApiKey", gc.Param("id")))
+			return
+		}
+		controller.Logger.Info("API key revoked", zap.String("id", revoked.Id.Hex()), zap.String("by", gc.GetString("user")))
+		gc.JSON(200, revoked.MapToResponseModel())
+	case err := <-errChan:
+		gc.Error(err)
+	}
+}
+
+func newApiKey() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return "gk_" + base64.RawURLEncoding.EncodeToString(secret), nil
+}
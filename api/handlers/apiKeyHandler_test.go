@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steadfastie/gokube/data"
+	domainErrors "github.com/steadfastie/gokube/data/errors"
+	"github.com/steadfastie/gokube/data/repositories"
+	"go.uber.org/zap"
+)
+
+type stubApiKeys struct {
+	repositories.ApiKeyRepository
+	created []*data.ApiKeyDocument
+}
+
+func (repo *stubApiKeys) Create(ctx context.Context, key *data.ApiKeyDocument, resultChan chan<- *data.ApiKeyDocument, errChan chan<- error) {
+	repo.created = append(repo.created, key)
+	resultChan <- key
+}
+
+func TestCreateApiKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		body    string
+		scopes  []string
+		subject string
+		err     string
+	}{
+		{"own key", `{"name":"x","scopes":["read:counter"]}`, []string{"read:counter"}, "apikey|", ""},
+		{"own subject", `{"name":"x","scopes":["read:counter"],"subject":"auth0|issuer"}`, []string{"read:counter"}, "auth0|issuer", ""},
+		{"own groups", `{"name":"x","scopes":["read:counter"],"groups":["team-a"]}`, []string{"read:counter"}, "apikey|", ""},
+		{"scope the caller doesn't have", `{"name":"x","scopes":["update:counter"]}`, []string{"read:counter"}, "", "scope update:counter"},
+		{"another subject", `{"name":"x","scopes":["read:counter"],"subject":"auth0|victim"}`, []string{"read:counter"}, "", "another subject"},
+		{"another subject when impersonating", `{"name":"x","scopes":["read:counter"],"subject":"svc|batch"}`, []string{"read:counter", data.ApiKeyImpersonateScope}, "svc|batch", ""},
+		{"group the caller isn't a member of", `{"name":"x","scopes":["read:counter"],"groups":["team-a","admins"]}`, []string{"read:counter"}, "", "group admins"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := &stubApiKeys{}
+			controller := &ApiKeyController{Repository: repo, Logger: zap.NewNop()}
+
+			var errs []*gin.Error
+			router := gin.New()
+			router.POST("/api/apikeys", func(c *gin.Context) {
+				granted := map[string]struct{}{}
+				for _, scope := range test.scopes {
+					granted[scope] = struct{}{}
+				}
+				c.Set("user", "auth0|issuer")
+				c.Set("groups", []string{"team-a"})
+				c.Set("scopes", granted)
+				c.Next()
+				errs = c.Errors
+			}, controller.CreateHandler)
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/apikeys", strings.NewReader(test.body)))
+
+			if test.err != "" {
+				var businessRule *domainErrors.BusinessRuleError
+				if len(errs) != 1 || !errors.As(errs[0].Err, &businessRule) || !strings.Contains(businessRule.Details, test.err) {
+					t.Fatalf("errors = %v, want a business rule violation about %s", errs, test.err)
+				}
+				if len(repo.created) != 0 {
+					t.Errorf("key was issued")
+				}
+				return
+			}
+
+			if recorder.Code != http.StatusOK || len(repo.created) != 1 {
+				t.Fatalf("status = %d, errors = %v, want the key issued", recorder.Code, errs)
+			}
+			var response data.CreatedApiKeyResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(response.Subject, test.subject) {
+				t.Errorf("subject = %q, want %q", response.Subject, test.subject)
+			}
+			if groups := repo.created[0].Groups; len(groups) > 0 && !slices.Equal(groups, []string{"team-a"}) {
+				t.Errorf("groups = %v, want only the caller's", groups)
+			}
+		})
+	}
+}
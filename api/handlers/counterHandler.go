@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"errors"
+
 	"github.com/gin-gonic/gin"
 	"github.com/steadfastie/gokube/data"
-	_ "github.com/steadfastie/gokube/data/errors"
+	domainErrors "github.com/steadfastie/gokube/data/errors"
 	"github.com/steadfastie/gokube/data/repositories"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
@@ -20,7 +22,7 @@ type CounterController struct {
 //	@Tags		counter
 //	@Accept		json
 //	@Produce	json
-//	@Security	OAuth2AccessCode
+//	@Security	OAuth2AccessCode || ApiKeyAuth
 //	@Param		id	path		string					true	"Counter ID"
 //	@Success	200	{object}	data.CounterResponse	"Requested counter"
 //	@Failure	400	{object}	errors.HTTPError
@@ -39,7 +41,7 @@ func (controller *CounterController) GetByIdHandler(gc *gin.Context) {
 	case foundCounter := <-resultChan:
 		gc.JSON(200, foundCounter.MapToResponseModel())
 	case err := <-errChan:
-		gc.JSON(400, err)
+		gc.Error(err)
 	}
 }
 
@@ -49,7 +51,7 @@ func (controller *CounterController) GetByIdHandler(gc *gin.Context) {
 //	@Tags		counter
 //	@Accept		json
 //	@Produce	json
-//	@Security	OAuth2AccessCode
+//	@Security	OAuth2AccessCode || ApiKeyAuth
 //	@Success	200	{string}	id	"ID of the created counter object"
 //	@Failure	400	{object}	errors.HTTPError
 //	@Failure	404	{object}	errors.HTTPError	"Counter not found"
@@ -67,7 +69,7 @@ func (controller *CounterController) CreateHandler(gc *gin.Context) {
 	case resultID := <-resultChan:
 		gc.JSON(200, resultID.Hex())
 	case err := <-errChan:
-		gc.JSON(400, err)
+		gc.Error(err)
 	}
 }
 
@@ -77,18 +79,22 @@ func (controller *CounterController) CreateHandler(gc *gin.Context) {
 //	@Tags		counter
 //	@Accept		json
 //	@Produce	json
-//	@Security	OAuth2AccessCode
+//	@Security	OAuth2AccessCode || ApiKeyAuth
 //	@Param		id		path		string						true	"Counter ID"
 //	@Param		patch	body		data.PatchModel				true	"Describe your desires"
 //	@Success	200		{object}	data.PatchCounterResponse	"ID of the created counter object"
 //	@Failure	400		{object}	errors.HTTPError
 //	@Failure	404		{object}	errors.HTTPError	"Counter not found"
+//	@Failure	429		"Too many patches, see the Retry-After header"
+//	@Header		all		{integer}	RateLimit-Remaining	"Requests left until the limit"
+//	@Header		429		{integer}	Retry-After			"Seconds until the next request is allowed"
 //	@Router		/counter/{id} [patch]
 func (controller *CounterController) PatchHandler(gc *gin.Context) {
 	var patchModel data.PatchModel
 
 	if err := gc.ShouldBindJSON(&patchModel); err != nil {
-		gc.JSON(400, err)
+		gc.Error(err).SetType(gin.ErrorTypeBind)
+		return
 	}
 
 	resultChan := make(chan *data.PatchCounterResponse)
@@ -103,6 +109,158 @@ func (controller *CounterController) PatchHandler(gc *gin.Context) {
 	case patchResult := <-resultChan:
 		gc.JSON(200, patchResult)
 	case err := <-errChan:
-		gc.JSON(400, err)
+		gc.Error(err)
+	}
+}
+
+// GetAccessHandler Lists who can access a counter
+//
+//	@Summary	lists the owner and the grants of a counter
+//	@Tags		counter
+//	@Accept		json
+//	@Produce	json
+//	@Security	OAuth2AccessCode || ApiKeyAuth
+//	@Param		id	path		string						true	"Counter ID"
+//	@Success	200	{object}	data.CounterAccessResponse	"Owner and grants"
+//	@Failure	400	{object}	errors.HTTPError
+//	@Failure	404	{object}	errors.HTTPError	"Counter not found or the caller is not its admin"
+//	@Router		/counter/{id}/access [get]
+func (controller *CounterController) GetAccessHandler(gc *gin.Context) {
+	resultChan := make(chan *data.CounterAccessResponse)
+	errChan := make(chan error)
+
+	defer close(resultChan)
+	defer close(errChan)
+
+	go controller.Repository.GetAccess(gc, gc.Param("id"), resultChan, errChan)
+
+	select {
+	case access := <-resultChan:
+		if access == nil {
+			gc.Error(domainErrors.NewNotFoundError("Counter", gc.Param("id")))
+			return
+		}
+		gc.JSON(200, access)
+	case err := <-errChan:
+		gc.Error(err)
+	}
+}
+
+// GrantAccessHandler Shares a counter
+//
+//	@Summary		grants a user or a group access to a counter
+//	@Description	Replaces the previous grant of the same user or group. Write access includes read, admin includes write and sharing
+//	@Tags			counter
+//	@Accept			json
+//	@Produce		json
+//	@Security		OAuth2AccessCode || ApiKeyAuth
+//	@Param			id		path		string					true	"Counter ID"
+//	@Param			grant	body		data.GrantAccessRequest	true	"Who gets which access"
+//	@Success		200		{object}	data.CounterAccessResponse	"Owner and grants"
+//	@Failure		400		{object}	errors.HTTPError
+//	@Failure		404		{object}	errors.HTTPError	"Counter not found or the caller is not its admin"
+//	@Failure		409		{object}	errors.HTTPError	"Access keeps changing concurrently"
+//	@Failure		422		{object}	errors.HTTPError	"The owner can't be granted access"
+//	@Router			/counter/{id}/access [put]
+func (controller *CounterController) GrantAccessHandler(gc *gin.Context) {
+	var request data.GrantAccessRequest
+	if err := gc.ShouldBindJSON(&request); err != nil {
+		gc.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	resultChan := make(chan *data.CounterAccessResponse)
+	errChan := make(chan error)
+
+	defer close(resultChan)
+	defer close(errChan)
+
+	go controller.Repository.Grant(gc, gc.Param("id"), &request, resultChan, errChan)
+
+	select {
+	case access := <-resultChan:
+		if access == nil {
+			gc.Error(domainErrors.NewNotFoundError("Counter", gc.Param("id")))
+			return
+		}
+		gc.JSON(200, access)
+	case err := <-errChan:
+		gc.Error(accessError(err))
+	}
+}
+
+// RevokeAccessHandler Stops sharing a counter
+//
+//	@Summary	revokes the access of a user or a group to a counter
+//	@Tags		counter
+//	@Accept		json
+//	@Produce	json
+//	@Security	OAuth2AccessCode || ApiKeyAuth
+//	@Param		id			path		string	true	"Counter ID"
+//	@Param		kind		path		string	true	"Principal kind"	Enums(user, group)
+//	@Param		principal	path		string	true	"Subject or group name"
+//	@Success	200			{object}	data.CounterAccessResponse	"Owner and grants"
+//	@Failure	400			{object}	errors.HTTPError
+//	@Failure	404			{object}	errors.HTTPError	"Counter or grant not found"
+//	@Failure	409			{object}	errors.HTTPError	"Access keeps changing concurrently"
+//	@Router		/counter/{id}/access/{kind}/{principal} [delete]
+func (controller *CounterController) RevokeAccessHandler(gc *gin.Context) {
+	kind := data.PrincipalKind(gc.Param("kind"))
+	if kind != data.UserPrincipal && kind != data.GroupPrincipal {
+		gc.Error(domainErrors.NewBusinessRuleError("Principal kind must be user or group"))
+		return
+	}
+
+	resultChan := make(chan *data.CounterAccessResponse)
+	errChan := make(chan error)
+
+	defer close(resultChan)
+	defer close(errChan)
+
+	go controller.Repository.Revoke(gc, gc.Param("id"), kind, gc.Param("principal"), resultChan, errChan)
+
+	select {
+	case access := <-resultChan:
+		if access == nil {
+			gc.Error(domainErrors.NewNotFoundError("AccessGrant", gc.Param("kind")+":"+gc.Param("principal")))
+			return
+		}
+		gc.JSON(200, access)
+	case err := <-errChan:
+		gc.Error(accessError(err))
+	}
+}
+
+// canAccessCounter hides counters the caller has no access to behind the same answer as missing ones
+func canAccessCounter(gc *gin.Context, counters repositories.CounterRepository, id string, access data.AccessLevel) (bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, nil
+	}
+
+	resultChan := make(chan bool)
+	errChan := make(chan error)
+
+	defer close(resultChan)
+	defer close(errChan)
+
+	go counters.CanAccess(gc, objectID, access, resultChan, errChan)
+
+	select {
+	case allowed := <-resultChan:
+		return allowed, nil
+	case err := <-errChan:
+		return false, err
+	}
+}
+
+func accessError(err error) error {
+	switch {
+	case errors.Is(err, repositories.ErrOwnerGrant):
+		return domainErrors.NewBusinessRuleError(err.Error())
+	case errors.Is(err, repositories.ErrAccessBusy):
+		return domainErrors.NewOptimisticLockError(err.Error())
+	default:
+		return err
 	}
 }
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	domainErrors "github.com/steadfastie/gokube/data/errors"
+)
+
+const maxDevTokenTTL = 24 * time.Hour
+
+// TokenMinter issues tokens the API accepts. Only the dev auth provider implements it
+type TokenMinter interface {
+	Mint(subject string, tenant string, scopes []string, groups []string, ttl time.Duration) (string, time.Time, error)
+}
+
+type DevTokenRequest struct {
+	Subject string   `json:"subject" binding:"required" example:"dev|alice"`
+	Scopes  []string `json:"scopes" example:"read:counter,create:counter,update:counter"`
+	Groups  []string `json:"groups" example:"team-a"`
+	Tenant  string   `json:"tenant" example:"acme"`
+	// Defaults to an hour, at most a day
+	TTLSeconds int `json:"ttlSeconds" example:"3600"`
+}
+
+type DevTokenResponse struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type" example:"Bearer"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+type DevTokenController struct {
+	Minter TokenMinter `container:"type"`
+}
+
+// MintHandler Issues a token for local development
+//
+//	@Summary		mints a token for any subject and scopes
+//	@Description	Only available with AUTH_PROVIDER=dev, which the API refuses in production
+//	@Tags			dev
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		handlers.DevTokenRequest	true	"Who the token is for"
+//	@Success		200		{object}	handlers.DevTokenResponse
+//	@Failure		400		{object}	errors.HTTPError
+//	@Failure		422		{object}	errors.HTTPError	"TTL is out of range"
+//	@Router			/dev/token [post]
+func (controller *DevTokenController) MintHandler(gc *gin.Context) {
+	var request DevTokenRequest
+	if err := gc.ShouldBindJSON(&request); err != nil {
+		gc.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	ttl := time.Hour
+	if request.TTLSeconds != 0 {
+		ttl = time.Duration(request.TTLSeconds) * time.Second
+	}
+	if ttl <= 0 || ttl > maxDevTokenTTL {
+		gc.Error(domainErrors.NewBusinessRuleError("TTL must be between a second and a day"))
+		return
+	}
+
+	token, expiresAt, err := controller.Minter.Mint(request.Subject, request.Tenant, request.Scopes, request.Groups, ttl)
+	if err != nil {
+		gc.Error(err)
+		return
+	}
+	gc.JSON(200, DevTokenResponse{AccessToken: token, TokenType: "Bearer", ExpiresAt: expiresAt})
+}
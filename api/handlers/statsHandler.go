@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steadfastie/gokube/data"
+	domainErrors "github.com/steadfastie/gokube/data/errors"
+	"github.com/steadfastie/gokube/data/repositories"
+	"go.uber.org/zap"
+)
+
+// How many days the daily stats cover when no range is requested
+const defaultDailyStatsRange = 30
+
+type StatsController struct {
+	Repository repositories.StatsRepository   `container:"type"`
+	Counters   repositories.CounterRepository `container:"type"`
+	Logger     *zap.Logger                    `container:"type"`
+}
+
+// GetCounterStatsHandler Gets statistics of a counter
+//
+//	@Summary	retrieves counter statistics projected from its events
+//	@Tags		stats
+//	@Accept		json
+//	@Produce	json
+//	@Security	OAuth2AccessCode || ApiKeyAuth
+//	@Param		id	path		string						true	"Counter ID"
+//	@Success	200	{object}	data.CounterStatsResponse	"Counter statistics"
+//	@Failure	400	{object}	errors.HTTPError
+//	@Failure	404	{object}	errors.HTTPError	"No statistics for the counter"
+//	@Router		/counter/{id}/stats [get]
+func (controller *StatsController) GetCounterStatsHandler(gc *gin.Context) {
+	allowed, err := canAccessCounter(gc, controller.Counters, gc.Param("id"), data.ReadAccess)
+	if err != nil {
+		gc.Error(err)
+		return
+	}
+	if !allowed {
+		gc.Error(domainErrors.NewNotFoundError("CounterStats", gc.Param("id")))
+		return
+	}
+
+	resultChan := make(chan *data.CounterStatsDocument)
+	errChan := make(chan error)
+
+	defer close(resultChan)
+	defer close(errChan)
+
+	go controller.Repository.GetByCounterId(gc, gc.Param("id"), resultChan, errChan)
+
+	select {
+	case stats := <-resultChan:
+		if stats == nil {
+			gc.Error(domainErrors.NewNotFoundError("CounterStats", gc.Param("id")))
+			return
+		}
+		gc.JSON(200, stats.MapToResponseModel())
+	case err := <-errChan:
+		gc.Error(err)
+	}
+}
+
+// GetDailyStatsHandler Gets daily statistics
+//
+//	@Summary	retrieves daily numbers of created and updated counters
+//	@Tags		stats
+//	@Accept		json
+//	@Produce	json
+//	@Security	OAuth2AccessCode || ApiKeyAuth
+//	@Param		query	query		data.DailyStatsQuery		false	"Inclusive UTC day range, defaults to the last 30 days"
+//	@Success	200		{array}		data.DailyStatsResponse	"Daily statistics"
+//	@Failure	400		{object}	errors.HTTPError
+//	@Router		/stats/daily [get]
+func (controller *StatsController) GetDailyStatsHandler(gc *gin.Context) {
+	var query data.DailyStatsQuery
+	if err := gc.ShouldBindQuery(&query); err != nil {
+		gc.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	to := time.Now().UTC()
+	if query.To != "" {
+		parsed, err := time.Parse(data.DayLayout, query.To)
+		if err != nil {
+			gc.Error(domainErrors.NewBusinessRuleError("Query parameter to must look like 2006-01-02"))
+			return
+		}
+		to = parsed
+	}
+
+	from := to.AddDate(0, 0, -defaultDailyStatsRange)
+	if query.From != "" {
+		parsed, err := time.Parse(data.DayLayout, query.From)
+		if err != nil {
+			gc.Error(domainErrors.NewBusinessRuleError("Query parameter from must look like 2006-01-02"))
+			return
+		}
+		from = parsed
+	}
+
+	resultChan := make(chan []data.DailyStatsDocument)
+	errChan := make(chan error)
+
+	defer close(resultChan)
+	defer close(errChan)
+
+	go controller.Repository.GetDaily(gc, from, to, resultChan, errChan)
+
+	select {
+	case days := <-resultChan:
+		response := make([]*data.DailyStatsResponse, len(days))
+		for i := range days {
+			response[i] = days[i].MapToResponseModel()
+		}
+		gc.JSON(200, response)
+	case err := <-errChan:
+		gc.Error(err)
+	}
+}
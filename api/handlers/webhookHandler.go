@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steadfastie/gokube/data"
+	domainErrors "github.com/steadfastie/gokube/data/errors"
+	"github.com/steadfastie/gokube/data/repositories"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+type WebhookController struct {
+	Repository repositories.WebhookRepository `container:"type"`
+	Counters   repositories.CounterRepository `container:"type"`
+	Logger     *zap.Logger                    `container:"type"`
+}
+
+// CreateHandler Registers a webhook subscription
+//
+//	@Summary	subscribes an URL to counter events, scoped to a counter id, an event type or both
+//	@Tags		webhooks
+//	@Accept		json
+//	@Produce	json
+//	@Security	OAuth2AccessCode || ApiKeyAuth
+//	@Param		webhook	body		data.WebhookRequest				true	"Where and what to deliver"
+//	@Success	200		{object}	data.CreatedWebhookResponse	"Subscription along with its signing secret, shown only once"
+//	@Failure	400		{object}	errors.HTTPError
+//	@Failure	404		{object}	errors.HTTPError	"Counter not found"
+//	@Failure	422		{object}	errors.HTTPError	"Subscription has no scope or its URL is not public"
+//	@Router		/webhooks [post]
+func (controller *WebhookController) CreateHandler(gc *gin.Context) {
+	var request data.WebhookRequest
+	if err := gc.ShouldBindJSON(&request); err != nil {
+		gc.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	if err := data.CheckWebhookUrl(gc, net.DefaultResolver, request.Url); err != nil {
+		gc.Error(domainErrors.NewBusinessRuleError(err.Error()))
+		return
+	}
+	if request.CounterId == "" && request.EventType == "" {
+		gc.Error(domainErrors.NewBusinessRuleError("Subscription must be scoped to a counter id or an event type"))
+		return
+	}
+	if request.EventType != "" && request.EventType != data.CounterCreated && request.EventType != data.CounterUpdated {
+		gc.Error(domainErrors.NewBusinessRuleError("Event type must be either Created or Updated"))
+		return
+	}
+
+	var counterId *primitive.ObjectID
+	if request.CounterId != "" {
+		objectID, err := primitive.ObjectIDFromHex(request.CounterId)
+		if err != nil {
+			gc.Error(err)
+			return
+		}
+		allowed, err := canAccessCounter(gc, controller.Counters, request.CounterId, data.ReadAccess)
+		if err != nil {
+			gc.Error(err)
+			return
+		}
+		if !allowed {
+			gc.Error(domainErrors.NewNotFoundError("Counter", request.CounterId))
+			return
+		}
+		counterId = &objectID
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		gc.Error(err)
+		return
+	}
+	webhook := data.NewWebhookDocument(request.Url, secret, counterId, request.EventType, gc.GetString("user"), gc.GetStringSlice("groups"), data.TenantOrDefault(gc), time.Now().UTC())
+
+	resultChan := make(chan *data.WebhookDocument)
+	errChan := make(chan error)
+
+	defer close(resultChan)
+	defer close(errChan)
+
+	go controller.Repository.Create(gc, webhook, resultChan, errChan)
+
+	select {
+	case created := <-resultChan:
+		gc.JSON(200, created.MapToCreatedResponseModel())
+	case err := <-errChan:
+		gc.Error(err)
+	}
+}
+
+// ListHandler Lists webhook subscriptions
+//
+//	@Summary	lists webhook subscriptions created by the caller
+//	@Tags		webhooks
+//	@Accept		json
+//	@Produce	json
+//	@Security	OAuth2AccessCode || ApiKeyAuth
+//	@Success	200	{array}		data.WebhookResponse	"Subscriptions"
+//	@Failure	400	{object}	errors.HTTPError
+//	@Router		/webhooks [get]
+func (controller *WebhookController) ListHandler(gc *gin.Context) {
+	resultChan := make(chan []data.WebhookDocument)
+	errChan := make(chan error)
+
+	defer close(resultChan)
+	defer close(errChan)
+
+	go controller.Repository.List(gc, resultChan, errChan)
+
+	select {
+	case webhooks := <-resultChan:
+		response := make([]*data.WebhookResponse, len(webhooks))
+		for i := range webhooks {
+			response[i] = webhooks[i].MapToResponseModel()
+		}
+		gc.JSON(200, response)
+	case err := <-errChan:
+		gc.Error(err)
+	}
+}
+
+// DeleteHandler Deletes a webhook subscription
+//
+//	@Summary	stops delivering events to the subscription
+//	@Tags		webhooks
+//	@Accept		json
+//	@Produce	json
+//	@Security	OAuth2AccessCode || ApiKeyAuth
+//	@Param		id	path	string	true	"Subscription ID"
+//	@Success	204
+//	@Failure	400	{object}	errors.HTTPError
+//	@Failure	404	{object}	errors.HTTPError	"Subscription not found"
+//	@Router		/webhooks/{id} [delete]
+func (controller *WebhookController) DeleteHandler(gc *gin.Context) {
+	resultChan := make(chan bool)
+	errChan := make(chan error)
+
+	defer close(resultChan)
+	defer close(errChan)
+
+	go controller.Repository.Delete(gc, gc.Param("id"), resultChan, errChan)
+
+	select {
+	case deleted := <-resultChan:
+		if !deleted {
+			gc.Error(domainErrors.NewNotFoundError("Webhook", gc.Param("id")))
+			return
+		}
+		gc.Status(204)
+	case err := <-errChan:
+		gc.Error(err)
+	}
+}
+
+// ListDeliveriesHandler Lists deliveries of a webhook subscription
+//
+//	@Summary	retrieves the latest delivery attempts of the subscription
+//	@Tags		webhooks
+//	@Accept		json
+//	@Produce	json
+//	@Security	OAuth2AccessCode || ApiKeyAuth
+//	@Param		id	path		string					true	"Subscription ID"
+//	@Success	200	{array}		data.WebhookDelivery	"Latest deliveries first"
+//	@Failure	400	{object}	errors.HTTPError
+//	@Failure	404	{object}	errors.HTTPError	"Subscription not found"
+//	@Router		/webhooks/{id}/deliveries [get]
+func (controller *WebhookController) ListDeliveriesHandler(gc *gin.Context) {
+	resultChan := make(chan []data.WebhookDelivery)
+	errChan := make(chan error)
+
+	defer close(resultChan)
+	defer close(errChan)
+
+	go controller.Repository.ListDeliveries(gc, gc.Param("id"), resultChan, errChan)
+
+	select {
+	case deliveries := <-resultChan:
+		if deliveries == nil {
+			gc.Error(domainErrors.NewNotFoundError("Webhook", gc.Param("id")))
+			return
+		}
+		gc.JSON(200, deliveries)
+	case err := <-errChan:
+		gc.Error(err)
+	}
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
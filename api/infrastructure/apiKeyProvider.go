@@ -0,0 +1,51 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/steadfastie/gokube/data"
+	"github.com/steadfastie/gokube/data/repositories"
+)
+
+const ApiKeyHeader = "X-API-Key"
+
+var errInvalidApiKey = errors.New("API key is unknown, expired or revoked")
+
+// apiKeyProvider turns an API key into the claims a token of its subject would carry
+type apiKeyProvider struct {
+	Keys repositories.ApiKeyRepository
+}
+
+func NewApiKeyProvider(keys repositories.ApiKeyRepository) AuthProvider {
+	return &apiKeyProvider{Keys: keys}
+}
+
+func (provider *apiKeyProvider) Verify(ctx context.Context, key string) (*Claims, error) {
+	document, err := provider.Keys.Authenticate(ctx, data.HashApiKey(key))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errAuthUnavailable, err)
+	}
+	// Repositories only return active keys, but one may expire or be revoked while it is cached or replicated
+	if document == nil || !document.Active(time.Now().UTC()) {
+		return nil, errInvalidApiKey
+	}
+
+	claims := &Claims{
+		Scope:  strings.Join(document.Scopes, " "),
+		Groups: document.Groups,
+		Tenant: document.Tenant,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: document.Subject,
+			ID:      document.Id.Hex(),
+		},
+	}
+	if document.ExpiresAt != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(*document.ExpiresAt)
+	}
+	return claims, nil
+}
@@ -0,0 +1,99 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/steadfastie/gokube/data"
+	"github.com/steadfastie/gokube/data/repositories"
+)
+
+// stubApiKeys looks keys up by hash only, like the collection does, and leaves the rest to the provider
+type stubApiKeys struct {
+	repositories.ApiKeyRepository
+	keys map[string]*data.ApiKeyDocument
+	err  error
+}
+
+func (repo stubApiKeys) Authenticate(ctx context.Context, hash string) (*data.ApiKeyDocument, error) {
+	return repo.keys[hash], repo.err
+}
+
+const testApiKey = "gk_q3Zt9xW2hY0mR7cV1bN4kP8sD6fG5jL3aQ2wE1rT0yU"
+
+func TestApiKeyProvider(t *testing.T) {
+	now := time.Now().UTC()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	request := &data.ApiKeyRequest{Name: "nightly-export", Scopes: []string{"read:counter", "update:counter"}, Subject: "svc|batch", Groups: []string{"team-a"}}
+	active := data.NewApiKeyDocument(testApiKey, request, "auth0|admin", "acme", now)
+	expiring := *active
+	expiring.ExpiresAt = &future
+	expired := *active
+	expired.ExpiresAt = &past
+	revoked := *active
+	revoked.RevokedAt = &past
+
+	tests := []struct {
+		name     string
+		key      string
+		document *data.ApiKeyDocument
+		err      error
+	}{
+		{"active key", testApiKey, active, nil},
+		{"key expiring later", testApiKey, &expiring, nil},
+		{"expired key", testApiKey, &expired, errInvalidApiKey},
+		{"revoked key", testApiKey, &revoked, errInvalidApiKey},
+		{"wrong secret", testApiKey[:len(testApiKey)-1] + "V", active, errInvalidApiKey},
+		{"prefix only", active.Prefix, active, errInvalidApiKey},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := NewApiKeyProvider(stubApiKeys{keys: map[string]*data.ApiKeyDocument{test.document.Hash: test.document}})
+
+			claims, err := provider.Verify(context.Background(), test.key)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+			if test.err != nil {
+				return
+			}
+			if claims.Subject != "svc|batch" || claims.Scope != "read:counter update:counter" || claims.Tenant != "acme" || claims.ID != active.Id.Hex() {
+				t.Errorf("claims = %+v, want those of the key", claims)
+			}
+			if !slices.Equal(claims.Groups, []string{"team-a"}) {
+				t.Errorf("groups = %v, want [team-a]", claims.Groups)
+			}
+			if (claims.ExpiresAt == nil) != (test.document.ExpiresAt == nil) {
+				t.Errorf("expiry = %v, want %v", claims.ExpiresAt, test.document.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestApiKeyHash(t *testing.T) {
+	document := data.NewApiKeyDocument(testApiKey, &data.ApiKeyRequest{Name: "x", Scopes: []string{"read:counter"}}, "auth0|admin", "acme", time.Now())
+
+	if document.Hash == testApiKey || document.Hash != data.HashApiKey(testApiKey) {
+		t.Errorf("hash = %q, want the hash of the key and not the key", document.Hash)
+	}
+	if data.HashApiKey(testApiKey+"x") == document.Hash {
+		t.Error("different keys have the same hash")
+	}
+	if document.Prefix != "gk_q3Zt9xW2" {
+		t.Errorf("prefix = %q, want gk_q3Zt9xW2", document.Prefix)
+	}
+}
+
+func TestApiKeyStoreDown(t *testing.T) {
+	provider := NewApiKeyProvider(stubApiKeys{err: errors.New("connection refused")})
+
+	if _, err := provider.Verify(context.Background(), testApiKey); !errors.Is(err, errAuthUnavailable) {
+		t.Errorf("err = %v, want %v", err, errAuthUnavailable)
+	}
+}
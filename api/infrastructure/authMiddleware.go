@@ -2,105 +2,125 @@ package infrastructure
 
 import (
 	"encoding/json"
-	"io"
-	"net/http"
+	"errors"
+	"fmt"
 	"strings"
 
-	"github.com/MicahParks/keyfunc/v3"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/golobby/container/v3"
+	"github.com/steadfastie/gokube/data"
+	"github.com/steadfastie/gokube/data/repositories"
 )
 
 const (
-	ReadCounterScope   = "read:counter"
-	CreateCounterScope = "create:counter"
-	UpdateCounterScope = "update:counter"
+	ReadCounterScope    = "read:counter"
+	CreateCounterScope  = "create:counter"
+	UpdateCounterScope  = "update:counter"
+	ShareCounterScope   = "share:counter"
+	ManageWebhooksScope = "manage:webhooks"
+	ManageApiKeysScope  = "manage:apikeys"
+	// Not required by any route, checked when a key is issued for another subject
+	ImpersonateApiKeysScope = data.ApiKeyImpersonateScope
 )
 
+// errAuthUnavailable means credentials can't be checked right now, rather than that they are wrong
+var errAuthUnavailable = errors.New("authentication is unavailable")
+
 type Claims struct {
 	Scope string `json:"scope"`
+	// Auth0 RBAC puts the API permissions of the user here
+	Permissions []string `json:"permissions,omitempty"`
+	// Counters shared with a group are available to its members
+	Groups []string `json:"groups,omitempty"`
+	// Read from the claim AUTH_TENANT_CLAIM names, or the tenant of the API key
+	Tenant string `json:"-"`
 	jwt.RegisteredClaims
+
+	raw map[string]any
 }
 
-func AuthMiddleware(requiredScopes ...string) gin.HandlerFunc {
+// UnmarshalJSON keeps every claim around, so that custom claims can be looked up by name
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	type plain Claims
+	if err := json.Unmarshal(data, (*plain)(c)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &c.raw)
+}
+
+// AuthMiddleware authenticates requests with a bearer token or an API key
+// and authorizes them with the policy configured for the matched route
+func AuthMiddleware() gin.HandlerFunc {
+	var provider AuthProvider
+	container.Resolve(&provider)
+	var keys repositories.ApiKeyRepository
+	container.Resolve(&keys)
 	var config *Config
 	container.Resolve(&config)
 
+	return authMiddleware(provider, NewApiKeyProvider(keys), config.Auth)
+}
+
+func authMiddleware(tokens AuthProvider, apiKeys AuthProvider, settings AuthSettings) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authorizationHeader := c.GetHeader("Authorization")
-		if authorizationHeader == "" {
-			c.AbortWithStatus(http.StatusUnauthorized)
+		provider, credentials := tokens, strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if key := c.GetHeader(ApiKeyHeader); key != "" {
+			provider, credentials = apiKeys, key
+		}
+		if credentials == "" {
+			c.Error(errUnauthenticated)
+			c.Abort()
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authorizationHeader, "Bearer ")
-
-		jwksJSON := retrievePublicKey(c, config.Auth.Domain)
-
-		k, err := keyfunc.NewJWKSetJSON(jwksJSON)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Message": "Could not retrieve auth public key"})
+		claims, err := provider.Verify(c.Request.Context(), credentials)
+		if errors.Is(err, errAuthUnavailable) {
+			c.Error(err)
+			c.Abort()
+			return
 		}
-
-		token, err := jwt.ParseWithClaims(
-			tokenString,
-			&Claims{},
-			k.Keyfunc,
-			jwt.WithIssuer("https://"+config.Auth.Domain+"/"),
-			jwt.WithAudience(config.Auth.Audience),
-			jwt.WithValidMethods([]string{"RS256"}),
-		)
-
-		if err != nil || !token.Valid {
-			c.AbortWithStatus(http.StatusUnauthorized)
+		if err != nil {
+			c.Error(fmt.Errorf("%w: %v", errUnauthenticated, err))
+			c.Abort()
 			return
 		}
 
-		claims, ok := token.Claims.(*Claims)
-		if !ok {
-			c.AbortWithStatus(http.StatusUnauthorized)
+		tenant := claims.Tenant
+		if tenant == "" && !settings.TenantRequired {
+			tenant = data.DefaultTenant
+		}
+		if !data.ValidTenant(tenant) {
+			c.Error(fmt.Errorf("%w: tenant %q is invalid", errForbidden, tenant))
+			c.Abort()
 			return
 		}
 
-		if claims.HasScopes(requiredScopes...) {
+		if settings.Policies.Authorize(routeKey(c.Request.Method, c.FullPath()), claims) {
 			c.Set("user", claims.Subject)
+			c.Set("groups", claims.Groups)
+			c.Set("scopes", claims.Granted())
+			c.Set(data.TenantKey, tenant)
+			if provider == apiKeys {
+				// Keys of the same subject are rate limited apart
+				c.Set("apiKey", claims.ID)
+			}
 			c.Next()
 		} else {
-			c.AbortWithStatus(http.StatusForbidden)
+			c.Error(errForbidden)
+			c.Abort()
 		}
 	}
 }
 
-func (c Claims) HasScopes(expectedScopes ...string) bool {
-	result := strings.Fields(c.Scope)
-	for _, expectedScope := range expectedScopes {
-		for _, scope := range result {
-			if scope == expectedScope {
-				return true
-			}
-		}
+// Granted merges the scope and permissions claims
+func (c Claims) Granted() map[string]struct{} {
+	granted := map[string]struct{}{}
+	for _, scope := range strings.Fields(c.Scope) {
+		granted[scope] = struct{}{}
 	}
-	return false
-}
-
-func retrievePublicKey(c *gin.Context, domain string) json.RawMessage {
-	serverUrl := "https://" + domain + "/.well-known/jwks.json"
-	resp, err := http.Get(serverUrl)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"Message": "Auth server connectivity malfucntion"})
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Message": "Auth server unexpected behavior"})
+	for _, permission := range c.Permissions {
+		granted[permission] = struct{}{}
 	}
-
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Message": "Auth server connectivity malfucntion"})
-	}
-
-	return json.RawMessage(body)
+	return granted
 }
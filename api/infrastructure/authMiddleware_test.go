@@ -0,0 +1,132 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/steadfastie/gokube/data"
+	"go.uber.org/zap"
+)
+
+func TestApiKeyAuthentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tokens := stubProvider{claims: &Claims{Scope: "read:counter update:counter manage:apikeys"}}
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		apiKey        string
+		apiKeys       stubProvider
+		status        int
+	}{
+		{"get counter", http.MethodGet, "/api/counter/1", "", "gk_x", stubProvider{claims: keyClaims("read:counter")}, http.StatusOK},
+		{"patch counter with read key", http.MethodPatch, "/api/counter/1", "", "gk_x", stubProvider{claims: keyClaims("read:counter")}, http.StatusForbidden},
+		{"unknown key", http.MethodGet, "/api/counter/1", "", "gk_x", stubProvider{err: errInvalidApiKey}, http.StatusUnauthorized},
+		{"key store down", http.MethodGet, "/api/counter/1", "", "gk_x", stubProvider{err: errAuthUnavailable}, http.StatusServiceUnavailable},
+		{"key wins over token", http.MethodPatch, "/api/counter/1", "Bearer x", "gk_x", stubProvider{claims: keyClaims("read:counter")}, http.StatusForbidden},
+		{"token without key", http.MethodPatch, "/api/counter/1", "Bearer x", "", stubProvider{err: errInvalidApiKey}, http.StatusOK},
+		{"issue API key with key", http.MethodPost, "/api/apikeys", "", "gk_x", stubProvider{claims: keyClaims("manage:apikeys")}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var subject any
+			router := gin.New()
+			router.Use(errorMiddleware(zap.NewNop()))
+			middleware := authMiddleware(tokens, test.apiKeys, AuthSettings{Policies: DefaultPolicies()})
+			for route := range DefaultPolicies() {
+				method, path, _ := strings.Cut(route, " ")
+				router.Handle(method, path, middleware, func(c *gin.Context) {
+					subject, _ = c.Get("user")
+					c.Status(http.StatusOK)
+				})
+			}
+
+			request := httptest.NewRequest(test.method, test.path, nil)
+			if test.authorization != "" {
+				request.Header.Set("Authorization", test.authorization)
+			}
+			if test.apiKey != "" {
+				request.Header.Set(ApiKeyHeader, test.apiKey)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Errorf("%s %s = %d, want %d", test.method, test.path, recorder.Code, test.status)
+			}
+			if test.status == http.StatusOK && test.apiKey != "" && subject != "svc|batch" {
+				t.Errorf("user = %v, want the subject of the key", subject)
+			}
+		})
+	}
+}
+
+func keyClaims(scope string) *Claims {
+	return &Claims{Scope: scope, RegisteredClaims: jwt.RegisteredClaims{Subject: "svc|batch"}}
+}
+
+func TestTenants(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name     string
+		claims   *Claims
+		required bool
+		status   int
+		tenant   string
+	}{
+		{"tenant claim", &Claims{Scope: "read:counter", Tenant: "acme"}, false, http.StatusOK, "acme"},
+		{"no tenant claim", &Claims{Scope: "read:counter"}, false, http.StatusOK, data.DefaultTenant},
+		{"no tenant claim when required", &Claims{Scope: "read:counter"}, true, http.StatusForbidden, ""},
+		{"tenant claim when required", &Claims{Scope: "read:counter", Tenant: "acme"}, true, http.StatusOK, "acme"},
+		{"malformed tenant", &Claims{Scope: "read:counter", Tenant: "Acme/../x"}, false, http.StatusForbidden, ""},
+		{"tenant starting with a digit", &Claims{Scope: "read:counter", Tenant: "2024-01-01"}, false, http.StatusForbidden, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var tenant any
+			router := gin.New()
+			router.Use(errorMiddleware(zap.NewNop()))
+			settings := AuthSettings{Policies: DefaultPolicies(), TenantRequired: test.required}
+			router.GET("/api/counter/:id", authMiddleware(stubProvider{claims: test.claims}, stubProvider{}, settings), func(c *gin.Context) {
+				tenant = c.Value(data.TenantKey)
+				c.Status(http.StatusOK)
+			})
+
+			request := httptest.NewRequest(http.MethodGet, "/api/counter/1", nil)
+			request.Header.Set("Authorization", "Bearer x")
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Errorf("status = %d, want %d", recorder.Code, test.status)
+			}
+			if test.status == http.StatusOK && tenant != test.tenant {
+				t.Errorf("tenant = %v, want %v", tenant, test.tenant)
+			}
+		})
+	}
+}
+
+func TestTenantClaim(t *testing.T) {
+	var claims Claims
+	payload := `{"sub": "auth0|1", "scope": "read:counter", "https://gokube/tenant": "acme"}`
+	if err := json.Unmarshal([]byte(payload), &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "auth0|1" || claims.Scope != "read:counter" {
+		t.Errorf("registered claims = %+v, want subject and scope", claims)
+	}
+	if tenant, _ := claims.raw["https://gokube/tenant"].(string); tenant != "acme" {
+		t.Errorf("tenant claim = %q, want acme", tenant)
+	}
+}
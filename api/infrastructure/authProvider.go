@@ -0,0 +1,184 @@
+package infrastructure
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+type AuthProviderKind string
+
+const (
+	// Any OpenID Connect issuer, e.g. Auth0. Keys are found through discovery
+	OIDCProvider AuthProviderKind = "oidc"
+	// Keys are read from PEM files, or the file holds an HMAC secret
+	StaticProvider AuthProviderKind = "static"
+	// Tokens are signed with a local secret and minted by /api/dev/token. Never available in production
+	DevProvider AuthProviderKind = "dev"
+)
+
+// AuthProvider verifies bearer tokens
+type AuthProvider interface {
+	// Verify checks signature, issuer, audience and lifetime of the token
+	Verify(ctx context.Context, token string) (*Claims, error)
+}
+
+func NewAuthProvider(ctx context.Context, settings AuthSettings, logger *zap.Logger) (AuthProvider, error) {
+	switch settings.Provider {
+	case StaticProvider:
+		return newStaticProvider(settings)
+	case DevProvider:
+		logger.Warn("Tokens are verified with the dev secret, anyone can mint them through /api/dev/token")
+		return &devProvider{Settings: settings}, nil
+	default:
+		return &oidcProvider{
+			Keys:     NewJWKSCache(ctx, settings.Issuer, settings.JWKS, logger),
+			Settings: settings,
+		}, nil
+	}
+}
+
+func verify(token string, keyfunc jwt.Keyfunc, settings AuthSettings) (*Claims, error) {
+	parsed, err := jwt.ParseWithClaims(
+		token,
+		&Claims{},
+		keyfunc,
+		jwt.WithIssuer(settings.Issuer),
+		jwt.WithAudience(settings.Audience),
+		jwt.WithValidMethods(settings.Algorithms),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := parsed.Claims.(*Claims)
+	if !ok || !parsed.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	claims.Tenant, _ = claims.raw[settings.TenantClaim].(string)
+	return claims, nil
+}
+
+type oidcProvider struct {
+	Keys     *JWKSCache
+	Settings AuthSettings
+}
+
+func (provider *oidcProvider) Verify(ctx context.Context, token string) (*Claims, error) {
+	return verify(token, provider.Keys.Keyfunc(ctx), provider.Settings)
+}
+
+// staticProvider knows its keys by kid, which is the key file name without the extension
+type staticProvider struct {
+	Keys     map[string]any
+	Settings AuthSettings
+}
+
+func newStaticProvider(settings AuthSettings) (*staticProvider, error) {
+	provider := &staticProvider{Keys: map[string]any{}, Settings: settings}
+	for _, file := range settings.KeyFiles {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error happened while reading auth key: %w", err)
+		}
+		key, err := parseVerificationKey(content, settings.Algorithms)
+		if err != nil {
+			return nil, fmt.Errorf("error happened while parsing auth key %v: %w", file, err)
+		}
+		provider.Keys[strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))] = key
+	}
+	return provider, nil
+}
+
+func (provider *staticProvider) Verify(ctx context.Context, token string) (*Claims, error) {
+	return verify(token, provider.keyfunc, provider.Settings)
+}
+
+// Tokens without a kid can only be verified when there is a single key
+func (provider *staticProvider) keyfunc(token *jwt.Token) (any, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok && len(provider.Keys) == 1 {
+		for _, key := range provider.Keys {
+			return key, nil
+		}
+	}
+	key, ok := provider.Keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("no auth key has kid %q", kid)
+	}
+	return key, nil
+}
+
+// Public keys come as PKIX, PKCS1 or a certificate. Anything that is not PEM is an HMAC secret
+func parseVerificationKey(content []byte, algorithms []string) (any, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		for _, algorithm := range algorithms {
+			if strings.HasPrefix(algorithm, "HS") {
+				return []byte(strings.TrimSpace(string(content))), nil
+			}
+		}
+		return nil, errors.New("key is not PEM encoded and no HMAC algorithm is allowed")
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return certificate.PublicKey, nil
+	default:
+		return nil, fmt.Errorf("PEM block %q is not a public key", block.Type)
+	}
+}
+
+// devProvider signs and verifies tokens with a shared secret, so the API runs without an identity provider
+type devProvider struct {
+	Settings AuthSettings
+}
+
+func (provider *devProvider) Verify(ctx context.Context, token string) (*Claims, error) {
+	return verify(token, func(*jwt.Token) (any, error) {
+		return provider.Settings.DevSecret, nil
+	}, provider.Settings)
+}
+
+// Mint issues a token the provider accepts. The tenant goes into the configured tenant claim
+func (provider *devProvider) Mint(subject string, tenant string, scopes []string, groups []string, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now().UTC()
+	expiresAt := now.Add(ttl)
+	claims := jwt.MapClaims{
+		"iss":   provider.Settings.Issuer,
+		"sub":   subject,
+		"aud":   []string{provider.Settings.Audience},
+		"iat":   now.Unix(),
+		"exp":   expiresAt.Unix(),
+		"scope": strings.Join(scopes, " "),
+	}
+	if len(groups) > 0 {
+		claims["groups"] = groups
+	}
+	if tenant != "" {
+		claims[provider.Settings.TenantClaim] = tenant
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(provider.Settings.DevSecret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
@@ -0,0 +1,304 @@
+package infrastructure
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/steadfastie/gokube/data"
+	"go.uber.org/zap"
+)
+
+func staticSettings(algorithms ...string) AuthSettings {
+	return AuthSettings{Issuer: "https://idp.example/", Audience: "gokube", Algorithms: algorithms, TenantClaim: "tenant"}
+}
+
+func signStatic(t *testing.T, method jwt.SigningMethod, key any, kid string) string {
+	token := jwt.NewWithClaims(method, jwt.MapClaims{
+		"iss": "https://idp.example/",
+		"aud": "gokube",
+		"sub": "user|1",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// writeKey stores the key in dir under kid, the way AUTH_KEY_FILES expects it
+func writeKey(t *testing.T, dir string, kid string, content []byte) string {
+	file := filepath.Join(dir, kid+".pem")
+	if err := os.WriteFile(file, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func encodePEM(blockType string, bytes []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes})
+}
+
+func pkixPEM(t *testing.T, public any) []byte {
+	bytes, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encodePEM("PUBLIC KEY", bytes)
+}
+
+func certificatePEM(t *testing.T, key *rsa.PrivateKey) []byte {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	bytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encodePEM("CERTIFICATE", bytes)
+}
+
+func TestStaticProviderKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("a-shared-secret-of-at-least-32-bytes")
+
+	tests := []struct {
+		name      string
+		algorithm jwt.SigningMethod
+		content   []byte
+		signWith  any
+	}{
+		{"RSA PKIX", jwt.SigningMethodRS256, pkixPEM(t, &rsaKey.PublicKey), rsaKey},
+		{"RSA PKCS1", jwt.SigningMethodRS256, encodePEM("RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)), rsaKey},
+		{"RSA certificate", jwt.SigningMethodRS256, certificatePEM(t, rsaKey), rsaKey},
+		{"EC PKIX", jwt.SigningMethodES256, pkixPEM(t, &ecKey.PublicKey), ecKey},
+		{"HMAC secret with trailing newline", jwt.SigningMethodHS256, append(secret, '\n'), secret},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := staticSettings(test.algorithm.Alg())
+			settings.KeyFiles = []string{writeKey(t, t.TempDir(), "main", test.content)}
+			provider, err := newStaticProvider(settings)
+			if err != nil {
+				t.Fatalf("newStaticProvider() error = %v", err)
+			}
+
+			claims, err := provider.Verify(context.Background(), signStatic(t, test.algorithm, test.signWith, "main"))
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if claims.Subject != "user|1" {
+				t.Errorf("subject = %q, want user|1", claims.Subject)
+			}
+		})
+	}
+}
+
+func TestStaticProviderRejectsKeys(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		algorithms []string
+		content    []byte
+	}{
+		{"secret without HMAC algorithm", []string{"RS256", "ES256"}, []byte("a-shared-secret-of-at-least-32-bytes")},
+		{"private key", []string{"ES256"}, encodePEM("EC PRIVATE KEY", private)},
+		{"broken public key", []string{"RS256"}, encodePEM("PUBLIC KEY", []byte("not DER"))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := staticSettings(test.algorithms...)
+			settings.KeyFiles = []string{writeKey(t, t.TempDir(), "main", test.content)}
+			if _, err := newStaticProvider(settings); err == nil {
+				t.Error("newStaticProvider() accepted the key")
+			}
+		})
+	}
+
+	settings := staticSettings("RS256")
+	settings.KeyFiles = []string{filepath.Join(t.TempDir(), "missing.pem")}
+	if _, err := newStaticProvider(settings); err == nil {
+		t.Error("newStaticProvider() accepted a missing key file")
+	}
+}
+
+func TestStaticProviderKid(t *testing.T) {
+	keys := map[string]*ecdsa.PrivateKey{}
+	for _, kid := range []string{"2024-01", "2024-02"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[kid] = key
+	}
+
+	dir := t.TempDir()
+	settings := staticSettings("ES256")
+	for kid, key := range keys {
+		settings.KeyFiles = append(settings.KeyFiles, writeKey(t, dir, kid, pkixPEM(t, &key.PublicKey)))
+	}
+	provider, err := newStaticProvider(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		key   string
+		kid   string
+		valid bool
+	}{
+		{"first key", "2024-01", "2024-01", true},
+		{"second key", "2024-02", "2024-02", true},
+		{"kid of another key", "2024-01", "2024-02", false},
+		{"unknown kid", "2024-01", "2023-12", false},
+		{"no kid with several keys", "2024-01", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := provider.Verify(context.Background(), signStatic(t, jwt.SigningMethodES256, keys[test.key], test.kid))
+			if (err == nil) != test.valid {
+				t.Errorf("Verify() error = %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+
+	single := staticSettings("ES256")
+	single.KeyFiles = []string{writeKey(t, t.TempDir(), "only", pkixPEM(t, &keys["2024-01"].PublicKey))}
+	provider, err = newStaticProvider(single)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Verify(context.Background(), signStatic(t, jwt.SigningMethodES256, keys["2024-01"], "")); err != nil {
+		t.Errorf("Verify() without kid and a single key error = %v", err)
+	}
+}
+
+func TestDevProviderRefusedInProduction(t *testing.T) {
+	t.Setenv(EnvAppEnv, "production")
+	t.Setenv(EnvAuthProvider, string(DevProvider))
+	defer func() {
+		err, ok := recover().(error)
+		if !ok || !strings.Contains(err.Error(), "not available in production") {
+			t.Errorf("NewConfig() panicked with %v, want the dev auth provider refused in production", err)
+		}
+	}()
+	NewConfig(context.Background(), zap.NewNop())
+}
+
+func TestDevProviderMint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	settings := AuthSettings{
+		Provider:    DevProvider,
+		Issuer:      "gokube-dev",
+		Audience:    "gokube",
+		Algorithms:  []string{jwt.SigningMethodHS256.Alg()},
+		TenantClaim: "tenant",
+		DevSecret:   []byte(strings.Repeat("s", 32)),
+		Policies:    DefaultPolicies(),
+	}
+	provider := &devProvider{Settings: settings}
+	otherSecret := settings
+	otherSecret.DevSecret = []byte(strings.Repeat("o", 32))
+
+	tests := []struct {
+		name   string
+		minter *devProvider
+		method string
+		tenant string
+		scopes []string
+		ttl    time.Duration
+		status int
+	}{
+		{"read counter", provider, http.MethodGet, "acme", []string{"read:counter"}, time.Minute, http.StatusOK},
+		{"default tenant", provider, http.MethodGet, "", []string{"read:counter"}, time.Minute, http.StatusOK},
+		{"patch counter with read scope only", provider, http.MethodPatch, "acme", []string{"read:counter"}, time.Minute, http.StatusForbidden},
+		{"patch counter", provider, http.MethodPatch, "acme", []string{"read:counter", "update:counter"}, time.Minute, http.StatusOK},
+		{"expired", provider, http.MethodGet, "acme", []string{"read:counter"}, -time.Minute, http.StatusUnauthorized},
+		{"minted with another secret", &devProvider{Settings: otherSecret}, http.MethodGet, "acme", []string{"read:counter"}, time.Minute, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token, _, err := test.minter.Mint("dev|alice", test.tenant, test.scopes, []string{"ops"}, test.ttl)
+			if err != nil {
+				t.Fatalf("Mint() error = %v", err)
+			}
+
+			var user, tenant any
+			var groups []string
+			router := gin.New()
+			router.Use(errorMiddleware(zap.NewNop()))
+			handler := func(c *gin.Context) {
+				user, _ = c.Get("user")
+				groups = c.GetStringSlice("groups")
+				tenant = c.Value(data.TenantKey)
+				c.Status(http.StatusOK)
+			}
+			middleware := authMiddleware(provider, stubProvider{err: errInvalidApiKey}, settings)
+			router.GET("/api/counter/:id", middleware, handler)
+			router.PATCH("/api/counter/:id", middleware, handler)
+
+			request := httptest.NewRequest(test.method, "/api/counter/1", nil)
+			request.Header.Set("Authorization", "Bearer "+token)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Fatalf("%s /api/counter/1 = %d, want %d", test.method, recorder.Code, test.status)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+
+			wantTenant := test.tenant
+			if wantTenant == "" {
+				wantTenant = data.DefaultTenant
+			}
+			if user != "dev|alice" || tenant != wantTenant || len(groups) != 1 || groups[0] != "ops" {
+				t.Errorf("user = %v, tenant = %v, groups = %v, want dev|alice, %v, [ops]", user, tenant, groups, wantTenant)
+			}
+		})
+	}
+}
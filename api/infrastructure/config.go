@@ -2,25 +2,56 @@ package infrastructure
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/steadfastie/gokube/data"
 	"github.com/steadfastie/gokube/data/errors"
 	"github.com/steadfastie/gokube/data/services"
 	"go.uber.org/zap"
 )
 
 const (
+	EnvAppEnv                = "APP_ENV"
+	EnvAuthProvider          = "AUTH_PROVIDER"
 	EnvAuthDomain            = "AUTH_DOMAIN"
+	EnvAuthIssuer            = "AUTH_ISSUER"
 	EnvAuthAudience          = "AUTH_AUDIENCE"
+	EnvAuthAlgorithms        = "AUTH_ALGORITHMS"
+	EnvAuthKeyFiles          = "AUTH_KEY_FILES"
+	EnvAuthDevSecret         = "AUTH_DEV_SECRET"
+	EnvAuthPolicies          = "AUTH_POLICIES"
+	EnvAuthTenantClaim       = "AUTH_TENANT_CLAIM"
+	EnvAuthTenantRequired    = "AUTH_TENANT_REQUIRED"
 	EnvMongoConnectionString = "MONGO_CONNECTION_STRING"
 	EnvMongoDatabase         = "MONGO_DATABASE"
 	EnvLogLevel              = "LOGLEVEL"
+	EnvJWKSRefreshInterval   = "AUTH_JWKS_REFRESH_INTERVAL"
+	EnvJWKSMinRefresh        = "AUTH_JWKS_MIN_REFRESH_INTERVAL"
+	EnvRateLimitBackend      = "RATE_LIMIT_BACKEND"
+	EnvRateLimitDefault      = "RATE_LIMIT_DEFAULT"
+	EnvRateLimits            = "RATE_LIMITS"
+	EnvRateLimitPerIp        = "RATE_LIMIT_PER_IP"
+	EnvLegacyCounterOwner    = "LEGACY_COUNTER_OWNER"
+	EnvTrustedProxies        = "TRUSTED_PROXIES"
 )
 
 type Config struct {
 	Auth          AuthSettings           `json:"Auth0"`
 	MongoSettings services.MongoSettings `json:"MongoSettings"`
 	LogLevel      string                 `json:"LogLevel"`
+	RateLimits    RateLimitSettings      `json:"RateLimits"`
+	// Subject that becomes the owner of counters created before ownership was recorded
+	LegacyCounterOwner string `json:"LegacyCounterOwner"`
+	// Addresses and CIDRs whose X-Forwarded-For and X-Real-IP headers are believed
+	TrustedProxies []string `json:"TrustedProxies"`
 }
 
 func (c *Config) GetMongoSettings() services.MongoSettings {
@@ -32,19 +63,119 @@ func (c *Config) GetLogLevel() string {
 }
 
 type AuthSettings struct {
-	Domain   string `json:"Domain"`
-	Audience string `json:"Audience"`
+	Provider   AuthProviderKind `json:"Provider"`
+	Issuer     string           `json:"Issuer"`
+	Audience   string           `json:"Audience"`
+	Algorithms []string         `json:"Algorithms"`
+	JWKS       JWKSSettings     `json:"JWKS"`
+	Policies   Policies         `json:"Policies"`
+	// Custom claims of Auth0 must be namespaced, e.g. https://gokube/tenant
+	TenantClaim string `json:"TenantClaim"`
+	// Otherwise requests without a tenant act within the default one
+	TenantRequired bool `json:"TenantRequired"`
+	// PEM public keys or HMAC secrets of the static provider
+	KeyFiles []string `json:"-"`
+	// Signs and verifies tokens of the dev provider
+	DevSecret []byte `json:"-"`
 }
 
 func NewConfig(ctx context.Context, logger *zap.Logger) (*Config, error) {
-	authDomain := os.Getenv(EnvAuthDomain)
-	if authDomain == "" {
-		panic(errors.NewBusinessRuleError("Auth domain is not set"))
+	authProvider := AuthProviderKind(strings.ToLower(os.Getenv(EnvAuthProvider)))
+	switch authProvider {
+	case "":
+		authProvider = OIDCProvider
+	case OIDCProvider, StaticProvider:
+	case DevProvider:
+		if os.Getenv(EnvAppEnv) == "production" {
+			panic(errors.NewBusinessRuleError("Dev auth provider is not available in production"))
+		}
+	default:
+		panic(errors.NewBusinessRuleError("Auth provider must be one of oidc, static or dev"))
+	}
+
+	// Auth0 tenants only need the domain
+	authIssuer := os.Getenv(EnvAuthIssuer)
+	if authIssuer == "" {
+		switch authProvider {
+		case OIDCProvider:
+			authDomain := os.Getenv(EnvAuthDomain)
+			if authDomain == "" {
+				panic(errors.NewBusinessRuleError("Either auth issuer or auth domain must be set"))
+			}
+			authIssuer = "https://" + authDomain + "/"
+		case DevProvider:
+			authIssuer = "gokube-dev"
+		default:
+			panic(errors.NewBusinessRuleError("Auth issuer is not set"))
+		}
 	}
 
 	authAudience := os.Getenv(EnvAuthAudience)
 	if authAudience == "" {
-		panic(errors.NewBusinessRuleError("Auth audience is not set"))
+		if authProvider != DevProvider {
+			panic(errors.NewBusinessRuleError("Auth audience is not set"))
+		}
+		authAudience = "gokube"
+	}
+
+	authAlgorithms := []string{"RS256"} // Defaults to what Auth0 signs access tokens with
+	if value := os.Getenv(EnvAuthAlgorithms); value != "" {
+		authAlgorithms = strings.Split(value, ",")
+		for _, algorithm := range authAlgorithms {
+			if jwt.GetSigningMethod(algorithm) == nil || algorithm == jwt.SigningMethodNone.Alg() {
+				panic(errors.NewBusinessRuleError("Auth algorithms must be a comma-separated list of RS, PS, ES or HS algorithms, e.g. RS256,ES256"))
+			}
+		}
+	}
+
+	authKeyFiles := []string{}
+	if value := os.Getenv(EnvAuthKeyFiles); value != "" {
+		authKeyFiles = strings.Split(value, ",")
+	}
+	if authProvider == StaticProvider && len(authKeyFiles) == 0 {
+		panic(errors.NewBusinessRuleError("Static auth provider needs at least one key file"))
+	}
+
+	// A random secret invalidates minted tokens on every restart and differs between replicas
+	authDevSecret := []byte(os.Getenv(EnvAuthDevSecret))
+	if authProvider == DevProvider {
+		authAlgorithms = []string{jwt.SigningMethodHS256.Alg()}
+		if len(authDevSecret) == 0 {
+			authDevSecret = make([]byte, 32)
+			if _, err := rand.Read(authDevSecret); err != nil {
+				return nil, err
+			}
+		} else if len(authDevSecret) < 32 {
+			panic(errors.NewBusinessRuleError("Auth dev secret must be at least 32 bytes long"))
+		}
+	}
+
+	authPolicies := DefaultPolicies() // Defaults to the scopes the Swagger docs list per route
+	if value := os.Getenv(EnvAuthPolicies); value != "" {
+		var overrides Policies
+		if err := json.Unmarshal([]byte(value), &overrides); err != nil {
+			panic(errors.NewBusinessRuleError("Auth policies must be a JSON object of policies keyed by route, e.g. {\"PATCH /api/counter/:id\": {\"allOf\": [\"read:counter\", \"update:counter\"]}}"))
+		}
+		for route, policy := range overrides {
+			if err := policy.Validate(); err != nil {
+				panic(errors.NewBusinessRuleError(fmt.Sprintf("Auth policy of %s is invalid: %v", route, err)))
+			}
+		}
+		authPolicies = authPolicies.Merge(overrides)
+	}
+
+	authTenantClaim := os.Getenv(EnvAuthTenantClaim)
+	if authTenantClaim == "" {
+		authTenantClaim = "tenant" // Defaults to a plain tenant claim
+	}
+
+	authTenantRequired := false // Defaults to single-tenant deployments working without the claim
+	if value := os.Getenv(EnvAuthTenantRequired); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			panic(errors.NewBusinessRuleError("Auth tenant required must be a boolean"))
+		}
+		authTenantRequired = parsed
 	}
 
 	mongoConnectionString := os.Getenv(EnvMongoConnectionString)
@@ -62,16 +193,104 @@ func NewConfig(ctx context.Context, logger *zap.Logger) (*Config, error) {
 		logLevel = "Information" // Defaults to Information
 	}
 
+	jwksRefreshInterval := 10 * time.Minute // Defaults to picking up rotated keys within 10 minutes
+	if value := os.Getenv(EnvJWKSRefreshInterval); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < time.Second {
+			panic(errors.NewBusinessRuleError("JWKS refresh interval must be a duration of at least one second"))
+		}
+		jwksRefreshInterval = parsed
+	}
+
+	jwksMinRefreshInterval := 30 * time.Second // Defaults to at most two fetches a minute caused by unknown kids
+	if value := os.Getenv(EnvJWKSMinRefresh); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			panic(errors.NewBusinessRuleError("JWKS min refresh interval must be a non-negative duration"))
+		}
+		jwksMinRefreshInterval = parsed
+	}
+
+	rateLimitBackend := RateLimitBackend(strings.ToLower(os.Getenv(EnvRateLimitBackend)))
+	switch rateLimitBackend {
+	case "":
+		rateLimitBackend = MemoryRateLimits // Defaults to limits per replica
+	case MemoryRateLimits, MongoRateLimits:
+	default:
+		panic(errors.NewBusinessRuleError("Rate limit backend must be either memory or mongo"))
+	}
+
+	rateLimitDefault := data.RateLimit{Requests: 300, Period: time.Minute} // Defaults to 5 requests a second per client and route
+	if value := os.Getenv(EnvRateLimitDefault); value != "" {
+		parsed, err := data.ParseRateLimit(value)
+		if err != nil {
+			panic(errors.NewBusinessRuleError("Rate limit default must look like <requests>/<period> with a period of at least one second, e.g. 300/1m, or be off"))
+		}
+		rateLimitDefault = parsed
+	}
+
+	rateLimitPerIp := data.RateLimit{Requests: 600, Period: time.Minute} // Defaults to 10 requests a second per client IP across routes
+	if value := os.Getenv(EnvRateLimitPerIp); value != "" {
+		parsed, err := data.ParseRateLimit(value)
+		if err != nil {
+			panic(errors.NewBusinessRuleError("Rate limit per IP must look like <requests>/<period> with a period of at least one second, e.g. 600/1m, or be off"))
+		}
+		rateLimitPerIp = parsed
+	}
+
+	rateLimits := DefaultRateLimits() // Defaults to tighter limits on costly routes
+	if value := os.Getenv(EnvRateLimits); value != "" {
+		var overrides RateLimits
+		if err := json.Unmarshal([]byte(value), &overrides); err != nil {
+			panic(errors.NewBusinessRuleError(fmt.Sprintf("Rate limits must be a JSON object of limits keyed by route, e.g. {\"PATCH /api/counter/:id\": \"30/1m\"}: %v", err)))
+		}
+		rateLimits = rateLimits.Merge(overrides)
+	}
+
+	var trustedProxies []string // Defaults to trusting no proxy, so that clients are told apart by the address they connect from
+	if value := os.Getenv(EnvTrustedProxies); value != "" {
+		for _, proxy := range strings.Split(value, ",") {
+			proxy = strings.TrimSpace(proxy)
+			if _, err := netip.ParsePrefix(proxy); err != nil {
+				if _, err := netip.ParseAddr(proxy); err != nil {
+					panic(errors.NewBusinessRuleError(fmt.Sprintf("Trusted proxies must be a comma separated list of IP addresses and CIDRs, %q is neither", proxy)))
+				}
+			}
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+
+	legacyCounterOwner := os.Getenv(EnvLegacyCounterOwner) // Defaults to refusing to start while ownerless counters exist
+
 	config := &Config{
 		Auth: AuthSettings{
-			Domain:   authDomain,
-			Audience: authAudience,
+			Provider:   authProvider,
+			Issuer:     authIssuer,
+			Audience:   authAudience,
+			Algorithms: authAlgorithms,
+			JWKS: JWKSSettings{
+				RefreshInterval:    jwksRefreshInterval,
+				MinRefreshInterval: jwksMinRefreshInterval,
+			},
+			Policies:       authPolicies,
+			TenantClaim:    authTenantClaim,
+			TenantRequired: authTenantRequired,
+			KeyFiles:       authKeyFiles,
+			DevSecret:      authDevSecret,
 		},
 		MongoSettings: services.MongoSettings{
 			ConnectionString: mongoConnectionString,
 			Database:         mongoDatabase,
 		},
 		LogLevel: logLevel,
+		RateLimits: RateLimitSettings{
+			Backend: rateLimitBackend,
+			Default: rateLimitDefault,
+			PerIp:   rateLimitPerIp,
+			Routes:  rateLimits,
+		},
+		LegacyCounterOwner: legacyCounterOwner,
+		TrustedProxies:     trustedProxies,
 	}
 
 	return config, nil
@@ -2,6 +2,7 @@ package infrastructure
 
 import (
 	"context"
+	"fmt"
 	"log"
 
 	"github.com/golobby/container/v3"
@@ -33,12 +34,76 @@ func InitializeServices(ctx context.Context, logger *zap.Logger) {
 		log.Fatalf("can't register MongoDB client: %v", err)
 	}
 
-	err = container.Singleton(func(mongodb *services.MongoDB, logger *zap.Logger) repositories.CounterRepository {
-		return repositories.NewCounterRepository(mongodb, logger)
+	err = container.Singleton(func(config *Config, logger *zap.Logger) (AuthProvider, error) {
+		return NewAuthProvider(ctx, config.Auth, logger)
+	})
+	if err != nil {
+		log.Fatalf("can't register auth provider: %v", err)
+	}
+
+	// Only the dev provider mints tokens
+	err = container.Call(func(provider AuthProvider) error {
+		if minter, ok := provider.(handlers.TokenMinter); ok {
+			return container.Singleton(func() handlers.TokenMinter { return minter })
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("can't register token minter: %v", err)
+	}
+
+	err = container.Singleton(func(config *Config, mongodb *services.MongoDB, logger *zap.Logger) (repositories.CounterRepository, error) {
+		if config.LegacyCounterOwner != "" {
+			if err := repositories.ClaimOwnerlessCounters(ctx, mongodb, config.LegacyCounterOwner, logger); err != nil {
+				return nil, err
+			}
+			return repositories.NewCounterRepository(mongodb, logger), nil
+		}
+
+		// Ownerless counters answer 404 to everyone, which shouldn't go unnoticed
+		ownerless, err := repositories.CountOwnerlessCounters(ctx, mongodb)
+		if err != nil {
+			return nil, err
+		}
+		if ownerless > 0 {
+			return nil, fmt.Errorf("%d counters were created before ownership was recorded, set %s to the subject that should own them", ownerless, EnvLegacyCounterOwner)
+		}
+		return repositories.NewCounterRepository(mongodb, logger), nil
 	})
 	if err != nil {
 		log.Fatalf("can't register Basic repo: %v", err)
 	}
+
+	err = container.Singleton(func(mongodb *services.MongoDB, logger *zap.Logger) repositories.StatsRepository {
+		return repositories.NewStatsRepository(mongodb, logger)
+	})
+	if err != nil {
+		log.Fatalf("can't register stats repo: %v", err)
+	}
+
+	err = container.Singleton(func(mongodb *services.MongoDB, logger *zap.Logger) repositories.WebhookRepository {
+		return repositories.NewWebhookRepository(mongodb, logger)
+	})
+	if err != nil {
+		log.Fatalf("can't register webhook repo: %v", err)
+	}
+
+	err = container.Singleton(func(mongodb *services.MongoDB, logger *zap.Logger) (repositories.ApiKeyRepository, error) {
+		return repositories.NewApiKeyRepository(ctx, mongodb, logger)
+	})
+	if err != nil {
+		log.Fatalf("can't register API key repo: %v", err)
+	}
+
+	err = container.Singleton(func(config *Config, mongodb *services.MongoDB, logger *zap.Logger) (repositories.RateLimitRepository, error) {
+		if config.RateLimits.Backend == MongoRateLimits {
+			return repositories.NewMongoRateLimitRepository(ctx, mongodb, logger)
+		}
+		return repositories.NewMemoryRateLimitRepository(), nil
+	})
+	if err != nil {
+		log.Fatalf("can't register rate limit repo: %v", err)
+	}
 }
 
 func DisconnectServices(ctx context.Context) {
@@ -50,8 +115,43 @@ func DisconnectServices(ctx context.Context) {
 	})
 }
 
+func GetConfig() *Config {
+	var config *Config
+	container.Resolve(&config)
+	return config
+}
+
 func GetCounterController() *handlers.CounterController {
 	var controller handlers.CounterController
 	container.Fill(&controller)
 	return &controller
 }
+
+func GetStatsController() *handlers.StatsController {
+	var controller handlers.StatsController
+	container.Fill(&controller)
+	return &controller
+}
+
+func GetWebhookController() *handlers.WebhookController {
+	var controller handlers.WebhookController
+	container.Fill(&controller)
+	return &controller
+}
+
+func GetApiKeyController() *handlers.ApiKeyController {
+	var controller handlers.ApiKeyController
+	container.Fill(&controller)
+	return &controller
+}
+
+// GetDevTokenController reports false unless the dev auth provider is configured
+func GetDevTokenController() (*handlers.DevTokenController, bool) {
+	var minter handlers.TokenMinter
+	if err := container.Resolve(&minter); err != nil {
+		return nil, false
+	}
+	var controller handlers.DevTokenController
+	container.Fill(&controller)
+	return &controller, true
+}
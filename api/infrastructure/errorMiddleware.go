@@ -0,0 +1,139 @@
+package infrastructure
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/golobby/container/v3"
+	domainErrors "github.com/steadfastie/gokube/data/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem types are URNs, as there are no pages describing them
+const (
+	problemValidation   = "urn:gokube:problem:validation"
+	problemUnauthorized = "urn:gokube:problem:unauthorized"
+	problemForbidden    = "urn:gokube:problem:forbidden"
+	problemNotFound     = "urn:gokube:problem:not-found"
+	problemConflict     = "urn:gokube:problem:conflict"
+	problemBusinessRule = "urn:gokube:problem:business-rule"
+	problemRateLimited  = "urn:gokube:problem:rate-limited"
+	problemUnavailable  = "urn:gokube:problem:unavailable"
+	problemInternal     = "urn:gokube:problem:internal"
+)
+
+var (
+	errUnauthenticated = errors.New("credentials are missing, invalid or expired")
+	errForbidden       = errors.New("credentials don't grant access to this route")
+	errRateLimited     = errors.New("rate limit of this route has been exceeded")
+)
+
+// ErrorMiddleware renders the last error recorded with gin.Context.Error as a problem,
+// unless the response has been written already
+func ErrorMiddleware() gin.HandlerFunc {
+	var logger *zap.Logger
+	container.Resolve(&logger)
+
+	return errorMiddleware(logger)
+}
+
+func errorMiddleware(logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		last := c.Errors.Last()
+		if last == nil {
+			return
+		}
+		requestId := c.GetString(RequestIdKey)
+		if c.Writer.Written() {
+			logger.Warn("Error happened after the response was written", zap.String("requestId", requestId), zap.Error(last.Err))
+			return
+		}
+
+		problem := problemOf(last)
+		problem.Instance = c.Request.URL.Path
+		problem.RequestId = requestId
+		if problem.Status >= http.StatusInternalServerError {
+			logger.Error("Request failed", zap.String("requestId", requestId), zap.String("route", c.FullPath()), zap.Error(last.Err))
+		}
+
+		c.Header("Content-Type", ProblemContentType)
+		c.JSON(problem.Status, problem)
+	}
+}
+
+func problemOf(err *gin.Error) *domainErrors.HTTPError {
+	var invalid validator.ValidationErrors
+	var notFound *domainErrors.NotFoundError
+	var conflict *domainErrors.OptimisticLockError
+	var businessRule *domainErrors.BusinessRuleError
+
+	switch {
+	case errors.As(err.Err, &invalid):
+		return &domainErrors.HTTPError{
+			Type:          problemValidation,
+			Title:         "Request is invalid",
+			Status:        http.StatusBadRequest,
+			Detail:        "Some fields of the request are missing or invalid",
+			InvalidParams: invalidParams(invalid),
+		}
+	case err.IsType(gin.ErrorTypeBind) && errors.Is(err.Err, io.EOF):
+		return problem(problemValidation, "Request is invalid", http.StatusBadRequest, "Request body is missing")
+	case err.IsType(gin.ErrorTypeBind):
+		return problem(problemValidation, "Request is invalid", http.StatusBadRequest, err.Error())
+	case invalidId(err.Err):
+		return problem(problemValidation, "Request is invalid", http.StatusBadRequest, "Ids must be 24 hexadecimal characters")
+	case errors.As(err.Err, &notFound):
+		return problem(problemNotFound, "Not found", http.StatusNotFound, notFound.Details)
+	case errors.As(err.Err, &conflict):
+		return problem(problemConflict, "Conflict", http.StatusConflict, conflict.Details)
+	case errors.As(err.Err, &businessRule):
+		return problem(problemBusinessRule, "Business rule violated", http.StatusUnprocessableEntity, businessRule.Details)
+	case errors.Is(err.Err, errUnauthenticated):
+		return problem(problemUnauthorized, "Unauthorized", http.StatusUnauthorized, "Credentials are missing, invalid or expired")
+	case errors.Is(err.Err, errForbidden):
+		return problem(problemForbidden, "Forbidden", http.StatusForbidden, "Credentials don't grant access to this route")
+	case errors.Is(err.Err, errRateLimited):
+		return problem(problemRateLimited, "Too many requests", http.StatusTooManyRequests, "Rate limit of this route has been exceeded, see the Retry-After header")
+	case errors.Is(err.Err, errAuthUnavailable):
+		return problem(problemUnavailable, "Service unavailable", http.StatusServiceUnavailable, "Auth server connectivity malfunction")
+	default:
+		// Internal errors are only logged, they may tell more than clients should know
+		return problem(problemInternal, "Something went wrong", http.StatusInternalServerError, "")
+	}
+}
+
+func problem(problemType string, title string, status int, detail string) *domainErrors.HTTPError {
+	return &domainErrors.HTTPError{
+		Type:   problemType,
+		Title:  title,
+		Status: status,
+		Detail: detail,
+	}
+}
+
+func invalidParams(invalid validator.ValidationErrors) []domainErrors.InvalidParam {
+	params := make([]domainErrors.InvalidParam, len(invalid))
+	for i, field := range invalid {
+		reason := field.Tag()
+		if field.Param() != "" {
+			reason += "=" + field.Param()
+		}
+		params[i] = domainErrors.InvalidParam{Name: field.Field(), Reason: reason}
+	}
+	return params
+}
+
+// invalidId recognizes what primitive.ObjectIDFromHex fails with
+func invalidId(err error) bool {
+	var invalidByte hex.InvalidByteError
+	return errors.Is(err, primitive.ErrInvalidHex) || errors.As(err, &invalidByte)
+}
@@ -0,0 +1,235 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MicahParks/jwkset"
+	"github.com/MicahParks/keyfunc/v3"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Reasons the key set is fetched for
+const (
+	refreshStartup    = "startup"
+	refreshScheduled  = "scheduled"
+	refreshUnknownKid = "unknown_kid"
+)
+
+var errNoKeys = fmt.Errorf("%w: no signing keys have been fetched yet", errAuthUnavailable)
+
+// Unix nanoseconds of the last successful fetch
+var jwksFetchedAt atomic.Int64
+
+var (
+	jwksRefreshes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_jwks_refreshes_total",
+			Help: "How many times the signing keys were fetched, partitioned by reason and outcome.",
+		},
+		[]string{"reason", "outcome"},
+	)
+	jwksCacheAge = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "auth_jwks_cache_age_seconds",
+			Help: "Time since the signing keys were last fetched successfully, -1 until they are.",
+		},
+		func() float64 {
+			fetchedAt := jwksFetchedAt.Load()
+			if fetchedAt == 0 {
+				return -1
+			}
+			return time.Since(time.Unix(0, fetchedAt)).Seconds()
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(jwksRefreshes, jwksCacheAge)
+}
+
+type JWKSSettings struct {
+	// Keys are fetched again this often, whether they are used or not
+	RefreshInterval time.Duration
+	// Tokens with an unknown kid trigger a fetch at most this often
+	MinRefreshInterval time.Duration
+}
+
+// JWKSCache keeps the identity provider's signing keys in memory. The key set is found through the
+// issuer's OpenID Connect discovery document. Keys are fetched at startup and
+// every RefreshInterval. A token signed with a key that is not known yet, e.g. right after a rotation,
+// triggers an early fetch. A failed fetch keeps the last good key set, so tokens are still verified
+// while the identity provider is down
+type JWKSCache struct {
+	Issuer   string
+	Settings JWKSSettings
+	Logger   *zap.Logger
+
+	client *http.Client
+	// Taken from the discovery document on the first successful fetch
+	url string
+
+	mu   sync.RWMutex
+	keys keyfunc.Keyfunc
+
+	// Held for the whole fetch, so that tokens with the same unknown kid wait for a single one
+	refreshMu   sync.Mutex
+	lastAttempt time.Time
+}
+
+func NewJWKSCache(ctx context.Context, issuer string, settings JWKSSettings, logger *zap.Logger) *JWKSCache {
+	cache := &JWKSCache{
+		Issuer:   issuer,
+		Settings: settings,
+		Logger:   logger,
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+
+	// The API still starts while the identity provider is down and responds with 503 until the keys are fetched
+	if err := cache.refresh(ctx, refreshStartup, 0); err != nil {
+		logger.Error("Could not fetch signing keys", zap.String("issuer", issuer), zap.Error(err))
+	}
+	go cache.run(ctx)
+	return cache
+}
+
+// Keyfunc verifies tokens with the cached keys and fetches them again when a token has an unknown kid
+func (cache *JWKSCache) Keyfunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (any, error) {
+		key, err := cache.lookup(token)
+		if err == nil || !(errors.Is(err, jwkset.ErrKeyNotFound) || errors.Is(err, errNoKeys)) {
+			return key, err
+		}
+
+		if err := cache.refresh(ctx, refreshUnknownKid, cache.Settings.MinRefreshInterval); err != nil {
+			cache.Logger.Warn("Could not fetch signing keys for an unknown kid", zap.Any("kid", token.Header[jwkset.HeaderKID]), zap.Error(err))
+		}
+		// A concurrent fetch may have brought the key even if this one was rate-limited
+		return cache.lookup(token)
+	}
+}
+
+func (cache *JWKSCache) lookup(token *jwt.Token) (any, error) {
+	cache.mu.RLock()
+	keys := cache.keys
+	cache.mu.RUnlock()
+
+	if keys == nil {
+		return nil, errNoKeys
+	}
+	return keys.Keyfunc(token)
+}
+
+func (cache *JWKSCache) run(ctx context.Context) {
+	ticker := time.NewTicker(cache.Settings.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cache.refresh(ctx, refreshScheduled, 0); err != nil {
+				cache.Logger.Warn("Could not refresh signing keys, serving the last good ones", zap.String("issuer", cache.Issuer), zap.Error(err))
+			}
+		}
+	}
+}
+
+// Skips the fetch when the previous attempt was less than minInterval ago
+func (cache *JWKSCache) refresh(ctx context.Context, reason string, minInterval time.Duration) error {
+	cache.refreshMu.Lock()
+	defer cache.refreshMu.Unlock()
+
+	if time.Since(cache.lastAttempt) < minInterval {
+		return nil
+	}
+	cache.lastAttempt = time.Now()
+
+	keys, err := cache.fetch(ctx)
+	if err != nil {
+		jwksRefreshes.WithLabelValues(reason, "failure").Inc()
+		return err
+	}
+
+	cache.mu.Lock()
+	cache.keys = keys
+	cache.mu.Unlock()
+
+	jwksFetchedAt.Store(time.Now().UnixNano())
+	jwksRefreshes.WithLabelValues(reason, "success").Inc()
+	return nil
+}
+
+// Must be called while holding refreshMu
+func (cache *JWKSCache) fetch(ctx context.Context) (keyfunc.Keyfunc, error) {
+	if cache.url == "" {
+		url, err := cache.discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+		cache.url = url
+	}
+
+	body, err := cache.get(ctx, cache.url)
+	if err != nil {
+		return nil, fmt.Errorf("error happened while fetching signing keys: %w", err)
+	}
+
+	keys, err := keyfunc.NewJWKSetJSON(json.RawMessage(body))
+	if err != nil {
+		return nil, fmt.Errorf("error happened while parsing signing keys: %w", err)
+	}
+	return keys, nil
+}
+
+// Reads jwks_uri of the discovery document. See https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfig
+func (cache *JWKSCache) discover(ctx context.Context) (string, error) {
+	body, err := cache.get(ctx, strings.TrimSuffix(cache.Issuer, "/")+"/.well-known/openid-configuration")
+	if err != nil {
+		return "", fmt.Errorf("error happened while fetching discovery document: %w", err)
+	}
+
+	var document struct {
+		Issuer  string `json:"issuer"`
+		JWKSUri string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return "", fmt.Errorf("error happened while parsing discovery document: %w", err)
+	}
+	if document.Issuer != cache.Issuer {
+		return "", fmt.Errorf("discovery document belongs to issuer %q instead of %q", document.Issuer, cache.Issuer)
+	}
+	if document.JWKSUri == "" {
+		return "", errors.New("discovery document has no jwks_uri")
+	}
+	return document.JWKSUri, nil
+}
+
+func (cache *JWKSCache) get(ctx context.Context, url string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := cache.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v responded with %v", url, response.StatusCode)
+	}
+	return io.ReadAll(response.Body)
+}
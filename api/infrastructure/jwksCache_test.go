@@ -0,0 +1,251 @@
+package infrastructure
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+// fakeIdp serves a discovery document and the signing keys it currently publishes
+type fakeIdp struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	keys map[string]*ecdsa.PrivateKey
+	down bool
+
+	fetches atomic.Int64
+}
+
+func newFakeIdp(t *testing.T) *fakeIdp {
+	idp := &fakeIdp{keys: map[string]*ecdsa.PrivateKey{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		if idp.isDown() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"issuer": idp.URL, "jwks_uri": idp.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.fetches.Add(1)
+		if idp.isDown() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(idp.jwks())
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// publish replaces the published keys with a freshly generated key per kid
+func (idp *fakeIdp) publish(t *testing.T, kids ...string) {
+	keys := map[string]*ecdsa.PrivateKey{}
+	for _, kid := range kids {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[kid] = key
+	}
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.keys = keys
+}
+
+func (idp *fakeIdp) setDown(down bool) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.down = down
+}
+
+func (idp *fakeIdp) isDown() bool {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	return idp.down
+}
+
+func (idp *fakeIdp) jwks() map[string]any {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	encode := func(coordinate []byte) string { return base64.RawURLEncoding.EncodeToString(coordinate) }
+	keys := []map[string]string{}
+	for kid, key := range idp.keys {
+		keys = append(keys, map[string]string{
+			"kty": "EC",
+			"crv": "P-256",
+			"alg": "ES256",
+			"use": "sig",
+			"kid": kid,
+			"x":   encode(key.X.FillBytes(make([]byte, 32))),
+			"y":   encode(key.Y.FillBytes(make([]byte, 32))),
+		})
+	}
+	return map[string]any{"keys": keys}
+}
+
+// sign issues a token with the key currently published under kid
+func (idp *fakeIdp) sign(t *testing.T, kid string) string {
+	idp.mu.Lock()
+	key := idp.keys[kid]
+	idp.mu.Unlock()
+	if key == nil {
+		key, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{Subject: "user|1"})
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func newTestJWKSCache(t *testing.T, idp *fakeIdp, settings JWKSSettings) (*JWKSCache, context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return NewJWKSCache(ctx, idp.URL, settings, zap.NewNop()), ctx
+}
+
+func verifies(cache *JWKSCache, ctx context.Context, token string) bool {
+	_, err := jwt.Parse(token, cache.Keyfunc(ctx))
+	return err == nil
+}
+
+func refreshes(reason string, outcome string) float64 {
+	return testutil.ToFloat64(jwksRefreshes.WithLabelValues(reason, outcome))
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJWKSCacheRefreshesInBackground(t *testing.T) {
+	idp := newFakeIdp(t)
+	idp.publish(t, "first")
+	scheduled := refreshes(refreshScheduled, "success")
+
+	// Unknown kids never trigger a fetch here, so only the ticker can bring the rotated key
+	cache, ctx := newTestJWKSCache(t, idp, JWKSSettings{RefreshInterval: 20 * time.Millisecond, MinRefreshInterval: time.Hour})
+	if !verifies(cache, ctx, idp.sign(t, "first")) {
+		t.Fatal("token signed with the startup key was rejected")
+	}
+
+	idp.publish(t, "second")
+	fetched := idp.fetches.Load()
+	waitFor(t, "a scheduled fetch", func() bool { return idp.fetches.Load() > fetched && refreshes(refreshScheduled, "success") > scheduled })
+
+	if !verifies(cache, ctx, idp.sign(t, "second")) {
+		t.Error("token signed with the rotated key was rejected after a scheduled fetch")
+	}
+	if verifies(cache, ctx, idp.sign(t, "first")) {
+		t.Error("token signed with the retired key was accepted after a scheduled fetch")
+	}
+}
+
+func TestJWKSCacheRefreshesOnUnknownKid(t *testing.T) {
+	idp := newFakeIdp(t)
+	idp.publish(t, "first")
+	unknownKid := refreshes(refreshUnknownKid, "success")
+
+	minInterval := 100 * time.Millisecond
+	cache, ctx := newTestJWKSCache(t, idp, JWKSSettings{RefreshInterval: time.Hour, MinRefreshInterval: minInterval})
+	started := time.Now()
+
+	idp.publish(t, "first", "second")
+	accepted := verifies(cache, ctx, idp.sign(t, "second"))
+	if time.Since(started) < minInterval && (accepted || idp.fetches.Load() != 1) {
+		t.Fatal("unknown kid was fetched within the minimum refresh interval of the startup fetch")
+	}
+
+	time.Sleep(minInterval)
+	if !verifies(cache, ctx, idp.sign(t, "second")) {
+		t.Fatal("token with a new kid was rejected after the minimum refresh interval")
+	}
+	if got := refreshes(refreshUnknownKid, "success") - unknownKid; got != 1 {
+		t.Errorf("unknown kid refreshes = %v, want 1", got)
+	}
+
+	// A flood of tokens with made-up kids must not turn into a flood of fetches
+	fetched := idp.fetches.Load()
+	for i := 0; i < 10; i++ {
+		if verifies(cache, ctx, idp.sign(t, "made-up")) {
+			t.Fatal("token with a kid the identity provider never published was accepted")
+		}
+	}
+	if fetches := idp.fetches.Load() - fetched; fetches != 0 {
+		t.Errorf("made-up kids caused %d fetches within the minimum refresh interval, want 0", fetches)
+	}
+}
+
+func TestJWKSCacheServesLastGoodKeys(t *testing.T) {
+	idp := newFakeIdp(t)
+	idp.publish(t, "first")
+	jwksFetchedAt.Store(0)
+	failures := refreshes(refreshScheduled, "failure")
+
+	cache, ctx := newTestJWKSCache(t, idp, JWKSSettings{RefreshInterval: 20 * time.Millisecond, MinRefreshInterval: time.Hour})
+	if age := testutil.ToFloat64(jwksCacheAge); age < 0 || age > 1 {
+		t.Fatalf("cache age right after the startup fetch = %v, want about 0", age)
+	}
+
+	idp.setDown(true)
+	waitFor(t, "two failed scheduled fetches", func() bool { return refreshes(refreshScheduled, "failure")-failures >= 2 })
+
+	if !verifies(cache, ctx, idp.sign(t, "first")) {
+		t.Error("token was rejected while the identity provider is down, want the last good keys served")
+	}
+	// Failures leave the fetch time alone, so the age keeps growing past the refresh interval
+	if age := testutil.ToFloat64(jwksCacheAge); age < 0.04 {
+		t.Errorf("cache age after failed fetches = %v, want at least two refresh intervals", age)
+	}
+
+	idp.setDown(false)
+	waitFor(t, "a scheduled fetch after recovery", func() bool { return testutil.ToFloat64(jwksCacheAge) < 0.02 })
+}
+
+func TestJWKSCacheStartsWithoutIdp(t *testing.T) {
+	idp := newFakeIdp(t)
+	idp.publish(t, "first")
+	idp.setDown(true)
+	jwksFetchedAt.Store(0)
+	failures := refreshes(refreshStartup, "failure")
+
+	cache, ctx := newTestJWKSCache(t, idp, JWKSSettings{RefreshInterval: time.Hour, MinRefreshInterval: time.Hour})
+	if got := refreshes(refreshStartup, "failure") - failures; got != 1 {
+		t.Errorf("startup failures = %v, want 1", got)
+	}
+	if age := testutil.ToFloat64(jwksCacheAge); age != -1 {
+		t.Errorf("cache age before any successful fetch = %v, want -1", age)
+	}
+
+	provider := &oidcProvider{Keys: cache, Settings: AuthSettings{Algorithms: []string{"ES256"}}}
+	if _, err := provider.Verify(ctx, idp.sign(t, "first")); err == nil || !errors.Is(err, errAuthUnavailable) {
+		t.Errorf("Verify without keys = %v, want %v", err, errAuthUnavailable)
+	}
+}
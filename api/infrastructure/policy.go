@@ -0,0 +1,171 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Policy is either a single scope or a group of policies that must all or any be satisfied.
+// In JSON a scope is a plain string, e.g. {"allOf": ["read:counter", {"anyOf": ["update:counter", "admin"]}]}
+type Policy struct {
+	Scope string   `json:"scope,omitempty"`
+	AllOf []Policy `json:"allOf,omitempty"`
+	AnyOf []Policy `json:"anyOf,omitempty"`
+}
+
+// Policies are keyed by route as gin reports it, e.g. "PATCH /api/counter/:id"
+type Policies map[string]Policy
+
+func RequireScope(scope string) Policy {
+	return Policy{Scope: scope}
+}
+
+func AllOf(scopes ...string) Policy {
+	return Policy{AllOf: scopePolicies(scopes)}
+}
+
+func AnyOf(scopes ...string) Policy {
+	return Policy{AnyOf: scopePolicies(scopes)}
+}
+
+func scopePolicies(scopes []string) []Policy {
+	policies := make([]Policy, len(scopes))
+	for i, scope := range scopes {
+		policies[i] = RequireScope(scope)
+	}
+	return policies
+}
+
+// DefaultPolicies guard the routes main registers
+func DefaultPolicies() Policies {
+	return Policies{
+		"GET /api/counter/:id":                            RequireScope(ReadCounterScope),
+		"POST /api/counter":                               RequireScope(CreateCounterScope),
+		"PATCH /api/counter/:id":                          AllOf(ReadCounterScope, UpdateCounterScope),
+		"GET /api/counter/:id/stats":                      RequireScope(ReadCounterScope),
+		"GET /api/counter/:id/access":                     RequireScope(ReadCounterScope),
+		"PUT /api/counter/:id/access":                     AllOf(ReadCounterScope, ShareCounterScope),
+		"DELETE /api/counter/:id/access/:kind/:principal": AllOf(ReadCounterScope, ShareCounterScope),
+		"GET /api/stats/daily":                            RequireScope(ReadCounterScope),
+		"POST /api/webhooks":                              RequireScope(ManageWebhooksScope),
+		"GET /api/webhooks":                               RequireScope(ManageWebhooksScope),
+		"DELETE /api/webhooks/:id":                        RequireScope(ManageWebhooksScope),
+		"GET /api/webhooks/:id/deliveries":                RequireScope(ManageWebhooksScope),
+		"POST /api/apikeys":                               RequireScope(ManageApiKeysScope),
+		"GET /api/apikeys":                                RequireScope(ManageApiKeysScope),
+		"DELETE /api/apikeys/:id":                         RequireScope(ManageApiKeysScope),
+	}
+}
+
+func (policy *Policy) UnmarshalJSON(data []byte) error {
+	var scope string
+	if err := json.Unmarshal(data, &scope); err == nil {
+		*policy = RequireScope(scope)
+		return nil
+	}
+
+	type plain Policy
+	return json.Unmarshal(data, (*plain)(policy))
+}
+
+// Validate rejects policies that would silently allow or deny everyone
+func (policy Policy) Validate() error {
+	set := 0
+	if policy.Scope != "" {
+		set++
+	}
+	if policy.AllOf != nil {
+		set++
+	}
+	if policy.AnyOf != nil {
+		set++
+	}
+	if set != 1 {
+		return fmt.Errorf("policy must have exactly one of scope, allOf or anyOf")
+	}
+
+	for _, group := range [][]Policy{policy.AllOf, policy.AnyOf} {
+		if group != nil && len(group) == 0 {
+			return fmt.Errorf("policy groups must not be empty")
+		}
+		for _, nested := range group {
+			if err := nested.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Allows reports whether the granted scopes satisfy the policy
+func (policy Policy) Allows(granted map[string]struct{}) bool {
+	switch {
+	case policy.Scope != "":
+		_, ok := granted[policy.Scope]
+		return ok
+	case len(policy.AllOf) > 0:
+		for _, nested := range policy.AllOf {
+			if !nested.Allows(granted) {
+				return false
+			}
+		}
+		return true
+	case len(policy.AnyOf) > 0:
+		for _, nested := range policy.AnyOf {
+			if nested.Allows(granted) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// Authorize denies routes without a policy
+func (policies Policies) Authorize(route string, claims *Claims) bool {
+	policy, ok := policies[route]
+	if !ok {
+		return false
+	}
+	return policy.Allows(claims.Granted())
+}
+
+// Merge overrides the policies of the routes present in overrides
+func (policies Policies) Merge(overrides Policies) Policies {
+	merged := make(Policies, len(policies)+len(overrides))
+	for route, policy := range policies {
+		merged[route] = policy
+	}
+	for route, policy := range overrides {
+		merged[route] = policy
+	}
+	return merged
+}
+
+// Unregistered lists policies of routes the router doesn't have, which usually means a typo
+func (policies Policies) Unregistered(routes gin.RoutesInfo) []string {
+	return unregisteredRoutes(policies, routes)
+}
+
+func unregisteredRoutes[T any](configured map[string]T, routes gin.RoutesInfo) []string {
+	registered := make(map[string]struct{}, len(routes))
+	for _, route := range routes {
+		registered[routeKey(route.Method, route.Path)] = struct{}{}
+	}
+
+	unregistered := []string{}
+	for route := range configured {
+		if _, ok := registered[route]; !ok {
+			unregistered = append(unregistered, route)
+		}
+	}
+	return unregistered
+}
+
+func routeKey(method, path string) string {
+	return strings.ToUpper(method) + " " + path
+}
@@ -0,0 +1,217 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestDefaultPolicies(t *testing.T) {
+	tests := []struct {
+		name        string
+		route       string
+		scope       string
+		permissions []string
+		allowed     bool
+	}{
+		{"read counter", "GET /api/counter/:id", "read:counter", nil, true},
+		{"read counter without scopes", "GET /api/counter/:id", "", nil, false},
+		{"read counter with another scope", "GET /api/counter/:id", "create:counter", nil, false},
+		{"read counter with permission", "GET /api/counter/:id", "", []string{"read:counter"}, true},
+		{"create counter", "POST /api/counter", "create:counter", nil, true},
+		{"create counter with read scope", "POST /api/counter", "read:counter", nil, false},
+		{"patch counter", "PATCH /api/counter/:id", "read:counter update:counter", nil, true},
+		{"patch counter with read scope only", "PATCH /api/counter/:id", "read:counter", nil, false},
+		{"patch counter with update scope only", "PATCH /api/counter/:id", "update:counter", nil, false},
+		{"patch counter with scope and permission", "PATCH /api/counter/:id", "read:counter", []string{"update:counter"}, true},
+		{"counter stats", "GET /api/counter/:id/stats", "read:counter", nil, true},
+		{"counter stats with update scope", "GET /api/counter/:id/stats", "update:counter", nil, false},
+		{"counter access", "GET /api/counter/:id/access", "read:counter", nil, true},
+		{"grant counter access", "PUT /api/counter/:id/access", "read:counter share:counter", nil, true},
+		{"grant counter access with share scope only", "PUT /api/counter/:id/access", "share:counter", nil, false},
+		{"revoke counter access", "DELETE /api/counter/:id/access/:kind/:principal", "", []string{"read:counter", "share:counter"}, true},
+		{"revoke counter access with update scope", "DELETE /api/counter/:id/access/:kind/:principal", "read:counter update:counter", nil, false},
+		{"daily stats", "GET /api/stats/daily", "read:counter", nil, true},
+		{"daily stats without scopes", "GET /api/stats/daily", "", nil, false},
+		{"create webhook", "POST /api/webhooks", "manage:webhooks", nil, true},
+		{"create webhook with counter scopes", "POST /api/webhooks", "read:counter create:counter update:counter", nil, false},
+		{"list webhooks", "GET /api/webhooks", "manage:webhooks", nil, true},
+		{"delete webhook", "DELETE /api/webhooks/:id", "manage:webhooks", nil, true},
+		{"delete webhook with read scope", "DELETE /api/webhooks/:id", "read:counter", nil, false},
+		{"webhook deliveries", "GET /api/webhooks/:id/deliveries", "", []string{"manage:webhooks"}, true},
+		{"issue API key", "POST /api/apikeys", "manage:apikeys", nil, true},
+		{"issue API key with webhook scope", "POST /api/apikeys", "manage:webhooks", nil, false},
+		{"list API keys", "GET /api/apikeys", "", []string{"manage:apikeys"}, true},
+		{"revoke API key", "DELETE /api/apikeys/:id", "manage:apikeys", nil, true},
+		{"route without policy", "GET /api/unknown", "read:counter manage:webhooks", nil, false},
+		{"scope prefix is not a match", "GET /api/counter/:id", "read:counters", nil, false},
+	}
+
+	policies := DefaultPolicies()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := &Claims{Scope: test.scope, Permissions: test.permissions}
+			if allowed := policies.Authorize(test.route, claims); allowed != test.allowed {
+				t.Errorf("Authorize(%q) = %v, want %v", test.route, allowed, test.allowed)
+			}
+		})
+	}
+}
+
+func TestPolicyExpressions(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		granted []string
+		allowed bool
+	}{
+		{"scope", `"a"`, []string{"a"}, true},
+		{"missing scope", `"a"`, []string{"b"}, false},
+		{"all of", `{"allOf": ["a", "b"]}`, []string{"a", "b"}, true},
+		{"all of missing one", `{"allOf": ["a", "b"]}`, []string{"a"}, false},
+		{"any of", `{"anyOf": ["a", "b"]}`, []string{"b"}, true},
+		{"any of missing all", `{"anyOf": ["a", "b"]}`, []string{"c"}, false},
+		{"nested any of", `{"allOf": ["a", {"anyOf": ["b", "c"]}]}`, []string{"a", "c"}, true},
+		{"nested any of missing", `{"allOf": ["a", {"anyOf": ["b", "c"]}]}`, []string{"a"}, false},
+		{"nested all of", `{"anyOf": ["admin", {"allOf": ["a", "b"]}]}`, []string{"admin"}, true},
+		{"object scope", `{"scope": "a"}`, []string{"a"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var policy Policy
+			if err := json.Unmarshal([]byte(test.policy), &policy); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if err := policy.Validate(); err != nil {
+				t.Fatalf("validate: %v", err)
+			}
+
+			granted := map[string]struct{}{}
+			for _, scope := range test.granted {
+				granted[scope] = struct{}{}
+			}
+			if allowed := policy.Allows(granted); allowed != test.allowed {
+				t.Errorf("Allows(%v) = %v, want %v", test.granted, allowed, test.allowed)
+			}
+		})
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		valid  bool
+	}{
+		{"scope", `"a"`, true},
+		{"empty object", `{}`, false},
+		{"empty scope", `""`, false},
+		{"empty all of", `{"allOf": []}`, false},
+		{"empty any of", `{"anyOf": []}`, false},
+		{"scope and group", `{"scope": "a", "anyOf": ["b"]}`, false},
+		{"both groups", `{"allOf": ["a"], "anyOf": ["b"]}`, false},
+		{"invalid nested", `{"allOf": ["a", {}]}`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var policy Policy
+			if err := json.Unmarshal([]byte(test.policy), &policy); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if err := policy.Validate(); (err == nil) != test.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
+
+type stubProvider struct {
+	claims *Claims
+	err    error
+}
+
+func (provider stubProvider) Verify(ctx context.Context, token string) (*Claims, error) {
+	return provider.claims, provider.err
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		header   string
+		provider stubProvider
+		status   int
+	}{
+		{"no token", http.MethodGet, "/api/counter/1", "", stubProvider{}, http.StatusUnauthorized},
+		{"invalid token", http.MethodGet, "/api/counter/1", "Bearer x", stubProvider{err: errors.New("invalid")}, http.StatusUnauthorized},
+		{"no signing keys", http.MethodGet, "/api/counter/1", "Bearer x", stubProvider{err: errNoKeys}, http.StatusServiceUnavailable},
+		{"get counter", http.MethodGet, "/api/counter/1", "Bearer x", stubProvider{claims: &Claims{Scope: "read:counter"}}, http.StatusOK},
+		{"create counter", http.MethodPost, "/api/counter", "Bearer x", stubProvider{claims: &Claims{Scope: "create:counter"}}, http.StatusOK},
+		{"patch counter", http.MethodPatch, "/api/counter/1", "Bearer x", stubProvider{claims: &Claims{Scope: "read:counter update:counter"}}, http.StatusOK},
+		{"patch counter with read scope only", http.MethodPatch, "/api/counter/1", "Bearer x", stubProvider{claims: &Claims{Scope: "read:counter"}}, http.StatusForbidden},
+		{"patch counter with permissions", http.MethodPatch, "/api/counter/1", "Bearer x", stubProvider{claims: &Claims{Permissions: []string{"read:counter", "update:counter"}}}, http.StatusOK},
+		{"counter stats", http.MethodGet, "/api/counter/1/stats", "Bearer x", stubProvider{claims: &Claims{Scope: "read:counter"}}, http.StatusOK},
+		{"grant counter access", http.MethodPut, "/api/counter/1/access", "Bearer x", stubProvider{claims: &Claims{Scope: "read:counter share:counter"}}, http.StatusOK},
+		{"revoke counter access without share scope", http.MethodDelete, "/api/counter/1/access/user/auth0%7C1", "Bearer x", stubProvider{claims: &Claims{Scope: "read:counter"}}, http.StatusForbidden},
+		{"daily stats", http.MethodGet, "/api/stats/daily", "Bearer x", stubProvider{claims: &Claims{Scope: "read:counter"}}, http.StatusOK},
+		{"create webhook", http.MethodPost, "/api/webhooks", "Bearer x", stubProvider{claims: &Claims{Scope: "manage:webhooks"}}, http.StatusOK},
+		{"list webhooks with counter scope", http.MethodGet, "/api/webhooks", "Bearer x", stubProvider{claims: &Claims{Scope: "read:counter"}}, http.StatusForbidden},
+		{"delete webhook", http.MethodDelete, "/api/webhooks/1", "Bearer x", stubProvider{claims: &Claims{Scope: "manage:webhooks"}}, http.StatusOK},
+		{"webhook deliveries", http.MethodGet, "/api/webhooks/1/deliveries", "Bearer x", stubProvider{claims: &Claims{Scope: "manage:webhooks"}}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(errorMiddleware(zap.NewNop()))
+			middleware := authMiddleware(test.provider, stubProvider{err: errInvalidApiKey}, AuthSettings{Policies: DefaultPolicies()})
+			for route := range DefaultPolicies() {
+				method, path, _ := strings.Cut(route, " ")
+				router.Handle(method, path, middleware, func(c *gin.Context) { c.Status(http.StatusOK) })
+			}
+
+			request := httptest.NewRequest(test.method, test.path, nil)
+			if test.header != "" {
+				request.Header.Set("Authorization", test.header)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Errorf("%s %s = %d, want %d", test.method, test.path, recorder.Code, test.status)
+			}
+			if test.status >= http.StatusBadRequest && recorder.Header().Get("Content-Type") != ProblemContentType {
+				t.Errorf("%s %s responded with %q, want a problem", test.method, test.path, recorder.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestUnregisteredPolicies(t *testing.T) {
+	routes := gin.RoutesInfo{
+		{Method: http.MethodGet, Path: "/api/counter/:id"},
+		{Method: http.MethodPatch, Path: "/api/counter/:id"},
+	}
+	policies := Policies{
+		"GET /api/counter/:id":   RequireScope(ReadCounterScope),
+		"PATCH /api/counter/:id": AllOf(ReadCounterScope, UpdateCounterScope),
+		"PUT /api/counter/:id":   RequireScope(UpdateCounterScope),
+	}
+
+	unregistered := policies.Unregistered(routes)
+	if len(unregistered) != 1 || unregistered[0] != "PUT /api/counter/:id" {
+		t.Errorf("Unregistered() = %v, want [PUT /api/counter/:id]", unregistered)
+	}
+}
@@ -0,0 +1,166 @@
+package infrastructure
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golobby/container/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steadfastie/gokube/data"
+	"github.com/steadfastie/gokube/data/repositories"
+	"go.uber.org/zap"
+)
+
+type RateLimitBackend string
+
+const (
+	MemoryRateLimits RateLimitBackend = "memory"
+	MongoRateLimits  RateLimitBackend = "mongo"
+)
+
+var rateLimited = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "http_rate_limited_total",
+		Help: "How many requests were rejected for exceeding a rate limit, partitioned by route.",
+	},
+	[]string{"route"},
+)
+
+func init() {
+	prometheus.MustRegister(rateLimited)
+}
+
+type RateLimitSettings struct {
+	Backend RateLimitBackend `json:"Backend"`
+	// Applies to every request of a client IP before it is authenticated, so that guessing credentials is throttled too
+	PerIp data.RateLimit `json:"PerIp"`
+	// Applies to routes without a limit of their own
+	Default data.RateLimit `json:"Default"`
+	Routes  RateLimits     `json:"Routes"`
+}
+
+// RateLimits are keyed by route as gin reports it, e.g. "PATCH /api/counter/:id"
+type RateLimits map[string]data.RateLimit
+
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		// Concurrent patches of a counter conflict and retry, so a single client can starve everyone else
+		"PATCH /api/counter/:id": {Requests: 30, Period: time.Minute},
+		"POST /api/apikeys":      {Requests: 10, Period: time.Minute},
+	}
+}
+
+// Merge overrides the limits of the routes present in overrides
+func (limits RateLimits) Merge(overrides RateLimits) RateLimits {
+	merged := make(RateLimits, len(limits)+len(overrides))
+	for route, limit := range limits {
+		merged[route] = limit
+	}
+	for route, limit := range overrides {
+		merged[route] = limit
+	}
+	return merged
+}
+
+// Unregistered lists limits of routes the router doesn't have, which usually means a typo
+func (limits RateLimits) Unregistered(routes gin.RoutesInfo) []string {
+	return unregisteredRoutes(limits, routes)
+}
+
+func (settings RateLimitSettings) limitOf(route string) data.RateLimit {
+	if limit, ok := settings.Routes[route]; ok {
+		return limit
+	}
+	return settings.Default
+}
+
+// RateLimitMiddleware gives every client a token bucket per route. It goes after AuthMiddleware,
+// so that authenticated clients are told apart by who they are rather than where they come from
+func RateLimitMiddleware() gin.HandlerFunc {
+	var buckets repositories.RateLimitRepository
+	container.Resolve(&buckets)
+	var config *Config
+	container.Resolve(&config)
+	var logger *zap.Logger
+	container.Resolve(&logger)
+
+	return rateLimitMiddleware(buckets, config.RateLimits, logger)
+}
+
+// IpRateLimitMiddleware gives every client IP a single token bucket across routes. It goes before AuthMiddleware,
+// so that requests with bad credentials, which never reach RateLimitMiddleware, are limited as well
+func IpRateLimitMiddleware() gin.HandlerFunc {
+	var buckets repositories.RateLimitRepository
+	container.Resolve(&buckets)
+	var config *Config
+	container.Resolve(&config)
+	var logger *zap.Logger
+	container.Resolve(&logger)
+
+	return ipRateLimitMiddleware(buckets, config.RateLimits.PerIp, logger)
+}
+
+func rateLimitMiddleware(buckets repositories.RateLimitRepository, settings RateLimitSettings, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := routeKey(c.Request.Method, c.FullPath())
+		limitRequest(c, buckets, route, route+" "+rateLimitKey(c), settings.limitOf(route), logger)
+	}
+}
+
+func ipRateLimitMiddleware(buckets repositories.RateLimitRepository, limit data.RateLimit, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := routeKey(c.Request.Method, c.FullPath())
+		limitRequest(c, buckets, route, "ip "+c.ClientIP(), limit, logger)
+	}
+}
+
+// Takes a token from the bucket, and aborts the request when there is none
+func limitRequest(c *gin.Context, buckets repositories.RateLimitRepository, route string, key string, limit data.RateLimit, logger *zap.Logger) {
+	if limit.IsUnlimited() {
+		c.Next()
+		return
+	}
+
+	decision, err := buckets.Take(c.Request.Context(), key, limit)
+	if err != nil {
+		// Limits protect the API, so losing them is better than failing requests
+		logger.Warn("Could not check rate limit, letting the request through", zap.String("route", route), zap.Error(err))
+		c.Next()
+		return
+	}
+
+	// The route limit comes later and overwrites these headers, as it is usually the tighter one
+	header := c.Writer.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	header.Set("RateLimit-Reset", seconds(decision.Reset))
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", limit.Requests, seconds(limit.Period)))
+	if !decision.Allowed {
+		rateLimited.WithLabelValues(route).Inc()
+		header.Set("Retry-After", seconds(decision.RetryAfter))
+		c.Error(errRateLimited)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// rateLimitKey tells clients apart by API key, then by subject, and anonymous ones by IP.
+// Subjects are only unique within a tenant
+func rateLimitKey(c *gin.Context) string {
+	if key := c.GetString("apiKey"); key != "" {
+		return "key:" + key
+	}
+	if user := c.GetString("user"); user != "" {
+		return "user:" + c.GetString(data.TenantKey) + "/" + user
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// Headers carry whole seconds, rounded up so that clients don't come back too early
+func seconds(duration time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(duration.Seconds())), 10)
+}
@@ -0,0 +1,153 @@
+package infrastructure
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/steadfastie/gokube/data"
+	"github.com/steadfastie/gokube/data/repositories"
+	"go.uber.org/zap"
+)
+
+func serveFrom(router *gin.Engine, remoteAddr string, authorization string, headers ...string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, "/api/counter/1", nil)
+	request.RemoteAddr = remoteAddr
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tokens := stubProvider{claims: &Claims{Scope: "read:counter", RegisteredClaims: jwt.RegisteredClaims{Subject: "auth0|1"}}}
+	settings := RateLimitSettings{
+		Default: data.Unlimited,
+		Routes:  RateLimits{"GET /api/counter/:id": {Requests: 2, Period: time.Minute}},
+	}
+	router := gin.New()
+	router.Use(errorMiddleware(zap.NewNop()))
+	router.GET("/api/counter/:id", authMiddleware(tokens, stubProvider{}, AuthSettings{Policies: DefaultPolicies()}),
+		rateLimitMiddleware(repositories.NewMemoryRateLimitRepository(), settings, zap.NewNop()),
+		func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i, remaining := range []string{"1", "0"} {
+		recorder := serveFrom(router, "192.0.2.1:1234", "Bearer x")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("request %d = %d, want 200", i+1, recorder.Code)
+		}
+		if got := recorder.Header().Get("RateLimit-Remaining"); got != remaining {
+			t.Errorf("request %d: RateLimit-Remaining = %q, want %q", i+1, got, remaining)
+		}
+	}
+
+	// The subject is limited wherever it comes from
+	recorder := serveFrom(router, "198.51.100.1:1234", "Bearer x")
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit = %d, want 429", recorder.Code)
+	}
+	if recorder.Header().Get("Content-Type") != ProblemContentType {
+		t.Errorf("429 responded with %q, want a problem", recorder.Header().Get("Content-Type"))
+	}
+	expected := map[string]string{
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "60",
+		"RateLimit-Policy":    "2;w=60",
+		"Retry-After":         "30",
+	}
+	for header, value := range expected {
+		if got := recorder.Header().Get(header); got != value {
+			t.Errorf("%s = %q, want %q", header, got, value)
+		}
+	}
+}
+
+func TestIpRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(errorMiddleware(zap.NewNop()))
+	api := router.Group("/api")
+	api.Use(ipRateLimitMiddleware(repositories.NewMemoryRateLimitRepository(), data.RateLimit{Requests: 2, Period: time.Minute}, zap.NewNop()))
+	api.GET("/counter/:id", authMiddleware(stubProvider{err: errors.New("invalid")}, stubProvider{}, AuthSettings{Policies: DefaultPolicies()}),
+		func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// Bad credentials are answered until the IP runs out of tokens
+	for i := 0; i < 2; i++ {
+		if recorder := serveFrom(router, "192.0.2.1:1234", "Bearer x"); recorder.Code != http.StatusUnauthorized {
+			t.Fatalf("request %d = %d, want 401", i+1, recorder.Code)
+		}
+	}
+	recorder := serveFrom(router, "192.0.2.1:1234", "Bearer x")
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit = %d, want 429", recorder.Code)
+	}
+	if got := recorder.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %q, want 30", got)
+	}
+	if got := recorder.Header().Get("RateLimit-Policy"); got != "2;w=60" {
+		t.Errorf("RateLimit-Policy = %q, want 2;w=60", got)
+	}
+
+	// Other addresses have buckets of their own
+	if recorder := serveFrom(router, "198.51.100.1:1234", "Bearer x"); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("request from another address = %d, want 401", recorder.Code)
+	}
+}
+
+func newIpLimitedRouter(t *testing.T, trustedProxies []string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		t.Fatal(err)
+	}
+	router.Use(errorMiddleware(zap.NewNop()))
+	router.Use(ipRateLimitMiddleware(repositories.NewMemoryRateLimitRepository(), data.RateLimit{Requests: 1, Period: time.Minute}, zap.NewNop()))
+	router.GET("/api/counter/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router
+}
+
+func TestIpRateLimitIgnoresSpoofedHeaders(t *testing.T) {
+	router := newIpLimitedRouter(t, nil)
+
+	if recorder := serveFrom(router, "192.0.2.1:1234", "", "X-Forwarded-For", "203.0.113.1"); recorder.Code != http.StatusOK {
+		t.Fatalf("first request = %d, want 200", recorder.Code)
+	}
+	spoofed := [][]string{
+		{"X-Forwarded-For", "203.0.113.2"},
+		{"X-Forwarded-For", "203.0.113.3, 198.51.100.7"},
+		{"X-Real-IP", "203.0.113.4"},
+	}
+	for _, headers := range spoofed {
+		if recorder := serveFrom(router, "192.0.2.1:1234", "", headers...); recorder.Code != http.StatusTooManyRequests {
+			t.Errorf("request with %v = %d, want the bucket of the connecting address", headers, recorder.Code)
+		}
+	}
+}
+
+func TestIpRateLimitBehindTrustedProxy(t *testing.T) {
+	router := newIpLimitedRouter(t, []string{"10.0.0.0/8"})
+
+	for _, client := range []string{"203.0.113.1", "203.0.113.2"} {
+		if recorder := serveFrom(router, "10.0.0.5:1234", "", "X-Forwarded-For", client); recorder.Code != http.StatusOK {
+			t.Errorf("first request of %s through the proxy = %d, want 200", client, recorder.Code)
+		}
+	}
+	if recorder := serveFrom(router, "10.0.0.5:1234", "", "X-Forwarded-For", "203.0.113.1"); recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("second request of 203.0.113.1 through the proxy = %d, want 429", recorder.Code)
+	}
+}
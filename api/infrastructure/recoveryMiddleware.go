@@ -1,31 +1,31 @@
 package infrastructure
 
 import (
-	"net/http"
+	"fmt"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golobby/container/v3"
-	domainErros "github.com/steadfastie/gokube/data/errors"
 	"go.uber.org/zap"
 )
 
-func RecoveryMiddleware(c *gin.Context, e any) {
-	container.Call(func(logger *zap.Logger) {
-		GlobalPanicRecovery(c, e, logger)
-	})
+// RecoveryMiddleware hands a recovered panic over to ErrorMiddleware. Panics with a domain error
+// are answered like the error would be, anything else with 500
+func RecoveryMiddleware() gin.RecoveryFunc {
+	var logger *zap.Logger
+	container.Resolve(&logger)
+
+	return recoveryMiddleware(logger)
 }
 
-func GlobalPanicRecovery(c *gin.Context, err any, logger *zap.Logger) {
-	switch data := err.(type) {
-	case *domainErros.OptimisticLockError:
-		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": data.Message, "details": data.Error.Error()})
-	case *domainErros.BusinessRuleError:
-		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": data.Message, "details": data.Error.Error()})
-	case *domainErros.NotFoundError:
-		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": data.Message, "details": data.Error.Error()})
-	default:
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
-	}
+func recoveryMiddleware(logger *zap.Logger) gin.RecoveryFunc {
+	return func(c *gin.Context, recovered any) {
+		err, ok := recovered.(error)
+		if !ok {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
 
-	logger.Panic("Something went wrong", zap.Any("error", err))
+		logger.Error("Recovered from panic", zap.String("requestId", c.GetString(RequestIdKey)), zap.Any("panic", recovered))
+		c.Error(err)
+		c.Abort()
+	}
 }
@@ -0,0 +1,47 @@
+package infrastructure
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RequestIdHeader = "X-Request-Id"
+	RequestIdKey    = "requestId"
+)
+
+// Longer ids, or ids with anything but printable ASCII, are replaced rather than echoed
+const maxRequestIdLength = 128
+
+// RequestIdMiddleware keeps the id a proxy assigned to the request, or makes one up, and echoes it in the response
+func RequestIdMiddleware(c *gin.Context) {
+	requestId := c.GetHeader(RequestIdHeader)
+	if !validRequestId(requestId) {
+		requestId = newRequestId()
+	}
+
+	c.Set(RequestIdKey, requestId)
+	c.Header(RequestIdHeader, requestId)
+	c.Next()
+}
+
+func validRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > maxRequestIdLength {
+		return false
+	}
+	for _, char := range requestId {
+		if char <= ' ' || char > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestId() string {
+	id := make([]byte, 16)
+	// Reading from crypto/rand doesn't fail on the platforms the API runs on
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -57,6 +57,14 @@ func metricsHandlerFunc(c *gin.Context) {
 //	@scope.read:counter						Grants access to counter get request
 //	@scope.create:counter					Grants access to counter post request
 //	@scope.update:counter					Grants access to counter patch request
+//	@scope.share:counter					Grants access to sharing counters with other users and groups
+//	@scope.manage:webhooks					Grants access to webhook subscriptions
+//	@scope.manage:apikeys					Grants access to issuing and revoking API keys
+
+//	@securitydefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						X-API-Key
+//	@description				Key issued with POST /apikeys, for services that can't sign in interactively
 
 // @externalDocs.description	GitHub repository
 // @externalDocs.url			https://github.com/Steadfastie/gokube
@@ -72,28 +80,72 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	router := gin.New()
+	// Otherwise every client could pick its own IP with X-Forwarded-For, and with it a fresh rate limit
+	if err := router.SetTrustedProxies(infra.GetConfig().TrustedProxies); err != nil {
+		zap.L().Fatal("Trusted proxies are invalid", zap.Error(err))
+	}
 
 	router.Use(cors.Default())
 	router.Use(metricsHandlerFunc)
-	router.Use(gin.RecoveryWithWriter(gin.DefaultErrorWriter, infra.RecoveryMiddleware))
+	router.Use(infra.RequestIdMiddleware)
+	// Renders what the handlers, and the recovery after them, record as errors
+	router.Use(infra.ErrorMiddleware())
+	router.Use(gin.RecoveryWithWriter(gin.DefaultErrorWriter, infra.RecoveryMiddleware()))
 	router.Use(gin.LoggerWithWriter(gin.DefaultWriter, "/health"))
 
 	counterController := infra.GetCounterController()
+	statsController := infra.GetStatsController()
+	webhookController := infra.GetWebhookController()
+	apiKeyController := infra.GetApiKeyController()
+	rateLimit := infra.RateLimitMiddleware()
 
 	// Configure endpoints
 	var api = router.Group("/api")
+	// Runs before authentication, which would otherwise be unlimited for bad credentials
+	api.Use(infra.IpRateLimitMiddleware())
 	{
-		api.GET("/panic/:type", handlers.PanicHandler)
+		api.GET("/panic/:type", rateLimit, handlers.PanicHandler)
 		counter := api.Group("/counter")
 		{
-			counter.GET(":id", infra.AuthMiddleware(infra.ReadCounterScope), counterController.GetByIdHandler)
-			counter.POST("", infra.AuthMiddleware(infra.CreateCounterScope), counterController.CreateHandler)
-			counter.PATCH(":id", infra.AuthMiddleware(infra.ReadCounterScope, infra.UpdateCounterScope), counterController.PatchHandler)
+			counter.GET(":id", infra.AuthMiddleware(), rateLimit, counterController.GetByIdHandler)
+			counter.POST("", infra.AuthMiddleware(), rateLimit, counterController.CreateHandler)
+			counter.PATCH(":id", infra.AuthMiddleware(), rateLimit, counterController.PatchHandler)
+			counter.GET(":id/stats", infra.AuthMiddleware(), rateLimit, statsController.GetCounterStatsHandler)
+			counter.GET(":id/access", infra.AuthMiddleware(), rateLimit, counterController.GetAccessHandler)
+			counter.PUT(":id/access", infra.AuthMiddleware(), rateLimit, counterController.GrantAccessHandler)
+			counter.DELETE(":id/access/:kind/:principal", infra.AuthMiddleware(), rateLimit, counterController.RevokeAccessHandler)
+		}
+		stats := api.Group("/stats")
+		{
+			stats.GET("/daily", infra.AuthMiddleware(), rateLimit, statsController.GetDailyStatsHandler)
+		}
+		webhooks := api.Group("/webhooks")
+		{
+			webhooks.POST("", infra.AuthMiddleware(), rateLimit, webhookController.CreateHandler)
+			webhooks.GET("", infra.AuthMiddleware(), rateLimit, webhookController.ListHandler)
+			webhooks.DELETE(":id", infra.AuthMiddleware(), rateLimit, webhookController.DeleteHandler)
+			webhooks.GET(":id/deliveries", infra.AuthMiddleware(), rateLimit, webhookController.ListDeliveriesHandler)
+		}
+		apiKeys := api.Group("/apikeys")
+		{
+			apiKeys.POST("", infra.AuthMiddleware(), rateLimit, apiKeyController.CreateHandler)
+			apiKeys.GET("", infra.AuthMiddleware(), rateLimit, apiKeyController.ListHandler)
+			apiKeys.DELETE(":id", infra.AuthMiddleware(), rateLimit, apiKeyController.RevokeHandler)
+		}
+		if devTokenController, ok := infra.GetDevTokenController(); ok {
+			api.POST("/dev/token", rateLimit, devTokenController.MintHandler)
 		}
 	}
 	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerfiles.Handler))
 	router.GET("/health", handlers.HealthHandler)
+	config := infra.GetConfig()
+	if unregistered := config.Auth.Policies.Unregistered(router.Routes()); len(unregistered) > 0 {
+		zap.L().Fatal("Auth policies refer to routes that don't exist", zap.Strings("routes", unregistered))
+	}
+	if unregistered := config.RateLimits.Routes.Unregistered(router.Routes()); len(unregistered) > 0 {
+		zap.L().Fatal("Rate limits refer to routes that don't exist", zap.Strings("routes", unregistered))
+	}
 
 	// Run
 	srv := &http.Server{
@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/steadfastie/gokube/data/brocker"
+	"github.com/steadfastie/gokube/data/events"
+)
+
+// Command is a one-off maintenance task run through the consumer binary, e.g. `consumer replay-dlq -idle 5s`
+type Command func(ctx context.Context, args []string) error
+
+var registry = map[string]Command{
+	"replay-dlq":    ReplayDeadLetters,
+	"rebuild-stats": RebuildStats,
+	"reset-offsets": ResetOffsets,
+	"replay-events": ReplayEvents,
+}
+
+func Run(ctx context.Context, name string, args []string) error {
+	command, ok := registry[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return command(ctx, args)
+}
+
+func decodeEvent(message *brocker.Message) (*events.CounterEvent, error) {
+	var event events.CounterEvent
+	if err := json.Unmarshal(message.Value, &event); err != nil {
+		return nil, err
+	}
+	tenant, _ := message.Header(brocker.HeaderTenant)
+	if err := event.CheckTenant(tenant); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
@@ -0,0 +1,155 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	infra "github.com/steadfastie/gokube/consumer/infrastructure"
+	"github.com/steadfastie/gokube/data/brocker"
+	"github.com/steadfastie/gokube/data/repositories"
+	"go.uber.org/zap"
+)
+
+type resetFlags struct {
+	to        *string
+	timestamp *string
+	offset    *int64
+	partition *int
+}
+
+func bindResetFlags(flags *flag.FlagSet) *resetFlags {
+	return &resetFlags{
+		to:        flags.String("to", string(brocker.ResetToEarliest), "earliest, latest, timestamp or offset"),
+		timestamp: flags.String("timestamp", "", "RFC 3339 moment to start from, used with -to timestamp"),
+		offset:    flags.Int64("offset", 0, "offset to start from, used with -to offset"),
+		partition: flags.Int("partition", -1, "single partition to reset, every partition by default"),
+	}
+}
+
+func (flags *resetFlags) reset() (brocker.OffsetReset, error) {
+	reset := brocker.OffsetReset{
+		Mode:      brocker.ResetMode(*flags.to),
+		Offset:    *flags.offset,
+		Partition: *flags.partition,
+	}
+
+	if reset.Mode == brocker.ResetToTimestamp {
+		timestamp, err := time.Parse(time.RFC3339, *flags.timestamp)
+		if err != nil {
+			return reset, fmt.Errorf("timestamp must be in RFC 3339 format: %w", err)
+		}
+		reset.Timestamp = timestamp
+	}
+	return reset, nil
+}
+
+// ResetOffsets moves the counter-consumer group, so that consumers reprocess history once restarted.
+// Every consumer of the group has to be stopped beforehand
+func ResetOffsets(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("reset-offsets", flag.ContinueOnError)
+	resetFlags := bindResetFlags(flags)
+	group := flags.String("group", brocker.CounterConsumerGroup, "consumer group to reset")
+	dryRun := flags.Bool("dry-run", false, "only report what would be replayed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	reset, err := resetFlags.reset()
+	if err != nil {
+		return err
+	}
+
+	logger := infra.GetLogger()
+	admin := infra.GetAdmin()
+	topic := infra.GetTopics().Counter
+
+	plan, err := admin.PlanReset(ctx, topic, *group, reset)
+	if err != nil {
+		return err
+	}
+
+	replayed := int64(0)
+	for _, partition := range plan {
+		replayed += partition.Replayed()
+		logger.Info(
+			"Partition reset plan",
+			zap.Int("partition", partition.Partition),
+			zap.Int64("committed", partition.Committed),
+			zap.Int64("target", partition.Target),
+			zap.Int64("replayed", partition.Replayed()),
+		)
+	}
+	logger.Info("Messages to be replayed", zap.String("group", *group), zap.Int64("replayed", replayed), zap.Bool("dryRun", *dryRun))
+
+	if *dryRun {
+		return nil
+	}
+	return admin.CommitOffsets(ctx, topic, *group, plan)
+}
+
+// ReplayEvents archives the topic history into a separate collection without touching any consumer group,
+// so that the outcome can be compared with the live events collection before swapping them
+func ReplayEvents(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("replay-events", flag.ContinueOnError)
+	resetFlags := bindResetFlags(flags)
+	collection := flags.String("collection", "", "collection to archive the events into, e.g. events_replay")
+	dryRun := flags.Bool("dry-run", false, "only report how many messages would be replayed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *collection == "" || *collection == repositories.EventsCollection {
+		return errors.New("replaying requires a -collection other than the live one")
+	}
+
+	reset, err := resetFlags.reset()
+	if err != nil {
+		return err
+	}
+
+	logger := infra.GetLogger()
+	admin := infra.GetAdmin()
+	topic := infra.GetTopics().Counter
+
+	plan, err := admin.PlanReset(ctx, topic, brocker.CounterConsumerGroup, reset)
+	if err != nil {
+		return err
+	}
+
+	total := int64(0)
+	for _, partition := range plan {
+		total += partition.Last - partition.Target
+	}
+	logger.Info("Messages to be replayed", zap.String("collection", *collection), zap.Int64("messages", total), zap.Bool("dryRun", *dryRun))
+
+	if *dryRun {
+		return nil
+	}
+
+	repo := infra.NewEventsRepositoryFor(*collection)
+	saved, skipped := 0, 0
+	for _, partition := range plan {
+		// Reads up to the high-water mark seen while planning, so that the replay has an end
+		err := admin.ReadPartition(ctx, topic, partition.Partition, partition.Target, partition.Last, func(message *brocker.Message) error {
+			event, err := decodeEvent(message)
+			if err != nil {
+				logger.Warn("Skipping unrecognized message", zap.Int("partition", message.Partition), zap.Int64("offset", message.Offset), zap.Error(err))
+				skipped++
+				return nil
+			}
+			if err := repo.SaveEvent(ctx, event); err != nil {
+				return err
+			}
+			saved++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Info("Events are replayed", zap.String("collection", *collection), zap.Int("saved", saved), zap.Int("skipped", skipped))
+	return nil
+}
@@ -0,0 +1,14 @@
+package commands
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReplayEventsRejectsLiveCollection(t *testing.T) {
+	for _, args := range [][]string{{}, {"-collection", ""}, {"-collection", "events"}} {
+		if err := ReplayEvents(context.Background(), args); err == nil {
+			t.Errorf("ReplayEvents(%v) was accepted, want the live collection refused", args)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package commands
+
+import (
+	"context"
+	"flag"
+
+	infra "github.com/steadfastie/gokube/consumer/infrastructure"
+	"github.com/steadfastie/gokube/data/events"
+	"go.uber.org/zap"
+)
+
+// RebuildStats drops the counter_stats projection and folds every archived event into it again.
+// It is the only way into the projection that skips the inbox. Running consumers keep projecting new events meanwhile,
+// which the projection tolerates as long as they are among the latest events applied to their counter and day
+func RebuildStats(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("rebuild-stats", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	logger := infra.GetLogger()
+	eventsRepo := infra.GetEventsRepository()
+	statsRepo := infra.GetStatsRepository()
+
+	if err := statsRepo.Reset(ctx); err != nil {
+		return err
+	}
+
+	applied := 0
+	err := eventsRepo.ForEach(ctx, func(event *events.CounterEvent) error {
+		if err := statsRepo.ReplayEvent(ctx, event); err != nil {
+			return err
+		}
+		applied++
+		if applied%1000 == 0 {
+			logger.Info("Rebuilding stats", zap.Int("applied", applied))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Stats are rebuilt", zap.Int("applied", applied))
+	return nil
+}
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"time"
+
+	infra "github.com/steadfastie/gokube/consumer/infrastructure"
+	"github.com/steadfastie/gokube/data/brocker"
+	"go.uber.org/zap"
+)
+
+// ReplayDeadLetters moves dead-lettered messages back onto the main topic.
+// It stops once the dead-letter topic stays silent for the idle period or the limit is reached
+func ReplayDeadLetters(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("replay-dlq", flag.ContinueOnError)
+	limit := flags.Int("max", 0, "maximum number of messages to replay, 0 replays everything")
+	idle := flags.Duration("idle", 10*time.Second, "stop after no message arrived for this long")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	logger := infra.GetLogger()
+
+	consumer := infra.NewDeadLetterConsumer(ctx)
+	defer consumer.Disconnect()
+
+	producer := infra.NewCounterProducer(ctx)
+	defer producer.Disconnect()
+
+	messageChan := make(chan *brocker.Message, 1)
+	errChan := make(chan error, 1)
+
+	replayed := 0
+	for *limit == 0 || replayed < *limit {
+		idleCtx, cancel := context.WithTimeout(ctx, *idle)
+		go consumer.RecieveMessage(idleCtx, messageChan, errChan)
+
+		var message *brocker.Message
+		select {
+		case message = <-messageChan:
+			cancel()
+		case err := <-errChan:
+			cancel()
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				logger.Info("Dead-letter topic is drained", zap.Int("replayed", replayed))
+				return nil
+			}
+			return err
+		}
+
+		if err := producer.PublishMessage(ctx, brocker.StripDeadLetterHeaders(message)); err != nil {
+			return err
+		}
+		if err := consumer.CommitMessage(ctx, message); err != nil {
+			return err
+		}
+
+		reason, _ := message.Header(brocker.HeaderError)
+		logger.Info("Replayed dead-lettered message", zap.Int64("offset", message.Offset), zap.String("reason", reason))
+		replayed++
+	}
+
+	logger.Info("Replay limit reached", zap.Int("replayed", replayed))
+	return nil
+}
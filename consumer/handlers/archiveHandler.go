@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/steadfastie/gokube/consumer/pipeline"
+	"github.com/steadfastie/gokube/data/events"
+	"github.com/steadfastie/gokube/data/repositories"
+)
+
+const ArchiveHandlerName = "archive"
+
+// NewArchiveHandler keeps every consumed event in the events collection
+func NewArchiveHandler(repo repositories.EventsRepository) pipeline.Handler {
+	return pipeline.HandlerFunc(func(ctx context.Context, event *events.CounterEvent) error {
+		return repo.SaveEvent(ctx, event)
+	})
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/steadfastie/gokube/consumer/pipeline"
+	"github.com/steadfastie/gokube/data/events"
+	"github.com/steadfastie/gokube/data/repositories"
+)
+
+const StatsHandlerName = "stats"
+
+// NewStatsHandler folds events into the counter_stats projection. It has to be registered behind
+// pipeline.Deduplicate, as the projection counts every event it is given
+func NewStatsHandler(repo repositories.StatsRepository) pipeline.Handler {
+	return pipeline.HandlerFunc(func(ctx context.Context, event *events.CounterEvent) error {
+		return repo.ApplyEvent(ctx, event)
+	})
+}
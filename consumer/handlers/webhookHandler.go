@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/steadfastie/gokube/consumer/pipeline"
+	"github.com/steadfastie/gokube/data"
+	"github.com/steadfastie/gokube/data/events"
+	"github.com/steadfastie/gokube/data/repositories"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const WebhookHandlerName = "webhook"
+
+const (
+	SignatureHeader = "X-Gokube-Signature"
+	EventHeader     = "X-Gokube-Event"
+	EventIdHeader   = "X-Gokube-Event-Id"
+)
+
+type WebhookSettings struct {
+	// Timeout of a single POST
+	Timeout     time.Duration
+	MaxAttempts int
+	// Delay before the second attempt, doubled after every failed one
+	Backoff time.Duration
+	// Subscriptions are disabled after this many events in a row could not be delivered
+	DisableAfter int
+}
+
+// Budget is the longest a delivery to a single subscription may take, retries included
+func (settings WebhookSettings) Budget() time.Duration {
+	budget := time.Duration(settings.MaxAttempts) * settings.Timeout
+	for attempt := 1; attempt < settings.MaxAttempts; attempt++ {
+		budget += settings.Backoff << (attempt - 1)
+	}
+	return budget
+}
+
+type webhookHandler struct {
+	repo     repositories.WebhookRepository
+	client   *http.Client
+	settings WebhookSettings
+	logger   *zap.Logger
+}
+
+// NewWebhookHandler POSTs events to the matching subscriptions, signed with the subscription secret.
+// Subscriptions whose host resolves to an internal address fail to deliver
+func NewWebhookHandler(repo repositories.WebhookRepository, settings WebhookSettings, logger *zap.Logger) pipeline.Handler {
+	return &webhookHandler{
+		repo:     repo,
+		client:   &http.Client{Timeout: settings.Timeout, Transport: data.WebhookTransport(settings.Timeout)},
+		settings: settings,
+		logger:   logger,
+	}
+}
+
+func (handler *webhookHandler) Handle(ctx context.Context, event *events.CounterEvent) error {
+	webhooks, err := handler.repo.FindMatching(ctx, event)
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error happened while marshalling event %v: %w", event.EventId.Hex(), err)
+	}
+
+	// Subscriptions don't wait for each other, and a failing one never fails the event
+	done := make(chan struct{}, len(webhooks))
+	for i := range webhooks {
+		go func(webhook *data.WebhookDocument) {
+			defer func() { done <- struct{}{} }()
+			handler.deliver(ctx, webhook, event, body)
+		}(&webhooks[i])
+	}
+	for range webhooks {
+		<-done
+	}
+	return nil
+}
+
+func (handler *webhookHandler) deliver(ctx context.Context, webhook *data.WebhookDocument, event *events.CounterEvent, body []byte) {
+	backoff := handler.settings.Backoff
+attempts:
+	for attempt := 1; attempt <= handler.settings.MaxAttempts; attempt++ {
+		delivery := handler.post(ctx, webhook, event, body, attempt)
+		if err := handler.repo.LogDelivery(ctx, delivery); err != nil {
+			handler.logger.Warn("Could not log webhook delivery", zap.Error(err))
+		}
+
+		if delivery.Succeeded {
+			if err := handler.repo.RecordSuccess(ctx, webhook.Id); err != nil {
+				handler.logger.Warn("Could not record webhook success", zap.Error(err))
+			}
+			return
+		}
+
+		if attempt == handler.settings.MaxAttempts {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			break attempts
+		}
+		backoff *= 2
+	}
+
+	disabled, err := handler.repo.RecordFailure(ctx, webhook.Id, handler.settings.DisableAfter)
+	if err != nil {
+		handler.logger.Warn("Could not record webhook failure", zap.Error(err))
+		return
+	}
+	if disabled {
+		handler.logger.Warn("Webhook disabled after persistent failures",
+			zap.String("webhook", webhook.Id.Hex()),
+			zap.String("url", webhook.Url))
+	}
+}
+
+func (handler *webhookHandler) post(ctx context.Context, webhook *data.WebhookDocument, event *events.CounterEvent, body []byte, attempt int) *data.WebhookDelivery {
+	started := time.Now().UTC()
+	delivery := &data.WebhookDelivery{
+		Id:             primitive.NewObjectID(),
+		SubscriptionId: webhook.Id,
+		EventId:        event.EventId,
+		Attempt:        attempt,
+		DeliveredAt:    started,
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, string(event.What))
+	request.Header.Set(EventIdHeader, event.EventId.Hex())
+	request.Header.Set(SignatureHeader, Sign(webhook.Secret, started, body))
+
+	response, err := handler.client.Do(request)
+	delivery.Duration = time.Since(started)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer response.Body.Close()
+
+	delivery.StatusCode = response.StatusCode
+	delivery.Succeeded = response.StatusCode >= 200 && response.StatusCode < 300
+	if !delivery.Succeeded {
+		delivery.Error = response.Status
+	}
+	return delivery
+}
+
+// Sign produces `t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">`.
+// Receivers recompute it with their secret and should reject stale timestamps
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,9 +1,14 @@
 package infrastructure
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/steadfastie/gokube/consumer/handlers"
+	"github.com/steadfastie/gokube/data/brocker"
 	"github.com/steadfastie/gokube/data/errors"
 	"github.com/steadfastie/gokube/data/services"
 )
@@ -13,14 +18,51 @@ const (
 	EnvMongoDatabase         = "MONGO_DATABASE"
 	EnvLogLevel              = "LOGLEVEL"
 	EnvCron                  = "CRON"
-	EnvKafkaAddresses        = "KAFKA_ADDRESSES"
+	EnvDeadLetterTopic       = "DEAD_LETTER_TOPIC"
+	EnvConsumerMode          = "CONSUMER_MODE"
+	EnvConcurrency           = "CONCURRENCY"
+	EnvMaxAttempts           = "MAX_ATTEMPTS"
+	EnvRetryTiers            = "RETRY_TIERS"
+	EnvHandlerTimeout        = "HANDLER_TIMEOUT"
+	EnvWebhookTimeout        = "WEBHOOK_TIMEOUT"
+	EnvWebhookMaxAttempts    = "WEBHOOK_MAX_ATTEMPTS"
+	EnvWebhookBackoff        = "WEBHOOK_BACKOFF"
+	EnvWebhookDisableAfter   = "WEBHOOK_DISABLE_AFTER"
+	EnvMaxLag                = "MAX_LAG"
+	EnvLagInterval           = "LAG_INTERVAL"
+	EnvInboxTTL              = "INBOX_TTL"
+)
+
+type ConsumerMode string
+
+const (
+	// StreamMode keeps a long-running fetch loop open against the broker
+	StreamMode ConsumerMode = "stream"
+	// CronMode reads a single message per cron tick. Meant for constrained environments
+	CronMode ConsumerMode = "cron"
 )
 
 type Config struct {
-	MongoSettings services.MongoSettings
-	LogLevel      string
-	Cron          string
-	KafkaServers  []string
+	MongoSettings  services.MongoSettings
+	LogLevel       string
+	Cron           string
+	Broker         brocker.BrokerSettings
+	Mode           ConsumerMode
+	Concurrency    int
+	MaxAttempts    int
+	RetryTiers     []RetryTierSettings
+	HandlerTimeout time.Duration
+	Webhooks       handlers.WebhookSettings
+	MaxLag         int64
+	LagInterval    time.Duration
+	InboxTTL       time.Duration
+}
+
+// RetryTierSettings describes a delayed retry topic named after the tier, e.g. counter.retry.5s
+type RetryTierSettings struct {
+	Name     string
+	Delay    time.Duration
+	Attempts int
 }
 
 func (config *Config) GetMongoSettings() services.MongoSettings {
@@ -52,23 +94,187 @@ func NewConfig() (*Config, error) {
 		cronExpression = "*/5 * * * * *" // Defaults to every 5 seconds
 	}
 
-	kafkaBootstrapServer := os.Getenv(EnvKafkaAddresses)
-	addresses := []string{}
-	if kafkaBootstrapServer == "" {
-		addresses = append(addresses, "localhost")
-	} else {
-		addresses = append(addresses, strings.Split(kafkaBootstrapServer, ",")...)
+	mode := ConsumerMode(strings.ToLower(os.Getenv(EnvConsumerMode)))
+	switch mode {
+	case "":
+		mode = StreamMode
+	case StreamMode, CronMode:
+	default:
+		panic(errors.NewBusinessRuleError("Consumer mode must be either stream or cron"))
+	}
+
+	concurrency := 4 // Defaults to 4 messages in flight
+	if value := os.Getenv(EnvConcurrency); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			panic(errors.NewBusinessRuleError("Concurrency must be a positive integer"))
+		}
+		concurrency = parsed
+	}
+
+	maxAttempts := 3 // Defaults to 3 attempts before a message is dead-lettered
+	if value := os.Getenv(EnvMaxAttempts); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			panic(errors.NewBusinessRuleError("Max attempts must be a positive integer"))
+		}
+		maxAttempts = parsed
+	}
+
+	retryTiers := os.Getenv(EnvRetryTiers)
+	if retryTiers == "" {
+		retryTiers = "5s,1m" // Defaults to a single pass through counter.retry.5s and counter.retry.1m
+	}
+	tiers, err := parseRetryTiers(retryTiers)
+	if err != nil {
+		panic(errors.NewBusinessRuleError(err.Error()))
+	}
+
+	handlerTimeout := 10 * time.Second // Defaults to 10 seconds per handler
+	if value := os.Getenv(EnvHandlerTimeout); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			panic(errors.NewBusinessRuleError("Handler timeout must be a positive duration"))
+		}
+		handlerTimeout = parsed
+	}
+
+	webhookTimeout := 5 * time.Second // Defaults to 5 seconds per POST
+	if value := os.Getenv(EnvWebhookTimeout); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			panic(errors.NewBusinessRuleError("Webhook timeout must be a positive duration"))
+		}
+		webhookTimeout = parsed
 	}
 
+	webhookMaxAttempts := 3 // Defaults to 3 POSTs per event and subscription
+	if value := os.Getenv(EnvWebhookMaxAttempts); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			panic(errors.NewBusinessRuleError("Webhook max attempts must be a positive integer"))
+		}
+		webhookMaxAttempts = parsed
+	}
+
+	webhookBackoff := 500 * time.Millisecond // Defaults to 0.5s, 1s, 2s... between POSTs
+	if value := os.Getenv(EnvWebhookBackoff); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			panic(errors.NewBusinessRuleError("Webhook backoff must be a positive duration"))
+		}
+		webhookBackoff = parsed
+	}
+
+	webhookDisableAfter := 10 // Defaults to disabling a subscription after 10 undelivered events in a row
+	if value := os.Getenv(EnvWebhookDisableAfter); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			panic(errors.NewBusinessRuleError("Webhook disable threshold must be a positive integer"))
+		}
+		webhookDisableAfter = parsed
+	}
+
+	maxLag := int64(1000) // Defaults to 1000 messages behind across partitions
+	if value := os.Getenv(EnvMaxLag); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			panic(errors.NewBusinessRuleError("Max lag must be a non-negative integer"))
+		}
+		maxLag = parsed
+	}
+
+	lagInterval := 15 * time.Second // Defaults to reading group offsets every 15 seconds
+	if value := os.Getenv(EnvLagInterval); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			panic(errors.NewBusinessRuleError("Lag interval must be a positive duration"))
+		}
+		lagInterval = parsed
+	}
+
+	inboxTTL := 7 * 24 * time.Hour // Defaults to a week, well beyond the retry tiers
+	if value := os.Getenv(EnvInboxTTL); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < time.Second {
+			panic(errors.NewBusinessRuleError("Inbox TTL must be a duration of at least one second"))
+		}
+		inboxTTL = parsed
+	}
+
+	broker := brocker.SettingsFromEnv()
+
+	deadLetterTopic := os.Getenv(EnvDeadLetterTopic)
+	if deadLetterTopic == "" {
+		deadLetterTopic = broker.Topics.Counter + ".dlq"
+	}
+	if !brocker.ValidTopicName(deadLetterTopic) {
+		panic(errors.NewBusinessRuleError("Dead-letter topic may only contain letters, digits, '.', '_' and '-'"))
+	}
+
+	broker.Topics.DeadLetter = deadLetterTopic
+
 	config := &Config{
 		MongoSettings: services.MongoSettings{
 			ConnectionString: mongoConnectionString,
 			Database:         mongoDatabase,
 		},
-		LogLevel:     logLevel,
-		Cron:         cronExpression,
-		KafkaServers: addresses,
+		LogLevel:       logLevel,
+		Cron:           cronExpression,
+		Broker:         broker,
+		Mode:           mode,
+		Concurrency:    concurrency,
+		MaxAttempts:    maxAttempts,
+		RetryTiers:     tiers,
+		HandlerTimeout: handlerTimeout,
+		Webhooks: handlers.WebhookSettings{
+			Timeout:      webhookTimeout,
+			MaxAttempts:  webhookMaxAttempts,
+			Backoff:      webhookBackoff,
+			DisableAfter: webhookDisableAfter,
+		},
+		MaxLag:      maxLag,
+		LagInterval: lagInterval,
+		InboxTTL:    inboxTTL,
 	}
 
 	return config, nil
 }
+
+// Parses tiers written as `delay[:attempts]`, e.g. "5s:3,1m,10m". "none" disables retry topics
+func parseRetryTiers(value string) ([]RetryTierSettings, error) {
+	if strings.EqualFold(value, "none") {
+		return nil, nil
+	}
+
+	tiers := []RetryTierSettings{}
+	seen := map[string]bool{}
+	for _, token := range strings.Split(value, ",") {
+		name, attemptsValue, hasAttempts := strings.Cut(strings.TrimSpace(token), ":")
+		if seen[name] {
+			// Each tier owns a topic and a consumer group named after it, so a repeated name would share both
+			return nil, fmt.Errorf("retry tier %q is listed twice, use %s:<attempts> to repeat it", name, name)
+		}
+		seen[name] = true
+
+		delay, err := time.ParseDuration(name)
+		if err != nil || delay <= 0 {
+			return nil, fmt.Errorf("retry tier %q must start with a positive duration", token)
+		}
+
+		attempts := 1
+		if hasAttempts {
+			attempts, err = strconv.Atoi(attemptsValue)
+			if err != nil || attempts < 1 {
+				return nil, fmt.Errorf("retry tier %q must have a positive number of attempts", token)
+			}
+		}
+
+		tiers = append(tiers, RetryTierSettings{
+			Name:     name,
+			Delay:    delay,
+			Attempts: attempts,
+		})
+	}
+	return tiers, nil
+}
@@ -0,0 +1,52 @@
+package infrastructure
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRetryTiers(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		tiers []RetryTierSettings
+		valid bool
+	}{
+		{"tiers with and without attempts", "5s:3,1m,10m", []RetryTierSettings{
+			{Name: "5s", Delay: 5 * time.Second, Attempts: 3},
+			{Name: "1m", Delay: time.Minute, Attempts: 1},
+			{Name: "10m", Delay: 10 * time.Minute, Attempts: 1},
+		}, true},
+		{"spaces around tiers", " 5s:2 , 1m ", []RetryTierSettings{
+			{Name: "5s", Delay: 5 * time.Second, Attempts: 2},
+			{Name: "1m", Delay: time.Minute, Attempts: 1},
+		}, true},
+		{"none", "none", nil, true},
+		{"none in capitals", "NONE", nil, true},
+		{"empty", "", nil, false},
+		{"empty tier", "5s,,1m", nil, false},
+		{"not a duration", "soon", nil, false},
+		{"zero delay", "0s", nil, false},
+		{"negative delay", "-5s", nil, false},
+		{"zero attempts", "5s:0", nil, false},
+		{"negative attempts", "5s:-1", nil, false},
+		{"attempts not a number", "5s:many", nil, false},
+		{"missing attempts", "5s:", nil, false},
+		{"missing delay", ":3", nil, false},
+		{"duplicate tier", "5s,5s", nil, false},
+		{"duplicate tier with attempts", "5s:2,1m,5s", nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tiers, err := parseRetryTiers(test.value)
+			if test.valid != (err == nil) {
+				t.Fatalf("parseRetryTiers(%q) error = %v, want valid %v", test.value, err, test.valid)
+			}
+			if !reflect.DeepEqual(tiers, test.tiers) {
+				t.Errorf("parseRetryTiers(%q) = %+v, want %+v", test.value, tiers, test.tiers)
+			}
+		})
+	}
+}
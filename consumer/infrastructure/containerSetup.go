@@ -5,13 +5,18 @@ import (
 	"log"
 
 	"github.com/golobby/container/v3"
+	"github.com/steadfastie/gokube/consumer/handlers"
 	"github.com/steadfastie/gokube/consumer/job"
+	"github.com/steadfastie/gokube/consumer/pipeline"
+	"github.com/steadfastie/gokube/data"
 	"github.com/steadfastie/gokube/data/brocker"
 	"github.com/steadfastie/gokube/data/repositories"
 	"github.com/steadfastie/gokube/data/services"
 	"go.uber.org/zap"
 )
 
+const deadLettersBinding = "deadLetters"
+
 func InitializeServices(ctx context.Context, logger *zap.Logger) {
 	err := container.Singleton(func() (*Config, error) {
 		return NewConfig()
@@ -34,13 +39,53 @@ func InitializeServices(ctx context.Context, logger *zap.Logger) {
 		log.Fatalf("can't register MongoDB client: %v", err)
 	}
 
-	err = container.Singleton(func(config *Config, logger *zap.Logger) brocker.Consumer {
-		return brocker.NewConsumer(ctx, logger, config.KafkaServers...)
+	err = container.Singleton(func(config *Config, mongodb *services.MongoDB, logger *zap.Logger) (brocker.Broker, error) {
+		return brocker.NewBroker(ctx, config.Broker, mongodb, logger)
+	})
+	if err != nil {
+		log.Fatalf("can't register broker: %v", err)
+	}
+
+	err = container.Singleton(func(broker brocker.Broker, logger *zap.Logger) brocker.Admin {
+		return broker.NewAdmin(logger)
+	})
+	if err != nil {
+		log.Fatalf("can't register broker admin: %v", err)
+	}
+
+	err = container.Call(func(config *Config, admin brocker.Admin, logger *zap.Logger) error {
+		return brocker.Provision(ctx, admin, config.Broker, logger, provisionedTopics(config)...)
+	})
+	if err != nil {
+		log.Fatalf("can't provision topics: %v", err)
+	}
+
+	err = container.Singleton(func(config *Config, broker brocker.Broker, logger *zap.Logger) brocker.Consumer {
+		return broker.NewConsumer(ctx, logger, config.Broker.Topics.Counter, brocker.CounterConsumerGroup)
 	})
 	if err != nil {
 		log.Fatalf("can't register Basic repo: %v", err)
 	}
 
+	err = container.NamedSingleton(deadLettersBinding, func(config *Config, broker brocker.Broker, logger *zap.Logger) brocker.Producer {
+		return broker.NewWriter(ctx, logger, config.Broker.Topics.DeadLetter)
+	})
+	if err != nil {
+		log.Fatalf("can't register dead-letter producer: %v", err)
+	}
+
+	err = container.Singleton(func(config *Config, admin brocker.Admin, logger *zap.Logger) *job.LagMonitor {
+		return job.NewLagMonitor(admin, logger, job.LagSettings{
+			Topic:    config.Broker.Topics.Counter,
+			Group:    brocker.CounterConsumerGroup,
+			Interval: config.LagInterval,
+			MaxLag:   config.MaxLag,
+		})
+	})
+	if err != nil {
+		log.Fatalf("can't register lag monitor: %v", err)
+	}
+
 	err = container.Singleton(func(mongodb *services.MongoDB, logger *zap.Logger) repositories.EventsRepository {
 		return repositories.NewEventsRepository(mongodb, logger)
 	})
@@ -48,8 +93,50 @@ func InitializeServices(ctx context.Context, logger *zap.Logger) {
 		log.Fatalf("can't register Basic repo: %v", err)
 	}
 
-	err = container.Singleton(func(mongodb *services.MongoDB, consumer brocker.Consumer, repo repositories.EventsRepository, logger *zap.Logger) job.ConsumerProcessor {
-		return job.NewConsumerProcessor(mongodb, consumer, repo, logger)
+	err = container.Singleton(func(mongodb *services.MongoDB, logger *zap.Logger) repositories.StatsRepository {
+		return repositories.NewStatsRepository(mongodb, logger)
+	})
+	if err != nil {
+		log.Fatalf("can't register stats repo: %v", err)
+	}
+
+	err = container.Singleton(func(mongodb *services.MongoDB, logger *zap.Logger) repositories.WebhookRepository {
+		return repositories.NewWebhookRepository(mongodb, logger)
+	})
+	if err != nil {
+		log.Fatalf("can't register webhook repo: %v", err)
+	}
+
+	err = container.Singleton(func(config *Config, mongodb *services.MongoDB, logger *zap.Logger) (repositories.InboxRepository, error) {
+		return repositories.NewInboxRepository(ctx, mongodb, logger, config.InboxTTL)
+	})
+	if err != nil {
+		log.Fatalf("can't register inbox repo: %v", err)
+	}
+
+	err = container.Singleton(func(config *Config, broker brocker.Broker, logger *zap.Logger) []job.RetryTier {
+		return newRetryTiers(ctx, config, broker, logger)
+	})
+	if err != nil {
+		log.Fatalf("can't register retry tiers: %v", err)
+	}
+
+	err = container.Singleton(func(config *Config, inbox repositories.InboxRepository, eventsRepo repositories.EventsRepository, statsRepo repositories.StatsRepository, webhookRepo repositories.WebhookRepository, logger *zap.Logger) *pipeline.Router {
+		return newRouter(config, inbox, eventsRepo, statsRepo, webhookRepo, logger)
+	})
+	if err != nil {
+		log.Fatalf("can't register handler router: %v", err)
+	}
+
+	err = container.Singleton(func(config *Config, mongodb *services.MongoDB, consumer brocker.Consumer, tiers []job.RetryTier, router *pipeline.Router, logger *zap.Logger) job.ConsumerProcessor {
+		var deadLetters brocker.Producer
+		container.NamedResolve(&deadLetters, deadLettersBinding)
+
+		settings := job.ProcessorSettings{
+			Concurrency: config.Concurrency,
+			MaxAttempts: config.MaxAttempts,
+		}
+		return job.NewConsumerProcessor(mongodb, consumer, deadLetters, tiers, router, logger, settings)
 	})
 	if err != nil {
 		log.Fatalf("can't register Basic repo: %v", err)
@@ -60,6 +147,16 @@ func DisconnectServices(ctx context.Context) {
 	container.Call(func(consumer brocker.Consumer) {
 		consumer.Disconnect()
 	})
+	var deadLetters brocker.Producer
+	if err := container.NamedResolve(&deadLetters, deadLettersBinding); err == nil {
+		deadLetters.Disconnect()
+	}
+	container.Call(func(tiers []job.RetryTier) {
+		for _, tier := range tiers {
+			tier.Consumer.Disconnect()
+			tier.Producer.Disconnect()
+		}
+	})
 	container.Call(func(mongodb *services.MongoDB, logger *zap.Logger) {
 		mongodb.DisconnectMongoClient(ctx, logger)
 	})
@@ -74,21 +171,150 @@ func GetCron() string {
 	return config.Cron
 }
 
+// Every event handler of the consumer is registered here
+// Handlers whose side effects must not repeat on redelivery opt into pipeline.Deduplicate
+func newRouter(config *Config, inbox repositories.InboxRepository, eventsRepo repositories.EventsRepository, statsRepo repositories.StatsRepository, webhookRepo repositories.WebhookRepository, logger *zap.Logger) *pipeline.Router {
+	router := pipeline.NewRouter(
+		logger,
+		pipeline.Recovery(logger),
+		pipeline.Logging(logger),
+		pipeline.Metrics(),
+	)
+
+	router.Register(pipeline.Registration{
+		Name:        handlers.ArchiveHandlerName,
+		Handler:     handlers.NewArchiveHandler(eventsRepo),
+		Policy:      pipeline.Retry,
+		Middlewares: []pipeline.Middleware{pipeline.Timeout(config.HandlerTimeout)},
+	})
+	router.Register(pipeline.Registration{
+		Name:        handlers.StatsHandlerName,
+		Handler:     handlers.NewStatsHandler(statsRepo),
+		Events:      []data.EventType{data.CounterCreated, data.CounterUpdated},
+		Policy:      pipeline.Retry,
+		Middlewares: []pipeline.Middleware{pipeline.Timeout(config.HandlerTimeout), pipeline.Deduplicate(inbox)},
+	})
+	// Webhooks retry on their own schedule and never send the event through the retry topics
+	router.Register(pipeline.Registration{
+		Name:        handlers.WebhookHandlerName,
+		Handler:     handlers.NewWebhookHandler(webhookRepo, config.Webhooks, logger),
+		Policy:      pipeline.Ignore,
+		Middlewares: []pipeline.Middleware{pipeline.Timeout(config.HandlerTimeout + config.Webhooks.Budget())},
+	})
+
+	return router
+}
+
+// Retry topics are only provisioned in the mode that uses them
+func provisionedTopics(config *Config) []string {
+	topics := []string{config.Broker.Topics.Counter, config.Broker.Topics.DeadLetter}
+	if config.Mode == StreamMode {
+		for _, settings := range config.RetryTiers {
+			topics = append(topics, config.Broker.Topics.Retry(settings.Name))
+		}
+	}
+	return topics
+}
+
+// Retry topics are only read by the streaming loop, so the cron mode sends failures straight to the dead-letter topic
+func newRetryTiers(ctx context.Context, config *Config, broker brocker.Broker, logger *zap.Logger) []job.RetryTier {
+	tiers := []job.RetryTier{}
+	if config.Mode != StreamMode {
+		return tiers
+	}
+
+	for _, settings := range config.RetryTiers {
+		topic := config.Broker.Topics.Retry(settings.Name)
+		tiers = append(tiers, job.RetryTier{
+			Name:     settings.Name,
+			Delay:    settings.Delay,
+			Attempts: settings.Attempts,
+			Producer: broker.NewWriter(ctx, logger, topic),
+			Consumer: broker.NewConsumer(ctx, logger, topic, brocker.RetryConsumerGroup(settings.Name)),
+		})
+	}
+	return tiers
+}
+
+func GetLogger() *zap.Logger {
+	var logger *zap.Logger
+	container.Resolve(&logger)
+	return logger
+}
+
+// NewDeadLetterConsumer reads the dead-letter topic on behalf of the replay command
+func NewDeadLetterConsumer(ctx context.Context) brocker.Consumer {
+	var broker brocker.Broker
+	container.Resolve(&broker)
+	return broker.NewConsumer(ctx, GetLogger(), GetTopics().DeadLetter, brocker.DeadLetterReplayConsumerGroup)
+}
+
+// NewCounterProducer writes back to the main topic on behalf of the replay command
+func NewCounterProducer(ctx context.Context) brocker.Producer {
+	var broker brocker.Broker
+	container.Resolve(&broker)
+	return broker.NewWriter(ctx, GetLogger(), GetTopics().Counter)
+}
+
+func GetTopics() brocker.TopicSettings {
+	var config *Config
+	container.Resolve(&config)
+	return config.Broker.Topics
+}
+
+func GetEventsRepository() repositories.EventsRepository {
+	var repo repositories.EventsRepository
+	container.Resolve(&repo)
+	return repo
+}
+
+// NewEventsRepositoryFor archives events into the given collection on behalf of the replay command
+func NewEventsRepositoryFor(collection string) repositories.EventsRepository {
+	var mongodb *services.MongoDB
+	container.Resolve(&mongodb)
+	return repositories.NewEventsRepositoryFor(mongodb, GetLogger(), collection)
+}
+
+func GetAdmin() brocker.Admin {
+	var admin brocker.Admin
+	container.Resolve(&admin)
+	return admin
+}
+
+func GetLagMonitor() *job.LagMonitor {
+	var monitor *job.LagMonitor
+	container.Resolve(&monitor)
+	return monitor
+}
+
+func GetStatsRepository() repositories.StatsRepository {
+	var repo repositories.StatsRepository
+	container.Resolve(&repo)
+	return repo
+}
+
+func GetMode() ConsumerMode {
+	var config *Config
+	container.Resolve(&config)
+	return config.Mode
+}
+
 func GetConsumerProcessor() job.ConsumerProcessor {
 	var processor job.ConsumerProcessor
 	container.Resolve(&processor)
 	return processor
 }
 
-func CheckConnections(ctx context.Context) bool {
+// CheckConnections also reports what the broker knows about its connection, so that a failing probe tells why
+func CheckConnections(ctx context.Context) (bool, brocker.Health) {
 	var mongodb *services.MongoDB
 	container.Resolve(&mongodb)
 
-	var broker brocker.Consumer
+	var broker brocker.Broker
 	container.Resolve(&broker)
 
 	mongoConnHealthy := mongodb.CheckConnection(ctx)
-	brokerConnHealthy := broker.CheckConnection()
+	brokerHealth := broker.Health(ctx)
 
-	return mongoConnHealthy && brokerConnHealthy
+	return mongoConnHealthy && brokerHealth.Healthy, brokerHealth
 }
@@ -3,40 +3,74 @@ package job
 import (
 	"context"
 	"encoding/json"
+	"sync"
+	"time"
 
+	"github.com/avast/retry-go"
+	"github.com/steadfastie/gokube/consumer/pipeline"
 	"github.com/steadfastie/gokube/data/brocker"
 	"github.com/steadfastie/gokube/data/events"
-	"github.com/steadfastie/gokube/data/repositories"
 	"github.com/steadfastie/gokube/data/services"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.uber.org/zap"
 )
 
 type ConsumerProcessor interface {
+	// Process reads a single message. Used by the cron mode
 	Process(ctx context.Context)
+	// Run keeps fetching messages until the context is cancelled
+	Run(ctx context.Context)
 }
 
 const collection = "counter"
 
+// Pause before asking the broker again after a failed read
+const readErrorBackoff = time.Second
+
+type ProcessorSettings struct {
+	// How many messages are handled at the same time
+	Concurrency int
+	// How many times a message is handled on each delivery before it is escalated
+	MaxAttempts int
+}
+
+// RetryTier is a delayed retry topic. A failed message goes through every tier
+// as many times as the tier allows before it ends up in the dead-letter topic
+type RetryTier struct {
+	Name     string
+	Delay    time.Duration
+	Attempts int
+	// Writes to the tier topic
+	Producer brocker.Producer
+	// Reads from the tier topic
+	Consumer brocker.Consumer
+}
+
 type consumerProcessor struct {
-	Collection *mongo.Collection
-	Consumer   brocker.Consumer
-	EventsRepo repositories.EventsRepository
-	Logger     *zap.Logger
+	Collection  *mongo.Collection
+	Consumer    brocker.Consumer
+	DeadLetters brocker.Producer
+	RetryTiers  []RetryTier
+	Router      *pipeline.Router
+	Logger      *zap.Logger
+	Settings    ProcessorSettings
 }
 
-func NewConsumerProcessor(mongodb *services.MongoDB, consumer brocker.Consumer, repo repositories.EventsRepository, logger *zap.Logger) ConsumerProcessor {
+func NewConsumerProcessor(mongodb *services.MongoDB, consumer brocker.Consumer, deadLetters brocker.Producer, retryTiers []RetryTier, router *pipeline.Router, logger *zap.Logger, settings ProcessorSettings) ConsumerProcessor {
 	return &consumerProcessor{
-		Collection: mongodb.MongoDB.Collection(collection),
-		Consumer:   consumer,
-		EventsRepo: repo,
-		Logger:     logger,
+		Collection:  mongodb.MongoDB.Collection(collection),
+		Consumer:    consumer,
+		DeadLetters: deadLetters,
+		RetryTiers:  retryTiers,
+		Router:      router,
+		Logger:      logger,
+		Settings:    settings,
 	}
 }
 
 func (processor *consumerProcessor) Process(ctx context.Context) {
 	processor.Logger.Info("Standing by for messages")
-	messageChan := make(chan []byte)
+	messageChan := make(chan *brocker.Message)
 	errChan := make(chan error)
 
 	defer close(messageChan)
@@ -46,13 +80,215 @@ func (processor *consumerProcessor) Process(ctx context.Context) {
 
 	select {
 	case message := <-messageChan:
-		var event events.CounterEvent
-		if err := json.Unmarshal(message, &event); err != nil {
-			processor.Logger.Error("Consumer could not recognize message", zap.Error(err))
-		}
-		processor.Logger.Info("Received message", zap.Any("event", event))
-		go processor.EventsRepo.SaveEvent(ctx, &event)
+		processor.handleMessage(ctx, processor.Consumer, message)
 	case err := <-errChan:
 		processor.Logger.Error("Could not receive messages", zap.Error(err))
 	}
 }
+
+func (processor *consumerProcessor) Run(ctx context.Context) {
+	processor.Logger.Info("Starting consumer loop", zap.Int("concurrency", processor.Settings.Concurrency))
+
+	var tiers sync.WaitGroup
+	for _, tier := range processor.RetryTiers {
+		tiers.Add(1)
+		go func(tier RetryTier) {
+			defer tiers.Done()
+			processor.runRetryTier(ctx, tier)
+		}(tier)
+	}
+	defer tiers.Wait()
+
+	// Buffered, so that the reading goroutine never hangs once the loop stops listening
+	messageChan := make(chan *brocker.Message, 1)
+	errChan := make(chan error, 1)
+
+	// In-flight messages are allowed to finish after shutdown was requested
+	handlerCtx := context.WithoutCancel(ctx)
+	slots := make(chan struct{}, processor.Settings.Concurrency)
+	var wg sync.WaitGroup
+
+	defer processor.Logger.Info("Consumer loop stopped")
+	defer wg.Wait()
+
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		message, ok := processor.fetch(ctx, processor.Consumer, messageChan, errChan)
+		if !ok {
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			processor.handleMessage(handlerCtx, processor.Consumer, message)
+		}()
+	}
+}
+
+// Messages of a tier share the same delay, so they become due in the order they were written.
+// Waiting for the head of the topic is therefore enough to respect every due time
+func (processor *consumerProcessor) runRetryTier(ctx context.Context, tier RetryTier) {
+	processor.Logger.Info("Starting retry loop", zap.String("tier", tier.Name), zap.Duration("delay", tier.Delay))
+
+	messageChan := make(chan *brocker.Message, 1)
+	errChan := make(chan error, 1)
+	handlerCtx := context.WithoutCancel(ctx)
+
+	for {
+		message, ok := processor.fetch(ctx, tier.Consumer, messageChan, errChan)
+		if !ok {
+			return
+		}
+
+		if due, ok := brocker.RetryDue(message); ok {
+			// Tier delays may well exceed the time the broker waits for an acknowledgement
+			stop := brocker.KeepAlive(tier.Consumer, message)
+			select {
+			case <-time.After(time.Until(due)):
+				stop()
+			case <-ctx.Done():
+				stop()
+				// Stays uncommitted and is picked up again after a restart
+				return
+			}
+		}
+
+		processor.handleMessage(handlerCtx, tier.Consumer, message)
+	}
+}
+
+// Waits for the next message. Returns false once the context is cancelled
+func (processor *consumerProcessor) fetch(ctx context.Context, consumer brocker.Consumer, messageChan chan *brocker.Message, errChan chan error) (*brocker.Message, bool) {
+	for {
+		go consumer.RecieveMessage(ctx, messageChan, errChan)
+
+		select {
+		case message := <-messageChan:
+			return message, true
+		case err := <-errChan:
+			if ctx.Err() != nil {
+				return nil, false
+			}
+			processor.Logger.Error("Could not receive messages", zap.Error(err))
+			select {
+			case <-time.After(readErrorBackoff):
+			case <-ctx.Done():
+				return nil, false
+			}
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// Offset is committed only once the event is either handled, scheduled for a retry or dead-lettered.
+// A message that could be none of these stays uncommitted and gets redelivered after a restart or a rebalance
+func (processor *consumerProcessor) handleMessage(ctx context.Context, consumer brocker.Consumer, message *brocker.Message) {
+	// Attempts and their backoff may take longer than the broker waits for an acknowledgement
+	defer brocker.KeepAlive(consumer, message)()
+
+	var event events.CounterEvent
+	if err := json.Unmarshal(message.Value, &event); err != nil {
+		// Redelivery won't make it any more readable
+		processor.Logger.Error("Consumer could not recognize message", zap.Int64("offset", message.Offset), zap.Error(err))
+		processor.deadLetter(ctx, consumer, message, err, 1)
+		return
+	}
+	// Events of an invalid tenant would end up in the data of another one, and redelivery won't fix them either
+	tenant, _ := message.Header(brocker.HeaderTenant)
+	if err := event.CheckTenant(tenant); err != nil {
+		processor.Logger.Error("Consumer rejected the tenant of a message", zap.Int64("offset", message.Offset), zap.Error(err))
+		processor.deadLetter(ctx, consumer, message, err, 1)
+		return
+	}
+	processor.Logger.Info("Received message", zap.Any("event", event))
+
+	// A retried message only reaches the handlers that failed, and so does every next attempt
+	handlers := brocker.RetryHandlers(message)
+	err := retry.Do(
+		func() error {
+			err := processor.Router.Dispatch(ctx, &event, handlers)
+			if failed := pipeline.FailedHandlers(err); failed != nil {
+				handlers = failed
+			}
+			return err
+		},
+		retry.Context(ctx),
+		retry.Attempts(uint(processor.Settings.MaxAttempts)),
+		retry.OnRetry(func(n uint, err error) {
+			processor.Logger.Warn("Retrying", zap.Uint("RetryAttempt", n), zap.String("id", event.EventId.Hex()), zap.Error(err))
+		}),
+		retry.LastErrorOnly(true),
+	)
+	if err != nil {
+		processor.escalate(ctx, consumer, message, err, handlers)
+		return
+	}
+	consumer.CommitMessage(ctx, message)
+	observe(message, outcomeHandled)
+}
+
+// Sends the message to the next retry tier, or to the dead-letter topic once every tier is exhausted
+func (processor *consumerProcessor) escalate(ctx context.Context, consumer brocker.Consumer, message *brocker.Message, cause error, handlers []string) {
+	count := brocker.RetryCount(message)
+	attempts := (count + 1) * processor.Settings.MaxAttempts
+
+	tier, ok := processor.nextTier(count)
+	if !ok {
+		processor.deadLetter(ctx, consumer, message, cause, attempts)
+		return
+	}
+
+	retryMessage := brocker.NewRetryMessage(message, cause, count+1, time.Now().Add(tier.Delay), handlers)
+	if err := tier.Producer.PublishMessage(ctx, retryMessage); err != nil {
+		processor.Logger.Error("Message was not scheduled for retry, offset stays uncommitted", zap.Int64("offset", message.Offset), zap.Error(err))
+		observe(message, outcomeUncommitted)
+		return
+	}
+	processor.Logger.Warn(
+		"Message was scheduled for retry",
+		zap.String("tier", tier.Name),
+		zap.Int("retry", count+1),
+		zap.Int("attempts", attempts),
+		zap.Strings("handlers", handlers),
+		zap.Error(cause),
+	)
+	consumer.CommitMessage(ctx, message)
+	observe(message, outcomeRetried)
+}
+
+// Tiers are walked in order, each one as many times as it allows
+func (processor *consumerProcessor) nextTier(retries int) (RetryTier, bool) {
+	for _, tier := range processor.RetryTiers {
+		if retries < tier.Attempts {
+			return tier, true
+		}
+		retries -= tier.Attempts
+	}
+	return RetryTier{}, false
+}
+
+func (processor *consumerProcessor) deadLetter(ctx context.Context, consumer brocker.Consumer, message *brocker.Message, cause error, attempts int) {
+	if err := processor.DeadLetters.PublishMessage(ctx, brocker.NewDeadLetter(message, cause, attempts)); err != nil {
+		processor.Logger.Error("Message was not dead-lettered, offset stays uncommitted", zap.Int64("offset", message.Offset), zap.Error(err))
+		observe(message, outcomeUncommitted)
+		return
+	}
+	processor.Logger.Warn(
+		"Message was sent to the dead-letter topic",
+		zap.String("topic", message.Topic),
+		zap.Int("partition", message.Partition),
+		zap.Int64("offset", message.Offset),
+		zap.Int("attempts", attempts),
+		zap.Error(cause),
+	)
+	consumer.CommitMessage(ctx, message)
+	observe(message, outcomeDeadLettered)
+}
@@ -0,0 +1,235 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/steadfastie/gokube/consumer/pipeline"
+	"github.com/steadfastie/gokube/data"
+	"github.com/steadfastie/gokube/data/brocker"
+	"github.com/steadfastie/gokube/data/events"
+	"go.uber.org/zap"
+)
+
+func TestNextTier(t *testing.T) {
+	processor := &consumerProcessor{RetryTiers: []RetryTier{
+		{Name: "5s", Attempts: 3},
+		{Name: "1m", Attempts: 1},
+		{Name: "10m", Attempts: 1},
+	}}
+
+	tests := []struct {
+		retries int
+		tier    string
+		ok      bool
+	}{
+		{0, "5s", true},
+		{1, "5s", true},
+		{2, "5s", true},
+		{3, "1m", true},
+		{4, "10m", true},
+		{5, "", false},
+		{6, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(strconv.Itoa(test.retries), func(t *testing.T) {
+			tier, ok := processor.nextTier(test.retries)
+			if ok != test.ok || tier.Name != test.tier {
+				t.Errorf("nextTier(%d) = %q, %v, want %q, %v", test.retries, tier.Name, ok, test.tier, test.ok)
+			}
+		})
+	}
+}
+
+func TestNextTierWithoutTiers(t *testing.T) {
+	processor := &consumerProcessor{}
+	if tier, ok := processor.nextTier(0); ok {
+		t.Errorf("nextTier(0) = %q, want the dead-letter topic", tier.Name)
+	}
+}
+
+// A message that keeps failing walks every tier as many times as it allows and ends up in the dead-letter topic
+func TestEscalation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	logger := zap.NewNop()
+	broker := brocker.NewMemoryBroker(1)
+
+	failure := errors.New("projection is down")
+	router := pipeline.NewRouter(logger)
+	router.Register(pipeline.Registration{
+		Name: "projection",
+		Handler: pipeline.HandlerFunc(func(ctx context.Context, event *events.CounterEvent) error {
+			return failure
+		}),
+	})
+	router.Register(pipeline.Registration{
+		Name: "archive",
+		Handler: pipeline.HandlerFunc(func(ctx context.Context, event *events.CounterEvent) error {
+			return nil
+		}),
+	})
+
+	tiers := []RetryTier{}
+	for _, settings := range []struct {
+		name     string
+		attempts int
+	}{{"5s", 2}, {"1m", 1}} {
+		tiers = append(tiers, RetryTier{
+			Name:     settings.name,
+			Delay:    time.Hour,
+			Attempts: settings.attempts,
+			Producer: broker.NewWriter(ctx, logger, "counter-retry-"+settings.name),
+			Consumer: broker.NewConsumer(ctx, logger, "counter-retry-"+settings.name, brocker.RetryConsumerGroup(settings.name)),
+		})
+	}
+
+	processor := &consumerProcessor{
+		Consumer:    broker.NewConsumer(ctx, logger, "counter", brocker.CounterConsumerGroup),
+		DeadLetters: broker.NewWriter(ctx, logger, "counter-dlq"),
+		RetryTiers:  tiers,
+		Router:      router,
+		Logger:      logger,
+		Settings:    ProcessorSettings{Concurrency: 1, MaxAttempts: 2},
+	}
+	if err := broker.NewWriter(ctx, logger, "counter").PublishMessage(ctx, &brocker.Message{Key: []byte("key"), Value: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		consumer brocker.Consumer
+		// Tier the message was scheduled for, empty for the original one
+		tier string
+	}{
+		{processor.Consumer, ""},
+		{tiers[0].Consumer, "5s"},
+		{tiers[0].Consumer, "5s"},
+		{tiers[1].Consumer, "1m"},
+	}
+
+	var handled time.Time
+	for i, step := range steps {
+		message := receive(t, ctx, step.consumer)
+		if step.tier != "" {
+			if count := brocker.RetryCount(message); count != i {
+				t.Errorf("step %d: retry count = %d, want %d", i, count, i)
+			}
+			if due, ok := brocker.RetryDue(message); !ok || due.Before(handled.Add(time.Hour).Truncate(time.Millisecond)) {
+				t.Errorf("step %d: retry due = %v, %v, want an hour after the failure", i, due, ok)
+			}
+			if handlers := brocker.RetryHandlers(message); len(handlers) != 1 || handlers[0] != "projection" {
+				t.Errorf("step %d: retry handlers = %v, want only the failed one", i, handlers)
+			}
+			if value, _ := message.Header(brocker.HeaderRetryError); value == "" {
+				t.Errorf("step %d: retry error header is missing", i)
+			}
+		}
+
+		handled = time.Now()
+		processor.handleMessage(ctx, step.consumer, message)
+	}
+
+	deadLetter := receive(t, ctx, broker.NewConsumer(ctx, logger, "counter-dlq", "test"))
+	if attempts, _ := deadLetter.Header(brocker.HeaderAttempts); attempts != "8" {
+		t.Errorf("dead letter attempts = %q, want 8", attempts)
+	}
+	if source, _ := deadLetter.Header(brocker.HeaderSourceTopic); source != "counter-retry-1m" {
+		t.Errorf("dead letter source topic = %q, want the last tier", source)
+	}
+	if value, _ := deadLetter.Header(brocker.HeaderError); value == "" {
+		t.Error("dead letter error header is missing")
+	}
+	if _, ok := deadLetter.Header(brocker.HeaderRetryCount); ok {
+		t.Error("dead letter keeps the retry headers")
+	}
+}
+
+// The event names the tenant. Messages whose header disagrees, or whose tenant is invalid, are dead-lettered
+func TestHandleMessageTenant(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		header  string
+		// Empty when the message is dead-lettered
+		want string
+	}{
+		{"header agrees", `{"tenant":"acme"}`, "acme", "acme"},
+		{"payload without header", `{"tenant":"acme"}`, "", "acme"},
+		{"event written before tenants", `{}`, data.DefaultTenant, data.DefaultTenant},
+		{"header names another tenant", `{"tenant":"acme"}`, "globex", ""},
+		{"header moves an old event", `{}`, "globex", ""},
+		{"tenant starting with a digit", `{"tenant":"2024-01-01"}`, "2024-01-01", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			logger := zap.NewNop()
+			broker := brocker.NewMemoryBroker(1)
+
+			var tenant string
+			handled := false
+			router := pipeline.NewRouter(logger)
+			router.Register(pipeline.Registration{
+				Name: "projection",
+				Handler: pipeline.HandlerFunc(func(ctx context.Context, event *events.CounterEvent) error {
+					handled = true
+					tenant = event.TenantOrDefault()
+					return nil
+				}),
+			})
+			processor := &consumerProcessor{
+				Consumer:    broker.NewConsumer(ctx, logger, "counter", brocker.CounterConsumerGroup),
+				DeadLetters: broker.NewWriter(ctx, logger, "counter-dlq"),
+				Router:      router,
+				Logger:      logger,
+				Settings:    ProcessorSettings{Concurrency: 1, MaxAttempts: 1},
+			}
+
+			message := &brocker.Message{Key: []byte("key"), Value: []byte(test.payload)}
+			if test.header != "" {
+				message.Headers = []brocker.Header{{Key: brocker.HeaderTenant, Value: []byte(test.header)}}
+			}
+			if err := broker.NewWriter(ctx, logger, "counter").PublishMessage(ctx, message); err != nil {
+				t.Fatal(err)
+			}
+
+			processor.handleMessage(ctx, processor.Consumer, receive(t, ctx, processor.Consumer))
+			if test.want == "" {
+				if handled {
+					t.Errorf("handler saw tenant %q, want the message dead-lettered", tenant)
+				}
+				deadLetter := receive(t, ctx, broker.NewConsumer(ctx, logger, "counter-dlq", "test"))
+				if value, _ := deadLetter.Header(brocker.HeaderError); value == "" {
+					t.Error("dead letter error header is missing")
+				}
+				return
+			}
+			if tenant != test.want {
+				t.Errorf("handler saw tenant %q, want %q", tenant, test.want)
+			}
+		})
+	}
+}
+
+func receive(t *testing.T, ctx context.Context, consumer brocker.Consumer) *brocker.Message {
+	t.Helper()
+	messageChan := make(chan *brocker.Message, 1)
+	errChan := make(chan error, 1)
+	go consumer.RecieveMessage(ctx, messageChan, errChan)
+
+	select {
+	case message := <-messageChan:
+		return message
+	case err := <-errChan:
+		t.Fatalf("Could not receive a message: %v", err)
+		return nil
+	}
+}
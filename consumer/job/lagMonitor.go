@@ -0,0 +1,107 @@
+package job
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steadfastie/gokube/data/brocker"
+	"go.uber.org/zap"
+)
+
+var partitionLag = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "consumer_partition_lag",
+		Help: "High-water mark minus the committed offset of the consumer group, partitioned by topic, group and partition.",
+	},
+	[]string{"topic", "group", "partition"},
+)
+
+func init() {
+	prometheus.MustRegister(partitionLag)
+}
+
+type LagSettings struct {
+	Topic string
+	Group string
+	// How often offsets are read from the broker
+	Interval time.Duration
+	// Readiness fails once the total lag of the group goes above it
+	MaxLag int64
+}
+
+// LagMonitor periodically compares the group offsets with the high-water marks
+type LagMonitor struct {
+	Admin    brocker.Admin
+	Logger   *zap.Logger
+	Settings LagSettings
+
+	mu       sync.RWMutex
+	lag      int64
+	measured bool
+	err      error
+}
+
+func NewLagMonitor(admin brocker.Admin, logger *zap.Logger, settings LagSettings) *LagMonitor {
+	return &LagMonitor{
+		Admin:    admin,
+		Logger:   logger,
+		Settings: settings,
+	}
+}
+
+// Run measures the lag right away and then on every interval until the context is cancelled
+func (monitor *LagMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(monitor.Settings.Interval)
+	defer ticker.Stop()
+
+	for {
+		monitor.measure(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (monitor *LagMonitor) measure(ctx context.Context) {
+	offsets, err := monitor.Admin.GroupOffsets(ctx, monitor.Settings.Topic, monitor.Settings.Group)
+	if err != nil {
+		if ctx.Err() == nil {
+			monitor.Logger.Warn("Could not measure consumer lag", zap.Error(err))
+		}
+		monitor.mu.Lock()
+		monitor.err = err
+		monitor.mu.Unlock()
+		return
+	}
+
+	var total int64
+	for _, partition := range offsets {
+		lag := partition.Lag()
+		total += lag
+		partitionLag.WithLabelValues(monitor.Settings.Topic, monitor.Settings.Group, strconv.Itoa(partition.Partition)).Set(float64(lag))
+	}
+
+	monitor.mu.Lock()
+	monitor.lag = total
+	monitor.measured = true
+	monitor.err = nil
+	monitor.mu.Unlock()
+}
+
+// Ready tells whether the group keeps up. Unknown lag, i.e. before the first measurement or
+// while the broker can't be asked, counts as not ready
+func (monitor *LagMonitor) Ready() (int64, bool) {
+	monitor.mu.RLock()
+	defer monitor.mu.RUnlock()
+
+	if !monitor.measured || monitor.err != nil {
+		return monitor.lag, false
+	}
+	return monitor.lag, monitor.lag <= monitor.Settings.MaxLag
+}
@@ -0,0 +1,45 @@
+package job
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steadfastie/gokube/data/brocker"
+)
+
+// Outcomes of a consumed message
+const (
+	outcomeHandled      = "handled"
+	outcomeRetried      = "retried"
+	outcomeDeadLettered = "dead_lettered"
+	outcomeUncommitted  = "uncommitted"
+)
+
+var (
+	processedMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "consumer_processed_messages_total",
+			Help: "How many messages were processed, partitioned by topic and outcome.",
+		},
+		[]string{"topic", "outcome"},
+	)
+	messageLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "consumer_message_latency_seconds",
+			Help:    "Time from the message being written to the broker until it was processed, partitioned by topic.",
+			Buckets: []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300, 900, 3600},
+		},
+		[]string{"topic"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(processedMessages, messageLatency)
+}
+
+func observe(message *brocker.Message, outcome string) {
+	processedMessages.WithLabelValues(message.Topic, outcome).Inc()
+	if !message.Time.IsZero() {
+		messageLatency.WithLabelValues(message.Topic).Observe(time.Since(message.Time).Seconds())
+	}
+}
@@ -2,22 +2,96 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/go-co-op/gocron/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/steadfastie/gokube/consumer/commands"
 	infra "github.com/steadfastie/gokube/consumer/infrastructure"
 	"github.com/steadfastie/gokube/consumer/job"
+	"github.com/steadfastie/gokube/data/brocker"
 	"go.uber.org/zap"
 )
 
 func main() {
-	ctx, _ := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	infra.InitializeServices(ctx, zap.L())
-	defer infra.DisconnectServices(ctx)
 
+	// Maintenance commands, e.g. `consumer replay-dlq`, run once and exit
+	if len(os.Args) > 1 {
+		err := commands.Run(ctx, os.Args[1], os.Args[2:])
+		infra.DisconnectServices(context.Background())
+		if err != nil {
+			log.Fatalf("%v command failed: %v", os.Args[1], err)
+		}
+		return
+	}
+
+	// Services are disconnected after ctx is cancelled, so they get a fresh one
+	defer infra.DisconnectServices(context.Background())
+
+	srv := &http.Server{
+		Addr:    ":8080",
+		Handler: healthHandler(),
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zap.L().Error("Could not serve health endpoint", zap.Error(err))
+		}
+	}()
+	go infra.GetLagMonitor().Run(ctx)
+
+	switch infra.GetMode() {
+	case infra.CronMode:
+		runScheduled(ctx)
+	default:
+		infra.GetConsumerProcessor().Run(ctx)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+	zap.L().Info("Consumer job exiting")
+}
+
+func healthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		healthy, broker := infra.CheckConnections(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if healthy {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(map[string]brocker.Health{"broker": broker})
+	})
+	// Unlike /health, readiness fails while the consumer falls behind, so traffic and alerts can react
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		lag, ready := infra.GetLagMonitor().Ready()
+		if ready {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintf(w, "lag: %d\n", lag)
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+// Reads a single message per cron tick. Kept for constrained environments
+func runScheduled(ctx context.Context) {
 	s, err := gocron.NewScheduler(
 		gocron.WithGlobalJobOptions(
 			gocron.WithSingletonMode(gocron.LimitModeReschedule),
@@ -39,26 +113,8 @@ func main() {
 			infra.GetConsumerProcessor(),
 		),
 	)
-	s.NewJob(
-		gocron.OneTimeJob(
-			gocron.OneTimeJobStartImmediately(),
-		),
-		gocron.NewTask(
-			func() {
-				http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-					if infra.CheckConnections(r.Context()) {
-						w.WriteHeader(http.StatusOK)
-					} else {
-						w.WriteHeader(http.StatusInternalServerError)
-					}
-				})
-				http.ListenAndServe(":8080", nil)
-			},
-		),
-	)
 	s.Start()
 
 	<-ctx.Done()
 	s.Shutdown()
-	zap.L().Info("Consumer job exiting")
 }
@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steadfastie/gokube/data/events"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+var (
+	handledEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "consumer_handled_events_total",
+			Help: "How many events were handled, partitioned by handler, event type and outcome.",
+		},
+		[]string{"handler", "event", "outcome"},
+	)
+	handlerDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "consumer_handler_duration_seconds",
+			Help:    "How long handlers took to process an event, partitioned by handler and event type.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"handler", "event"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(handledEvents, handlerDuration)
+}
+
+func Logging(logger *zap.Logger) Middleware {
+	return func(name string, next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, event *events.CounterEvent) error {
+			start := time.Now()
+			err := next.Handle(ctx, event)
+
+			fields := []zap.Field{
+				zap.String("handler", name),
+				zap.String("id", event.EventId.Hex()),
+				zap.String("what", string(event.What)),
+				zap.Duration("duration", time.Since(start)),
+			}
+			if err != nil {
+				logger.Error("Handler failed", append(fields, zap.Error(err))...)
+			} else {
+				logger.Debug("Handler succeeded", fields...)
+			}
+			return err
+		})
+	}
+}
+
+func Metrics() Middleware {
+	return func(name string, next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, event *events.CounterEvent) error {
+			start := time.Now()
+			err := next.Handle(ctx, event)
+
+			outcome := "success"
+			if err != nil {
+				outcome = "failure"
+			}
+			handlerDuration.WithLabelValues(name, string(event.What)).Observe(time.Since(start).Seconds())
+			handledEvents.WithLabelValues(name, string(event.What), outcome).Inc()
+			return err
+		})
+	}
+}
+
+// Recovery turns a panicking handler into a failed one
+func Recovery(logger *zap.Logger) Middleware {
+	return func(name string, next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, event *events.CounterEvent) (err error) {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					logger.Error("Handler panicked", zap.String("handler", name), zap.Any("panic", recovered), zap.Stack("stack"))
+					err = fmt.Errorf("handler %v panicked: %v", name, recovered)
+				}
+			}()
+			return next.Handle(ctx, event)
+		})
+	}
+}
+
+func Timeout(timeout time.Duration) Middleware {
+	return func(name string, next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, event *events.CounterEvent) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return next.Handle(ctx, event)
+		})
+	}
+}
+
+// Inbox records which handler has processed which event
+type Inbox interface {
+	Process(ctx context.Context, eventId primitive.ObjectID, handler string, apply func(ctx context.Context) error) (bool, error)
+}
+
+// Deduplicate runs the handler at most once per event. Writes the handler makes with the given
+// context are committed together with the inbox entry, so a redelivered event has no side effects
+func Deduplicate(inbox Inbox) Middleware {
+	return func(name string, next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, event *events.CounterEvent) error {
+			_, err := inbox.Process(ctx, event.EventId, name, func(ctx context.Context) error {
+				return next.Handle(ctx, event)
+			})
+			return err
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/steadfastie/gokube/data"
+	"github.com/steadfastie/gokube/data/events"
+	"go.uber.org/zap"
+)
+
+// Handler reacts to a single counter event, e.g. archives it or updates a projection
+type Handler interface {
+	Handle(ctx context.Context, event *events.CounterEvent) error
+}
+
+type HandlerFunc func(ctx context.Context, event *events.CounterEvent) error
+
+func (f HandlerFunc) Handle(ctx context.Context, event *events.CounterEvent) error {
+	return f(ctx, event)
+}
+
+// Middleware wraps a handler. The name of the wrapped handler is handed over for logs and metrics
+type Middleware func(name string, next Handler) Handler
+
+// FailurePolicy tells what a failed handler means for the message
+type FailurePolicy int
+
+const (
+	// Retry sends the message through the retry topics and eventually the dead-letter topic.
+	// Only the handlers that failed run again
+	Retry FailurePolicy = iota
+	// Ignore logs the failure and lets the message be committed
+	Ignore
+)
+
+type Registration struct {
+	Name    string
+	Handler Handler
+	// Event types the handler is interested in. Empty means every type
+	Events      []data.EventType
+	Policy      FailurePolicy
+	Middlewares []Middleware
+}
+
+type route struct {
+	name    string
+	handler Handler
+	policy  FailurePolicy
+}
+
+// Router dispatches every event to the handlers registered for its type.
+// Handlers run independently, so a broken one doesn't keep the others from doing their job
+type Router struct {
+	logger      *zap.Logger
+	middlewares []Middleware
+	routes      map[data.EventType][]route
+	catchAll    []route
+}
+
+// Middlewares given here wrap every handler, outermost first
+func NewRouter(logger *zap.Logger, middlewares ...Middleware) *Router {
+	return &Router{
+		logger:      logger,
+		middlewares: middlewares,
+		routes:      map[data.EventType][]route{},
+	}
+}
+
+func (router *Router) Register(registration Registration) {
+	middlewares := append(slices.Clone(router.middlewares), registration.Middlewares...)
+
+	handler := registration.Handler
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](registration.Name, handler)
+	}
+
+	r := route{
+		name:    registration.Name,
+		handler: handler,
+		policy:  registration.Policy,
+	}
+	if len(registration.Events) == 0 {
+		router.catchAll = append(router.catchAll, r)
+		return
+	}
+	for _, eventType := range registration.Events {
+		router.routes[eventType] = append(router.routes[eventType], r)
+	}
+}
+
+// DispatchError lists handlers that failed under the Retry policy
+type DispatchError struct {
+	Failures map[string]error
+}
+
+func (err *DispatchError) Error() string {
+	messages := make([]string, 0, len(err.Failures))
+	for _, name := range err.Handlers() {
+		messages = append(messages, fmt.Sprintf("%v: %v", name, err.Failures[name]))
+	}
+	return "handlers failed: " + strings.Join(messages, "; ")
+}
+
+func (err *DispatchError) Handlers() []string {
+	names := make([]string, 0, len(err.Failures))
+	for name := range err.Failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Dispatch runs the handlers of the event. When only is not empty, the handlers not listed there are skipped,
+// which is how a retried message reaches just the handlers that failed before
+func (router *Router) Dispatch(ctx context.Context, event *events.CounterEvent, only []string) error {
+	routes := append(slices.Clone(router.routes[event.What]), router.catchAll...)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failures := map[string]error{}
+
+	for _, r := range routes {
+		if len(only) > 0 && !slices.Contains(only, r.name) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(r route) {
+			defer wg.Done()
+
+			err := r.handler.Handle(ctx, event)
+			if err == nil {
+				return
+			}
+			if r.policy == Ignore {
+				router.logger.Warn("Handler failed, ignoring", zap.String("handler", r.name), zap.String("id", event.EventId.Hex()), zap.Error(err))
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			failures[r.name] = err
+		}(r)
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		return &DispatchError{Failures: failures}
+	}
+	return nil
+}
+
+// FailedHandlers returns the handlers to retry, or nil when the error did not come from a dispatch
+func FailedHandlers(err error) []string {
+	var dispatchErr *DispatchError
+	if errors.As(err, &dispatchErr) {
+		return dispatchErr.Handlers()
+	}
+	return nil
+}
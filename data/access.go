@@ -0,0 +1,126 @@
+package data
+
+import (
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AccessLevel string
+
+// Every level includes the ones before it
+const (
+	ReadAccess  AccessLevel = "read"
+	WriteAccess AccessLevel = "write"
+	AdminAccess AccessLevel = "admin"
+)
+
+var accessLevels = []AccessLevel{ReadAccess, WriteAccess, AdminAccess}
+
+// AtLeast lists the levels that include the level
+func (level AccessLevel) AtLeast() []AccessLevel {
+	for i, known := range accessLevels {
+		if known == level {
+			return accessLevels[i:]
+		}
+	}
+	return nil
+}
+
+// Allows tells whether the subject, or one of its groups, has at least the access level on the counter.
+// Mirrors the filter repositories query counters with
+func (document *CounterDocument) Allows(subject string, groups []string, access AccessLevel) bool {
+	if document.Owner == "" {
+		return false
+	}
+	if document.Owner == subject {
+		return true
+	}
+
+	levels := access.AtLeast()
+	for _, grant := range document.Grants {
+		if !slices.Contains(levels, grant.Access) {
+			continue
+		}
+		if grant.Matches(UserPrincipal, subject) {
+			return true
+		}
+		if grant.Kind == GroupPrincipal && slices.Contains(groups, grant.Principal) {
+			return true
+		}
+	}
+	return false
+}
+
+type PrincipalKind string
+
+const (
+	UserPrincipal  PrincipalKind = "user"
+	GroupPrincipal PrincipalKind = "group"
+)
+
+// AccessGrant gives a subject or every member of a group access to a counter
+type AccessGrant struct {
+	Kind      PrincipalKind `bson:"kind" json:"kind" example:"user"`
+	Principal string        `bson:"principal" json:"principal" example:"auth0|60c7c02ea38e3c3c4426c1bd"`
+	Access    AccessLevel   `bson:"access" json:"access" example:"write"`
+	GrantedBy string        `bson:"grantedBy" json:"grantedBy" example:"auth0|5f8d0d55b54764421b7156c9"`
+	GrantedAt time.Time     `bson:"grantedAt" json:"grantedAt" example:"2022-02-30T12:00:00Z"`
+}
+
+func (grant *AccessGrant) Matches(kind PrincipalKind, principal string) bool {
+	return grant.Kind == kind && grant.Principal == principal
+}
+
+type GrantAccessRequest struct {
+	Kind      PrincipalKind `json:"kind" binding:"required,oneof=user group" example:"user"`
+	Principal string        `json:"principal" binding:"required" example:"auth0|60c7c02ea38e3c3c4426c1bd"`
+	Access    AccessLevel   `json:"access" binding:"required,oneof=read write admin" example:"write"`
+}
+
+type CounterAccessResponse struct {
+	Owner  string        `json:"owner" example:"auth0|5f8d0d55b54764421b7156c9"`
+	Grants []AccessGrant `json:"grants"`
+}
+
+func (document *CounterDocument) MapToAccessResponseModel() *CounterAccessResponse {
+	grants := document.Grants
+	if grants == nil {
+		grants = []AccessGrant{}
+	}
+	return &CounterAccessResponse{
+		Owner:  document.Owner,
+		Grants: grants,
+	}
+}
+
+type CounterAccessEvent struct {
+	Type      EventType          `bson:"type"`
+	CounterId primitive.ObjectID `bson:"counterId"`
+	Kind      PrincipalKind      `bson:"kind"`
+	Principal string             `bson:"principal"`
+	Access    AccessLevel        `bson:"access,omitempty"`
+	UserAlias string             `bson:"userAlias"`
+}
+
+func NewAccessGrantedEvent(counterId primitive.ObjectID, grant *AccessGrant) *CounterAccessEvent {
+	return &CounterAccessEvent{
+		Type:      AccessGranted,
+		CounterId: counterId,
+		Kind:      grant.Kind,
+		Principal: grant.Principal,
+		Access:    grant.Access,
+		UserAlias: grant.GrantedBy,
+	}
+}
+
+func NewAccessRevokedEvent(counterId primitive.ObjectID, kind PrincipalKind, principal string, userAlias string) *CounterAccessEvent {
+	return &CounterAccessEvent{
+		Type:      AccessRevoked,
+		CounterId: counterId,
+		Kind:      kind,
+		Principal: principal,
+		UserAlias: userAlias,
+	}
+}
@@ -0,0 +1,125 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ApiKeyDocument keeps only the hash of the key, which is shown once when issued
+type ApiKeyDocument struct {
+	Id         primitive.ObjectID `bson:"_id"`
+	Name       string             `bson:"name"`
+	Prefix     string             `bson:"prefix"`
+	Hash       string             `bson:"hash"`
+	Subject    string             `bson:"subject"`
+	Scopes     []string           `bson:"scopes"`
+	Groups     []string           `bson:"groups,omitempty"`
+	CreatedBy  string             `bson:"createdBy"`
+	CreatedAt  time.Time          `bson:"createdAt"`
+	ExpiresAt  *time.Time         `bson:"expiresAt,omitempty"`
+	LastUsedAt *time.Time         `bson:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time         `bson:"revokedAt,omitempty"`
+	Tenant     string             `bson:"tenant,omitempty"`
+}
+
+// ApiKeyImpersonateScope lets a caller issue keys acting as subjects other than its own
+const ApiKeyImpersonateScope = "impersonate:apikeys"
+
+// How many characters of a key are kept to tell keys apart in listings
+const apiKeyPrefixLength = 11
+
+// Keys act within the tenant of whoever issued them
+func NewApiKeyDocument(key string, request *ApiKeyRequest, createdBy string, tenant string, now time.Time) *ApiKeyDocument {
+	id := primitive.NewObjectID()
+	subject := request.Subject
+	if subject == "" {
+		subject = "apikey|" + id.Hex()
+	}
+
+	var expiresAt *time.Time
+	if request.TTLSeconds > 0 {
+		expiration := now.Add(time.Duration(request.TTLSeconds) * time.Second)
+		expiresAt = &expiration
+	}
+
+	return &ApiKeyDocument{
+		Id:        id,
+		Name:      request.Name,
+		Prefix:    key[:apiKeyPrefixLength],
+		Hash:      HashApiKey(key),
+		Subject:   subject,
+		Scopes:    request.Scopes,
+		Groups:    request.Groups,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+		Tenant:    tenant,
+	}
+}
+
+// Keys are random enough for a plain hash, and a fast one keeps authentication cheap
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (document *ApiKeyDocument) Active(now time.Time) bool {
+	return document.RevokedAt == nil && (document.ExpiresAt == nil || now.Before(*document.ExpiresAt))
+}
+
+type ApiKeyRequest struct {
+	Name   string   `json:"name" binding:"required" example:"nightly-export"`
+	Scopes []string `json:"scopes" binding:"required,min=1" example:"read:counter"`
+	// Defaults to apikey|<key id>. Only the caller's own subject is accepted, unless it has the impersonate:apikeys scope
+	Subject string `json:"subject" example:"svc|nightly-export"`
+	// Only groups the caller belongs to
+	Groups []string `json:"groups" example:"team-a"`
+	// Keys without a TTL don't expire
+	TTLSeconds int `json:"ttlSeconds" binding:"min=0" example:"2592000"`
+}
+
+type ApiKeyResponse struct {
+	Id         primitive.ObjectID `json:"id" example:"60c7c02ea38e3c3c4426c1bd"`
+	Name       string             `json:"name" example:"nightly-export"`
+	Prefix     string             `json:"prefix" example:"gk_q3Zt9xW2"`
+	Subject    string             `json:"subject" example:"svc|nightly-export"`
+	Scopes     []string           `json:"scopes" example:"read:counter"`
+	Groups     []string           `json:"groups,omitempty" example:"team-a"`
+	CreatedBy  string             `json:"createdBy" example:"auth0|5f8d0d55b54764421b7156c9"`
+	CreatedAt  time.Time          `json:"createdAt" example:"2022-02-30T12:00:00Z"`
+	ExpiresAt  *time.Time         `json:"expiresAt,omitempty" example:"2022-03-30T12:00:00Z"`
+	LastUsedAt *time.Time         `json:"lastUsedAt,omitempty" example:"2022-03-01T12:00:00Z"`
+	RevokedAt  *time.Time         `json:"revokedAt,omitempty"`
+}
+
+// CreatedApiKeyResponse is the only response that carries the key itself
+type CreatedApiKeyResponse struct {
+	ApiKeyResponse
+	Key string `json:"key" example:"gk_q3Zt9xW2hY0mR7cV1bN4kP8sD6fG5jL3aQ2wE1rT0yU"`
+}
+
+func (document *ApiKeyDocument) MapToResponseModel() *ApiKeyResponse {
+	return &ApiKeyResponse{
+		Id:         document.Id,
+		Name:       document.Name,
+		Prefix:     document.Prefix,
+		Subject:    document.Subject,
+		Scopes:     document.Scopes,
+		Groups:     document.Groups,
+		CreatedBy:  document.CreatedBy,
+		CreatedAt:  document.CreatedAt,
+		ExpiresAt:  document.ExpiresAt,
+		LastUsedAt: document.LastUsedAt,
+		RevokedAt:  document.RevokedAt,
+	}
+}
+
+func (document *ApiKeyDocument) MapToCreatedResponseModel(key string) *CreatedApiKeyResponse {
+	return &CreatedApiKeyResponse{
+		ApiKeyResponse: *document.MapToResponseModel(),
+		Key:            key,
+	}
+}
@@ -0,0 +1,301 @@
+package brocker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+type ResetMode string
+
+const (
+	ResetToEarliest  ResetMode = "earliest"
+	ResetToLatest    ResetMode = "latest"
+	ResetToTimestamp ResetMode = "timestamp"
+	ResetToOffset    ResetMode = "offset"
+)
+
+// OffsetReset describes where a consumer group should continue reading from
+type OffsetReset struct {
+	Mode      ResetMode
+	Timestamp time.Time
+	Offset    int64
+	// Limits the reset to a single partition. Negative value stands for every partition
+	Partition int
+}
+
+// PartitionOffsets is the state of a consumer group on a single partition
+type PartitionOffsets struct {
+	Partition int
+	// Oldest offset still retained by the broker
+	First int64
+	// High-water mark, i.e. the offset the next written message gets
+	Last int64
+	// Next offset the group reads. Negative when the group never committed
+	Committed int64
+	// Offset the group would continue from after a reset
+	Target int64
+}
+
+// Lag is the number of messages the group has not consumed yet
+func (offsets PartitionOffsets) Lag() int64 {
+	if offsets.Committed < 0 {
+		return offsets.Last - offsets.First
+	}
+	return offsets.Last - offsets.Committed
+}
+
+// Replayed is the number of already consumed messages the group reads again after the reset
+func (offsets PartitionOffsets) Replayed() int64 {
+	if offsets.Committed < 0 || offsets.Target >= offsets.Committed {
+		return 0
+	}
+	return offsets.Committed - offsets.Target
+}
+
+type Admin interface {
+	// GroupOffsets reports every partition of the topic together with what the group has committed
+	GroupOffsets(ctx context.Context, topic string, group string) ([]PartitionOffsets, error)
+	// PlanReset fills in the target offsets without changing anything
+	PlanReset(ctx context.Context, topic string, group string, reset OffsetReset) ([]PartitionOffsets, error)
+	// CommitOffsets moves the group to the target offsets. The group must have no active members
+	CommitOffsets(ctx context.Context, topic string, group string, offsets []PartitionOffsets) error
+	// ReadPartition hands over messages of the partition in the [from, to) range, bypassing consumer groups
+	ReadPartition(ctx context.Context, topic string, partition int, from int64, to int64, handle func(message *Message) error) error
+}
+
+type kafkaAdmin struct {
+	Client     *kafka.Client
+	Connection *KafkaConnection
+	Logger     *zap.Logger
+}
+
+func NewAdmin(logger *zap.Logger, connection *KafkaConnection) Admin {
+	return &kafkaAdmin{
+		Client: &kafka.Client{
+			Addr:      kafka.TCP(connection.Addresses...),
+			Timeout:   10 * time.Second,
+			Transport: connection.Transport,
+		},
+		Connection: connection,
+		Logger:     logger,
+	}
+}
+
+func (admin *kafkaAdmin) GroupOffsets(ctx context.Context, topic string, group string) ([]PartitionOffsets, error) {
+	metadata, err := admin.Client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return nil, fmt.Errorf("error happened while reading metadata of %v: %w", topic, err)
+	}
+	if len(metadata.Topics) == 0 || metadata.Topics[0].Error != nil {
+		return nil, fmt.Errorf("topic %v is not available", topic)
+	}
+
+	partitions := []int{}
+	for _, partition := range metadata.Topics[0].Partitions {
+		partitions = append(partitions, partition.ID)
+	}
+	sort.Ints(partitions)
+
+	first, err := admin.listOffsets(ctx, topic, partitions, kafka.FirstOffsetOf)
+	if err != nil {
+		return nil, err
+	}
+	last, err := admin.listOffsets(ctx, topic, partitions, kafka.LastOffsetOf)
+	if err != nil {
+		return nil, err
+	}
+
+	committed, err := admin.Client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: group,
+		Topics:  map[string][]int{topic: partitions},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error happened while fetching offsets of %v: %w", group, err)
+	}
+	if committed.Error != nil {
+		return nil, fmt.Errorf("error happened while fetching offsets of %v: %w", group, committed.Error)
+	}
+
+	commits := map[int]int64{}
+	for _, partition := range committed.Topics[topic] {
+		commits[partition.Partition] = partition.CommittedOffset
+	}
+
+	result := make([]PartitionOffsets, len(partitions))
+	for i, partition := range partitions {
+		result[i] = PartitionOffsets{
+			Partition: partition,
+			First:     first[partition].FirstOffset,
+			Last:      last[partition].LastOffset,
+			Committed: -1,
+		}
+		if offset, ok := commits[partition]; ok {
+			result[i].Committed = offset
+		}
+		result[i].Target = result[i].Committed
+	}
+	return result, nil
+}
+
+func (admin *kafkaAdmin) PlanReset(ctx context.Context, topic string, group string, reset OffsetReset) ([]PartitionOffsets, error) {
+	offsets, err := admin.GroupOffsets(ctx, topic, group)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets, err = selectPartition(topic, offsets, reset.Partition)
+	if err != nil {
+		return nil, err
+	}
+
+	var byTime map[int]kafka.PartitionOffsets
+	if reset.Mode == ResetToTimestamp {
+		partitions := make([]int, len(offsets))
+		for i, partition := range offsets {
+			partitions[i] = partition.Partition
+		}
+		byTime, err = admin.listOffsets(ctx, topic, partitions, func(partition int) kafka.OffsetRequest {
+			return kafka.TimeOffsetOf(partition, reset.Timestamp)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resetTargets(offsets, reset, func(partition int) (int64, bool) {
+		for offset := range byTime[partition].Offsets {
+			if offset >= 0 {
+				return offset, true
+			}
+		}
+		return 0, false
+	})
+}
+
+func (admin *kafkaAdmin) CommitOffsets(ctx context.Context, topic string, group string, offsets []PartitionOffsets) error {
+	commits := make([]kafka.OffsetCommit, len(offsets))
+	for i, partition := range offsets {
+		commits[i] = kafka.OffsetCommit{Partition: partition.Partition, Offset: partition.Target}
+	}
+
+	// Generation -1 is accepted by the broker only while the group has no members
+	response, err := admin.Client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      group,
+		GenerationID: -1,
+		Topics:       map[string][]kafka.OffsetCommit{topic: commits},
+	})
+	if err != nil {
+		return fmt.Errorf("error happened while committing offsets of %v: %w", group, err)
+	}
+
+	for _, partition := range response.Topics[topic] {
+		if partition.Error != nil {
+			return fmt.Errorf("error happened while committing offset of %v on partition %v, make sure every consumer is stopped: %w", group, partition.Partition, partition.Error)
+		}
+	}
+	admin.Logger.Info("Committed group offsets", zap.String("group", group), zap.String("topic", topic), zap.Int("partitions", len(commits)))
+	return nil
+}
+
+func (admin *kafkaAdmin) ReadPartition(ctx context.Context, topic string, partition int, from int64, to int64, handle func(message *Message) error) error {
+	if from >= to {
+		return nil
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   admin.Connection.Addresses,
+		Dialer:    admin.Connection.Dialer,
+		Topic:     topic,
+		Partition: partition,
+		MaxBytes:  10e6, // 10MB
+	})
+	defer reader.Close()
+
+	if err := reader.SetOffset(from); err != nil {
+		return fmt.Errorf("error happened while seeking partition %v to %v: %w", partition, from, err)
+	}
+
+	for {
+		m, err := reader.ReadMessage(ctx)
+		if err != nil {
+			return fmt.Errorf("error happened while reading partition %v: %w", partition, err)
+		}
+		if m.Offset >= to {
+			return nil
+		}
+
+		if err := handle(fromKafkaMessage(m)); err != nil {
+			return err
+		}
+		if m.Offset+1 >= to {
+			return nil
+		}
+	}
+}
+
+func (admin *kafkaAdmin) listOffsets(ctx context.Context, topic string, partitions []int, request func(partition int) kafka.OffsetRequest) (map[int]kafka.PartitionOffsets, error) {
+	requests := make([]kafka.OffsetRequest, len(partitions))
+	for i, partition := range partitions {
+		requests[i] = request(partition)
+	}
+
+	response, err := admin.Client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{topic: requests},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error happened while listing offsets of %v: %w", topic, err)
+	}
+
+	result := map[int]kafka.PartitionOffsets{}
+	for _, partition := range response.Topics[topic] {
+		if partition.Error != nil && !errors.Is(partition.Error, kafka.OffsetOutOfRange) {
+			return nil, fmt.Errorf("error happened while listing offsets of %v on partition %v: %w", topic, partition.Partition, partition.Error)
+		}
+		result[partition.Partition] = partition
+	}
+	return result, nil
+}
+
+// Keeps the single partition the reset is limited to, if any
+func selectPartition(topic string, offsets []PartitionOffsets, partition int) ([]PartitionOffsets, error) {
+	if partition < 0 {
+		return offsets, nil
+	}
+
+	for _, offset := range offsets {
+		if offset.Partition == partition {
+			return []PartitionOffsets{offset}, nil
+		}
+	}
+	return nil, fmt.Errorf("topic %v has no partition %v", topic, partition)
+}
+
+// Fills in the targets. timeOffset looks up the first offset written at or after the reset timestamp
+func resetTargets(offsets []PartitionOffsets, reset OffsetReset, timeOffset func(partition int) (int64, bool)) ([]PartitionOffsets, error) {
+	for i, partition := range offsets {
+		switch reset.Mode {
+		case ResetToEarliest:
+			offsets[i].Target = partition.First
+		case ResetToLatest:
+			offsets[i].Target = partition.Last
+		case ResetToOffset:
+			offsets[i].Target = min(max(reset.Offset, partition.First), partition.Last)
+		case ResetToTimestamp:
+			offset, ok := timeOffset(partition.Partition)
+			if !ok {
+				// Nothing was written after the timestamp
+				offset = partition.Last
+			}
+			offsets[i].Target = offset
+		default:
+			return nil, fmt.Errorf("unknown reset mode %q", reset.Mode)
+		}
+	}
+	return offsets, nil
+}
@@ -0,0 +1,81 @@
+package brocker
+
+import (
+	"context"
+
+	"github.com/steadfastie/gokube/data/services"
+	"go.uber.org/zap"
+)
+
+// Backend names the implementation producers and consumers talk to
+type Backend string
+
+const (
+	KafkaBackend Backend = "kafka"
+	MongoBackend Backend = "mongo"
+	NatsBackend  Backend = "nats"
+)
+
+type BrokerSettings struct {
+	Backend Backend
+	Topics  TopicSettings
+	// Bootstrap servers of the Kafka backend
+	Addresses []string
+	Kafka     KafkaSecurity
+	// How the Kafka backend connects at startup and reconnects later on
+	Connection ConnectionSettings
+	Producer   ProducerSettings
+	Mongo      MongoBrokerSettings
+	Nats       NatsBrokerSettings
+}
+
+// Broker creates producers, consumers and admin clients of a single backend
+type Broker interface {
+	NewWriter(ctx context.Context, logger *zap.Logger, topic string) Producer
+	NewConsumer(ctx context.Context, logger *zap.Logger, topic string, groupId string) Consumer
+	NewAdmin(logger *zap.Logger) Admin
+	Health(ctx context.Context) Health
+}
+
+// NewBroker selects the backend. The Mongo backend keeps its collections in the given database.
+// Memory brokers are built with NewMemoryBroker by tests only
+func NewBroker(ctx context.Context, settings BrokerSettings, mongodb *services.MongoDB, logger *zap.Logger) (Broker, error) {
+	switch settings.Backend {
+	case MongoBackend:
+		return NewMongoBroker(ctx, mongodb, logger, settings.Mongo)
+	case NatsBackend:
+		return NewNatsBroker(logger, settings.Nats)
+	default:
+		connection, err := NewKafkaConnection(settings.Addresses, settings.Kafka)
+		if err != nil {
+			return nil, err
+		}
+		manager, err := NewConnectionManager(ctx, connection, settings.Connection, logger)
+		if err != nil {
+			return nil, err
+		}
+		return &kafkaBroker{manager: manager, producer: settings.Producer, provisioning: settings.Topics.Provisioning}, nil
+	}
+}
+
+type kafkaBroker struct {
+	manager      *ConnectionManager
+	producer     ProducerSettings
+	provisioning ProvisioningMode
+}
+
+func (broker *kafkaBroker) NewWriter(ctx context.Context, logger *zap.Logger, topic string) Producer {
+	return NewWriter(ctx, logger, topic, broker.manager, broker.producer, broker.provisioning == ProvisioningOff)
+}
+
+func (broker *kafkaBroker) NewConsumer(ctx context.Context, logger *zap.Logger, topic string, groupId string) Consumer {
+	return NewConsumer(ctx, logger, topic, groupId, broker.manager)
+}
+
+func (broker *kafkaBroker) NewAdmin(logger *zap.Logger) Admin {
+	return NewAdmin(logger, broker.manager.Connection)
+}
+
+func (broker *kafkaBroker) Health(ctx context.Context) Health {
+	return broker.manager.Health()
+}
@@ -0,0 +1,204 @@
+package brocker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+type StartupMode string
+
+const (
+	// Startup blocks until a broker answers
+	StartupWait StartupMode = "wait"
+	// Startup goes on right away and the health check fails until a broker answers
+	StartupDegraded StartupMode = "degraded"
+)
+
+type ConnectionSettings struct {
+	Startup StartupMode
+	// Delay between failed dial attempts doubles from MinBackoff up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Delay between checks of an established connection
+	CheckInterval time.Duration
+}
+
+// Health is what a broker last knew about its connection
+type Health struct {
+	Healthy bool `json:"healthy"`
+	// Addresses the services were configured with
+	Addresses []string `json:"addresses,omitempty"`
+	// Brokers the cluster reported on the last successful check
+	Brokers     []string   `json:"brokers,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+}
+
+// ConnectionManager keeps a single metadata connection to the cluster open and checks it periodically.
+// A failed check drops the connection and the next one dials again with backoff. Writers and readers
+// reconnect on their own, so the manager is what tells whether they have a cluster to talk to
+type ConnectionManager struct {
+	Connection *KafkaConnection
+	Settings   ConnectionSettings
+	Logger     *zap.Logger
+
+	mu     sync.Mutex
+	conn   *kafka.Conn
+	health Health
+}
+
+// NewConnectionManager waits for the cluster in StartupWait mode and returns right away otherwise.
+// Either way the connection is checked in the background until ctx is cancelled
+func NewConnectionManager(ctx context.Context, connection *KafkaConnection, settings ConnectionSettings, logger *zap.Logger) (*ConnectionManager, error) {
+	manager := &ConnectionManager{
+		Connection: connection,
+		Settings:   settings,
+		Logger:     logger,
+		health:     Health{Addresses: connection.Addresses},
+	}
+
+	if settings.Startup == StartupWait {
+		if err := manager.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	go manager.run(ctx)
+	return manager, nil
+}
+
+func (manager *ConnectionManager) Health() Health {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	return manager.health
+}
+
+func (manager *ConnectionManager) wait(ctx context.Context) error {
+	backoff := manager.Settings.MinBackoff
+	for {
+		err := manager.check(ctx)
+		if err == nil {
+			return nil
+		}
+		manager.Logger.Warn("Waiting for kafka", zap.Strings("addresses", manager.Connection.Addresses), zap.Duration("backoff", backoff), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for kafka: %w", err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, manager.Settings.MaxBackoff)
+	}
+}
+
+func (manager *ConnectionManager) run(ctx context.Context) {
+	defer manager.close()
+
+	backoff := manager.Settings.MinBackoff
+	wait := time.Duration(0)
+	// Waiting for the cluster has just checked the connection
+	if manager.Health().Healthy {
+		wait = manager.Settings.CheckInterval
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if err := manager.check(ctx); err != nil {
+			manager.Logger.Warn("Could not reach kafka", zap.Duration("backoff", backoff), zap.Error(err))
+			wait = backoff
+			backoff = min(backoff*2, manager.Settings.MaxBackoff)
+		} else {
+			wait = manager.Settings.CheckInterval
+			backoff = manager.Settings.MinBackoff
+		}
+	}
+}
+
+// Dials when there is no connection and asks the cluster for its brokers over it
+func (manager *ConnectionManager) check(ctx context.Context) error {
+	manager.mu.Lock()
+	conn := manager.conn
+	manager.mu.Unlock()
+
+	if conn == nil {
+		dialed, err := manager.dial(ctx)
+		if err != nil {
+			manager.failed(err)
+			return err
+		}
+		conn = dialed
+	}
+
+	conn.SetDeadline(time.Now().Add(manager.Connection.Dialer.Timeout))
+	brokers, err := conn.Brokers()
+	if err != nil {
+		conn.Close()
+		manager.failed(err)
+		return err
+	}
+
+	addresses := make([]string, len(brokers))
+	for i, broker := range brokers {
+		addresses[i] = net.JoinHostPort(broker.Host, strconv.Itoa(broker.Port))
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if !manager.health.Healthy {
+		manager.Logger.Info("Connected to kafka", zap.Strings("brokers", addresses))
+	}
+	now := time.Now().UTC()
+	manager.conn = conn
+	manager.health.Healthy = true
+	manager.health.Brokers = addresses
+	manager.health.LastSuccess = &now
+	return nil
+}
+
+// Tries the bootstrap addresses in turn
+func (manager *ConnectionManager) dial(ctx context.Context) (*kafka.Conn, error) {
+	errs := []error{}
+	for _, address := range manager.Connection.Addresses {
+		dialCtx, cancel := context.WithTimeout(ctx, manager.Connection.Dialer.Timeout)
+		conn, err := manager.Connection.Dialer.DialContext(dialCtx, "tcp", address)
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (manager *ConnectionManager) failed(err error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	now := time.Now().UTC()
+	manager.conn = nil
+	manager.health.Healthy = false
+	manager.health.LastError = err.Error()
+	manager.health.LastErrorAt = &now
+}
+
+func (manager *ConnectionManager) close() {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if manager.conn != nil {
+		manager.conn.Close()
+		manager.conn = nil
+	}
+}
@@ -2,35 +2,72 @@ package brocker
 
 import (
 	"context"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 	"go.uber.org/zap"
 )
 
-const groupId = "counter-consumer"
+const (
+	CounterConsumerGroup          = "counter-consumer"
+	DeadLetterReplayConsumerGroup = "counter-dlq-replay"
+)
 
-type Message struct {
-	Type  string
+// HeaderTenant names the tenant of an event, so that consumers can route it without decoding the payload
+const HeaderTenant = "x-tenant"
+
+type Header struct {
+	Key   string
 	Value []byte
 }
 
+type Message struct {
+	Key           []byte
+	Value         []byte
+	Headers       []Header
+	Topic         string
+	Partition     int
+	Offset        int64
+	HighWaterMark int64
+	Time          time.Time
+}
+
 type Consumer interface {
-	RecieveMessage(ctx context.Context, resultChan chan<- []byte, errChan chan<- error)
+	// RecieveMessage fetches the next message without committing its offset
+	RecieveMessage(ctx context.Context, resultChan chan<- *Message, errChan chan<- error)
+	// CommitMessage acknowledges the message. Offsets are committed in fetch order,
+	// so a message is never committed before the ones fetched ahead of it
+	CommitMessage(ctx context.Context, message *Message) error
 	CheckConnection() bool
 	Disconnect()
 }
 
-type kafkaReader struct {
-	Conn   *kafka.Conn
-	Reader *kafka.Reader
-	Logger *zap.Logger
+// Implemented by consumers whose server redelivers messages left unacknowledged for a while
+type messageKeeper interface {
+	keepAlive(message *Message) (stop func())
 }
 
-func NewConsumer(ctx context.Context, logger *zap.Logger, addresses ...string) Consumer {
-	conn, _ := kafka.DialLeader(ctx, "tcp", addresses[0], topic, 0)
+// KeepAlive keeps a fetched message from being redelivered while it waits for its retry or is being handled.
+// Call stop once the message is committed or given up on. Does nothing on backends that don't redeliver on their own
+func KeepAlive(consumer Consumer, message *Message) (stop func()) {
+	if keeper, ok := consumer.(messageKeeper); ok {
+		return keeper.keepAlive(message)
+	}
+	return func() {}
+}
 
+type kafkaReader struct {
+	Manager *ConnectionManager
+	Reader  *kafka.Reader
+	Logger  *zap.Logger
+	offsets *offsetTracker
+}
+
+func NewConsumer(ctx context.Context, logger *zap.Logger, topic string, groupId string, manager *ConnectionManager) Consumer {
+	connection := manager.Connection
 	r := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:   addresses,
+		Brokers:   connection.Addresses,
+		Dialer:    connection.Dialer,
 		Topic:     topic,
 		GroupID:   groupId,
 		Partition: 0,
@@ -38,9 +75,10 @@ func NewConsumer(ctx context.Context, logger *zap.Logger, addresses ...string) C
 	})
 
 	connector := &kafkaReader{
-		Conn:   conn,
-		Reader: r,
-		Logger: logger,
+		Manager: manager,
+		Reader:  r,
+		Logger:  logger,
+		offsets: newOffsetTracker(),
 	}
 	return connector
 }
@@ -49,14 +87,13 @@ func (consumer *kafkaReader) Disconnect() {
 	consumer.Reader.Close()
 }
 
-// Calls metadata endpoint. See https://github.com/segmentio/kafka-go/issues/389#issuecomment-569334516
+// The reader reconnects on its own, so it relies on the manager's checks
 func (consumer *kafkaReader) CheckConnection() bool {
-	_, err := consumer.Conn.Brokers()
-	return err == nil
+	return consumer.Manager.Health().Healthy
 }
 
-func (consumer *kafkaReader) RecieveMessage(ctx context.Context, resultChan chan<- []byte, errChan chan<- error) {
-	m, err := consumer.Reader.ReadMessage(ctx)
+func (consumer *kafkaReader) RecieveMessage(ctx context.Context, resultChan chan<- *Message, errChan chan<- error) {
+	m, err := consumer.Reader.FetchMessage(ctx)
 	if err != nil {
 		consumer.Logger.Error(
 			"Could not read a message from kafka",
@@ -68,6 +105,7 @@ func (consumer *kafkaReader) RecieveMessage(ctx context.Context, resultChan chan
 			zap.Int64("messages in kafka", m.HighWaterMark),
 		)
 		errChan <- err
+		return
 	}
 	consumer.Logger.Info(
 		"Recieved a message from kafka",
@@ -77,5 +115,48 @@ func (consumer *kafkaReader) RecieveMessage(ctx context.Context, resultChan chan
 		zap.Int64("offset", m.Offset),
 		zap.Int64("messages in kafka", m.HighWaterMark),
 	)
-	resultChan <- m.Value
+	consumer.offsets.fetched(m.Topic, m.Partition, m.Offset)
+
+	resultChan <- fromKafkaMessage(m)
+}
+
+func (consumer *kafkaReader) CommitMessage(ctx context.Context, message *Message) error {
+	offset, ok := consumer.offsets.complete(message.Topic, message.Partition, message.Offset)
+	if !ok {
+		return nil
+	}
+
+	err := consumer.Reader.CommitMessages(ctx, kafka.Message{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    offset,
+	})
+	if err != nil {
+		consumer.Logger.Error(
+			"Could not commit offset to kafka",
+			zap.String("topic", message.Topic),
+			zap.Int("partition", message.Partition),
+			zap.Int64("offset", offset),
+			zap.Error(err),
+		)
+	}
+	return err
+}
+
+func fromKafkaMessage(m kafka.Message) *Message {
+	headers := make([]Header, len(m.Headers))
+	for i, header := range m.Headers {
+		headers[i] = Header{Key: header.Key, Value: header.Value}
+	}
+
+	return &Message{
+		Key:           m.Key,
+		Value:         m.Value,
+		Headers:       headers,
+		Topic:         m.Topic,
+		Partition:     m.Partition,
+		Offset:        m.Offset,
+		HighWaterMark: m.HighWaterMark,
+		Time:          m.Time,
+	}
 }
@@ -0,0 +1,61 @@
+package brocker
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Headers describing why and from where a message ended up in the dead-letter topic
+const (
+	HeaderError           = "x-dlq-error"
+	HeaderSourceTopic     = "x-dlq-source-topic"
+	HeaderSourcePartition = "x-dlq-source-partition"
+	HeaderSourceOffset    = "x-dlq-source-offset"
+	HeaderAttempts        = "x-dlq-attempts"
+)
+
+const deadLetterHeaderPrefix = "x-dlq-"
+
+// Header returns the last value of the header with the given key
+func (message *Message) Header(key string) (string, bool) {
+	for i := len(message.Headers) - 1; i >= 0; i-- {
+		if message.Headers[i].Key == key {
+			return string(message.Headers[i].Value), true
+		}
+	}
+	return "", false
+}
+
+// NewDeadLetter keeps key, value and headers of the original message and describes the failure on top
+func NewDeadLetter(message *Message, cause error, attempts int) *Message {
+	headers := StripDeadLetterHeaders(message).Headers
+	headers = append(headers,
+		Header{Key: HeaderError, Value: []byte(cause.Error())},
+		Header{Key: HeaderSourceTopic, Value: []byte(message.Topic)},
+		Header{Key: HeaderSourcePartition, Value: []byte(strconv.Itoa(message.Partition))},
+		Header{Key: HeaderSourceOffset, Value: []byte(strconv.FormatInt(message.Offset, 10))},
+		Header{Key: HeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+	)
+
+	return &Message{
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: headers,
+	}
+}
+
+// StripDeadLetterHeaders restores the message as it was first published, before any retries or dead-lettering
+func StripDeadLetterHeaders(message *Message) *Message {
+	headers := make([]Header, 0, len(message.Headers))
+	for _, header := range message.Headers {
+		if !strings.HasPrefix(header.Key, deadLetterHeaderPrefix) && !strings.HasPrefix(header.Key, retryHeaderPrefix) {
+			headers = append(headers, header)
+		}
+	}
+
+	return &Message{
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: headers,
+	}
+}
@@ -0,0 +1,67 @@
+package brocker
+
+import (
+	"context"
+	"sync"
+)
+
+// DeliveryReport tells where a message ended up, or why it didn't
+type DeliveryReport struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Err       error
+}
+
+// Delivery is the future of an asynchronous write. It resolves once the broker has acknowledged the message
+// or the producer has given up on it
+type Delivery struct {
+	once   sync.Once
+	done   chan struct{}
+	report DeliveryReport
+}
+
+func newDelivery() *Delivery {
+	return &Delivery{done: make(chan struct{})}
+}
+
+// NewDelivery is for producers outside of this package, which resolve the delivery once the message is written
+func NewDelivery() (*Delivery, func(report DeliveryReport)) {
+	delivery := newDelivery()
+	return delivery, delivery.resolve
+}
+
+// The first report wins
+func (delivery *Delivery) resolve(report DeliveryReport) {
+	delivery.once.Do(func() {
+		delivery.report = report
+		close(delivery.done)
+	})
+}
+
+func (delivery *Delivery) Done() <-chan struct{} {
+	return delivery.done
+}
+
+// Report is only meaningful once Done is closed
+func (delivery *Delivery) Report() DeliveryReport {
+	<-delivery.done
+	return delivery.report
+}
+
+// Wait blocks until the delivery resolves or ctx is done. The message may still be delivered after ctx is done
+func (delivery *Delivery) Wait(ctx context.Context) (DeliveryReport, error) {
+	select {
+	case <-delivery.done:
+		return delivery.report, delivery.report.Err
+	case <-ctx.Done():
+		return DeliveryReport{}, ctx.Err()
+	}
+}
+
+// Backends that write synchronously resolve the delivery before returning it, so that writes can't overtake each other
+func publishAsync(ctx context.Context, message *Message, publish func(ctx context.Context, message *Message) DeliveryReport) *Delivery {
+	delivery := newDelivery()
+	delivery.resolve(publish(ctx, message))
+	return delivery
+}
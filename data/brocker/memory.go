@@ -0,0 +1,412 @@
+package brocker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var errConsumerClosed = errors.New("consumer is closed")
+
+// MemoryBroker keeps topics in process memory, so that tests need no Kafka. It is test-only and
+// can't be configured with BROKER: the outbox and the consumer run as separate processes and never share one.
+// Messages are never deleted, every topic has the same number of partitions, and partitions
+// of a topic are spread across the members of a consumer group the way Kafka does
+type MemoryBroker struct {
+	mu         sync.Mutex
+	partitions int
+	topics     map[string][][]*Message
+	groups     map[groupKey]*memoryGroup
+	// Closed and replaced whenever something readers wait for happens
+	changed chan struct{}
+}
+
+type groupKey struct {
+	topic string
+	group string
+}
+
+type memoryGroup struct {
+	// Next offset to read per partition
+	committed map[int]int64
+	members   []*memoryReader
+	// Bumped on every join and leave, so that members pick up their new partitions
+	generation int
+}
+
+func NewMemoryBroker(partitions int) *MemoryBroker {
+	return &MemoryBroker{
+		partitions: partitions,
+		topics:     map[string][][]*Message{},
+		groups:     map[groupKey]*memoryGroup{},
+		changed:    make(chan struct{}),
+	}
+}
+
+func (broker *MemoryBroker) NewWriter(ctx context.Context, logger *zap.Logger, topic string) Producer {
+	return &memoryWriter{Broker: broker, Topic: topic, Logger: logger}
+}
+
+func (broker *MemoryBroker) NewConsumer(ctx context.Context, logger *zap.Logger, topic string, groupId string) Consumer {
+	reader := &memoryReader{
+		Broker:    broker,
+		Topic:     topic,
+		Group:     groupId,
+		Logger:    logger,
+		positions: map[int]int64{},
+		offsets:   newOffsetTracker(),
+	}
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	group := broker.group(topic, groupId)
+	group.members = append(group.members, reader)
+	group.generation++
+	broker.notify()
+	return reader
+}
+
+func (broker *MemoryBroker) NewAdmin(logger *zap.Logger) Admin {
+	return &memoryAdmin{Broker: broker, Logger: logger}
+}
+
+func (broker *MemoryBroker) Health(ctx context.Context) Health {
+	return Health{Healthy: true}
+}
+
+// Must be called under the lock
+func (broker *MemoryBroker) topic(name string) [][]*Message {
+	partitions, ok := broker.topics[name]
+	if !ok {
+		partitions = make([][]*Message, broker.partitions)
+		broker.topics[name] = partitions
+	}
+	return partitions
+}
+
+// Must be called under the lock
+func (broker *MemoryBroker) group(topic string, name string) *memoryGroup {
+	key := groupKey{topic: topic, group: name}
+	group, ok := broker.groups[key]
+	if !ok {
+		group = &memoryGroup{committed: map[int]int64{}}
+		broker.groups[key] = group
+	}
+	return group
+}
+
+// Must be called under the lock
+func (broker *MemoryBroker) notify() {
+	close(broker.changed)
+	broker.changed = make(chan struct{})
+}
+
+// Messages with the same key end up in the same partition, messages without a key are spread evenly
+func (broker *MemoryBroker) append(topic string, message *Message) *Message {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	partitions := broker.topic(topic)
+	partition := 0
+	if len(message.Key) > 0 {
+		hash := fnv.New32a()
+		hash.Write(message.Key)
+		partition = int(hash.Sum32() % uint32(len(partitions)))
+	} else {
+		for i := range partitions {
+			if len(partitions[i]) < len(partitions[partition]) {
+				partition = i
+			}
+		}
+	}
+
+	stored := &Message{
+		Key:       slices.Clone(message.Key),
+		Value:     slices.Clone(message.Value),
+		Headers:   slices.Clone(message.Headers),
+		Topic:     topic,
+		Partition: partition,
+		Offset:    int64(len(partitions[partition])),
+		Time:      time.Now().UTC(),
+	}
+	partitions[partition] = append(partitions[partition], stored)
+	broker.notify()
+	return stored
+}
+
+type memoryWriter struct {
+	Broker *MemoryBroker
+	Topic  string
+	Logger *zap.Logger
+}
+
+func (producer *memoryWriter) SendMessage(ctx context.Context, key []byte, value []byte) {
+	producer.PublishMessage(ctx, &Message{Key: key, Value: value})
+}
+
+func (producer *memoryWriter) PublishMessage(ctx context.Context, message *Message) error {
+	return producer.publish(ctx, message).Err
+}
+
+// Appending never blocks, so the delivery is resolved right away
+func (producer *memoryWriter) PublishAsync(ctx context.Context, message *Message) *Delivery {
+	delivery := newDelivery()
+	delivery.resolve(producer.publish(ctx, message))
+	return delivery
+}
+
+func (producer *memoryWriter) publish(ctx context.Context, message *Message) DeliveryReport {
+	if err := ctx.Err(); err != nil {
+		return DeliveryReport{Topic: producer.Topic, Err: err}
+	}
+	stored := producer.Broker.append(producer.Topic, message)
+	producer.Logger.Debug(
+		"Wrote a message to memory",
+		zap.String("topic", stored.Topic),
+		zap.Int("partition", stored.Partition),
+		zap.Int64("offset", stored.Offset),
+	)
+	return DeliveryReport{Topic: stored.Topic, Partition: stored.Partition, Offset: stored.Offset}
+}
+
+func (producer *memoryWriter) CheckConnection() bool {
+	return true
+}
+
+func (producer *memoryWriter) Disconnect() {}
+
+type memoryReader struct {
+	Broker *MemoryBroker
+	Topic  string
+	Group  string
+	Logger *zap.Logger
+
+	// Guarded by the broker lock
+	positions  map[int]int64
+	generation int
+	next       int
+	closed     bool
+
+	offsets *offsetTracker
+}
+
+func (consumer *memoryReader) RecieveMessage(ctx context.Context, resultChan chan<- *Message, errChan chan<- error) {
+	for {
+		message, changed, err := consumer.poll()
+		if err != nil {
+			errChan <- err
+			return
+		}
+		if message != nil {
+			consumer.offsets.fetched(message.Topic, message.Partition, message.Offset)
+			resultChan <- message
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			errChan <- ctx.Err()
+			return
+		}
+	}
+}
+
+// Takes the next message of the assigned partitions, or hands over a channel to wait on when there is none
+func (consumer *memoryReader) poll() (*Message, <-chan struct{}, error) {
+	broker := consumer.Broker
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	if consumer.closed {
+		return nil, nil, errConsumerClosed
+	}
+
+	group := broker.group(consumer.Topic, consumer.Group)
+	// After a rebalance every member continues from what the group has committed
+	if consumer.generation != group.generation {
+		consumer.generation = group.generation
+		consumer.positions = map[int]int64{}
+		for _, partition := range consumer.assigned(group) {
+			consumer.positions[partition] = group.committed[partition]
+		}
+	}
+
+	partitions := broker.topic(consumer.Topic)
+	assigned := consumer.assigned(group)
+	for i := range assigned {
+		partition := assigned[(consumer.next+i)%len(assigned)]
+		position := consumer.positions[partition]
+		if position >= int64(len(partitions[partition])) {
+			continue
+		}
+
+		consumer.positions[partition] = position + 1
+		consumer.next = (consumer.next + i + 1) % len(assigned)
+
+		stored := partitions[partition][position]
+		message := *stored
+		message.HighWaterMark = int64(len(partitions[partition]))
+		return &message, nil, nil
+	}
+	return nil, broker.changed, nil
+}
+
+// Partitions are dealt out to the members in the order they joined. Must be called under the lock
+func (consumer *memoryReader) assigned(group *memoryGroup) []int {
+	member := slices.Index(group.members, consumer)
+	partitions := []int{}
+	for partition := 0; partition < consumer.Broker.partitions; partition++ {
+		if partition%len(group.members) == member {
+			partitions = append(partitions, partition)
+		}
+	}
+	return partitions
+}
+
+func (consumer *memoryReader) CommitMessage(ctx context.Context, message *Message) error {
+	offset, ok := consumer.offsets.complete(message.Topic, message.Partition, message.Offset)
+	if !ok {
+		return nil
+	}
+
+	broker := consumer.Broker
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	group := broker.group(consumer.Topic, consumer.Group)
+	// Like Kafka, the group stores the offset it reads next
+	if offset+1 > group.committed[message.Partition] {
+		group.committed[message.Partition] = offset + 1
+	}
+	return nil
+}
+
+func (consumer *memoryReader) CheckConnection() bool {
+	return true
+}
+
+func (consumer *memoryReader) Disconnect() {
+	broker := consumer.Broker
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	if consumer.closed {
+		return
+	}
+	consumer.closed = true
+
+	group := broker.group(consumer.Topic, consumer.Group)
+	group.members = slices.DeleteFunc(group.members, func(member *memoryReader) bool {
+		return member == consumer
+	})
+	group.generation++
+	broker.notify()
+}
+
+type memoryAdmin struct {
+	Broker *MemoryBroker
+	Logger *zap.Logger
+}
+
+func (admin *memoryAdmin) GroupOffsets(ctx context.Context, topic string, group string) ([]PartitionOffsets, error) {
+	broker := admin.Broker
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	partitions := broker.topic(topic)
+	committed := broker.group(topic, group).committed
+
+	result := make([]PartitionOffsets, len(partitions))
+	for partition, messages := range partitions {
+		result[partition] = PartitionOffsets{
+			Partition: partition,
+			First:     0,
+			Last:      int64(len(messages)),
+			Committed: -1,
+		}
+		if offset, ok := committed[partition]; ok {
+			result[partition].Committed = offset
+		}
+		result[partition].Target = result[partition].Committed
+	}
+	return result, nil
+}
+
+func (admin *memoryAdmin) PlanReset(ctx context.Context, topic string, group string, reset OffsetReset) ([]PartitionOffsets, error) {
+	offsets, err := admin.GroupOffsets(ctx, topic, group)
+	if err != nil {
+		return nil, err
+	}
+	offsets, err = selectPartition(topic, offsets, reset.Partition)
+	if err != nil {
+		return nil, err
+	}
+
+	broker := admin.Broker
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	partitions := broker.topic(topic)
+	return resetTargets(offsets, reset, func(partition int) (int64, bool) {
+		for _, message := range partitions[partition] {
+			if !message.Time.Before(reset.Timestamp) {
+				return message.Offset, true
+			}
+		}
+		return 0, false
+	})
+}
+
+func (admin *memoryAdmin) CommitOffsets(ctx context.Context, topic string, group string, offsets []PartitionOffsets) error {
+	broker := admin.Broker
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	memoryGroup := broker.group(topic, group)
+	if len(memoryGroup.members) > 0 {
+		return fmt.Errorf("group %v has %v active members, make sure every consumer is stopped", group, len(memoryGroup.members))
+	}
+	for _, partition := range offsets {
+		memoryGroup.committed[partition.Partition] = partition.Target
+	}
+
+	admin.Logger.Info("Committed group offsets", zap.String("group", group), zap.String("topic", topic), zap.Int("partitions", len(offsets)))
+	return nil
+}
+
+func (admin *memoryAdmin) ReadPartition(ctx context.Context, topic string, partition int, from int64, to int64, handle func(message *Message) error) error {
+	if from >= to {
+		return nil
+	}
+
+	broker := admin.Broker
+	broker.mu.Lock()
+	partitions := broker.topic(topic)
+	if partition < 0 || partition >= len(partitions) {
+		broker.mu.Unlock()
+		return fmt.Errorf("topic %v has no partition %v", topic, partition)
+	}
+	// Messages are never changed once appended, so the slice can be walked without the lock
+	messages := partitions[partition]
+	broker.mu.Unlock()
+
+	for offset := max(from, 0); offset < min(to, int64(len(messages))); offset++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		message := *messages[offset]
+		message.HighWaterMark = int64(len(messages))
+		if err := handle(&message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package brocker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestMemoryBrokerKeepsKeysInOnePartition(t *testing.T) {
+	ctx := context.Background()
+	broker := NewMemoryBroker(3)
+	producer := broker.NewWriter(ctx, zap.NewNop(), "counter")
+
+	partitions := map[string]int{}
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("counter-%d", i%5)
+		report := producer.PublishAsync(ctx, &Message{Key: []byte(key), Value: []byte{byte(i)}}).Report()
+		if report.Err != nil {
+			t.Fatal(report.Err)
+		}
+		if partition, ok := partitions[key]; ok && partition != report.Partition {
+			t.Errorf("Key %v moved from partition %d to %d", key, partition, report.Partition)
+		}
+		partitions[key] = report.Partition
+	}
+
+	// Per key, messages are read back in the order they were written
+	consumer := broker.NewConsumer(ctx, zap.NewNop(), "counter", "test")
+	last := map[string]byte{}
+	for i := 0; i < 30; i++ {
+		message := receiveMessage(t, consumer)
+		if previous, ok := last[string(message.Key)]; ok && previous >= message.Value[0] {
+			t.Errorf("Message %d of key %s was read after %d", message.Value[0], message.Key, previous)
+		}
+		last[string(message.Key)] = message.Value[0]
+	}
+}
+
+func TestMemoryBrokerCommitsInOrder(t *testing.T) {
+	ctx := context.Background()
+	broker := NewMemoryBroker(1)
+	producer := broker.NewWriter(ctx, zap.NewNop(), "counter")
+	for i := 0; i < 3; i++ {
+		producer.PublishMessage(ctx, &Message{Value: []byte{byte(i)}})
+	}
+
+	consumer := broker.NewConsumer(ctx, zap.NewNop(), "counter", "test")
+	messages := []*Message{}
+	for i := 0; i < 3; i++ {
+		messages = append(messages, receiveMessage(t, consumer))
+	}
+
+	admin := broker.NewAdmin(zap.NewNop())
+	committed := func() int64 {
+		offsets, err := admin.GroupOffsets(ctx, "counter", "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return offsets[0].Committed
+	}
+
+	consumer.CommitMessage(ctx, messages[2])
+	consumer.CommitMessage(ctx, messages[1])
+	if offset := committed(); offset != -1 {
+		t.Errorf("Committed offset = %d before the first message was done, want none", offset)
+	}
+	consumer.CommitMessage(ctx, messages[0])
+	if offset := committed(); offset != 3 {
+		t.Errorf("Committed offset = %d, want 3", offset)
+	}
+}
+
+// Members that join or leave make the group start over from what it has committed, so uncommitted messages are read again
+func TestMemoryBrokerRebalanceResumesFromCommit(t *testing.T) {
+	ctx := context.Background()
+	broker := NewMemoryBroker(2)
+	producer := broker.NewWriter(ctx, zap.NewNop(), "counter")
+	for i := 0; i < 4; i++ {
+		producer.PublishMessage(ctx, &Message{Value: []byte{byte(i)}})
+	}
+
+	first := broker.NewConsumer(ctx, zap.NewNop(), "counter", "test")
+	read := map[int][]int64{}
+	for i := 0; i < 4; i++ {
+		message := receiveMessage(t, first)
+		read[message.Partition] = append(read[message.Partition], message.Offset)
+		// Only the first message of every partition gets done
+		if message.Offset == 0 {
+			first.CommitMessage(ctx, message)
+		}
+	}
+	if len(read[0]) != 2 || len(read[1]) != 2 {
+		t.Fatalf("Single member read %v, want both partitions", read)
+	}
+
+	second := broker.NewConsumer(ctx, zap.NewNop(), "counter", "test")
+	for _, consumer := range []Consumer{first, second} {
+		message := receiveMessage(t, consumer)
+		if message.Offset != 1 {
+			t.Errorf("Partition %d resumed at offset %d, want the uncommitted 1", message.Partition, message.Offset)
+		}
+	}
+
+	// The partition of a member that left goes to the one that stayed
+	second.Disconnect()
+	partitions := map[int]bool{}
+	for i := 0; i < 2; i++ {
+		message := receiveMessage(t, first)
+		if message.Offset != 1 {
+			t.Errorf("Partition %d resumed at offset %d, want the uncommitted 1", message.Partition, message.Offset)
+		}
+		partitions[message.Partition] = true
+	}
+	if len(partitions) != 2 {
+		t.Errorf("Remaining member read partitions %v, want both", partitions)
+	}
+}
+
+func TestMemoryBrokerGroupsReadIndependently(t *testing.T) {
+	ctx := context.Background()
+	broker := NewMemoryBroker(1)
+	broker.NewWriter(ctx, zap.NewNop(), "counter").PublishMessage(ctx, &Message{Value: []byte("event")})
+
+	for _, group := range []string{"archive", "projection"} {
+		message := receiveMessage(t, broker.NewConsumer(ctx, zap.NewNop(), "counter", group))
+		if string(message.Value) != "event" {
+			t.Errorf("Group %v read %q", group, message.Value)
+		}
+	}
+}
+
+func receiveMessage(t *testing.T, consumer Consumer) *Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	messageChan := make(chan *Message, 1)
+	errChan := make(chan error, 1)
+	go consumer.RecieveMessage(ctx, messageChan, errChan)
+
+	select {
+	case message := <-messageChan:
+		return message
+	case err := <-errChan:
+		t.Fatalf("Could not receive a message: %v", err)
+		return nil
+	}
+}
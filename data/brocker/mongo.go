@@ -0,0 +1,692 @@
+package brocker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/steadfastie/gokube/data/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	brokerMessagesCollection   = "broker_messages"
+	brokerPartitionsCollection = "broker_partitions"
+	brokerOffsetsCollection    = "broker_offsets"
+)
+
+const (
+	// A consumer owns its partitions this long without renewing
+	mongoLeaseDuration = 30 * time.Second
+	// Readers look for new messages this often even when the change stream stays silent
+	mongoPollInterval = time.Second
+	mongoFetchBatch   = 100
+)
+
+var errPartitionLost = errors.New("partition is owned by another consumer")
+
+type MongoBrokerSettings struct {
+	Partitions int
+	// Messages are deleted this long after they were written, consumed or not
+	Retention time.Duration
+}
+
+// MongoBroker keeps topics in a Mongo collection, for deployments too small to justify Kafka.
+// Mongo has to run as a replica set, the broker refuses to start otherwise.
+//
+// Every message gets the next offset of its partition in the same transaction it is inserted in,
+// so offsets become visible strictly in order. Readers are woken up by a change stream and fall back
+// to polling. A consumer group member leases the partitions it reads and commits offsets only while
+// it holds the lease. Delivery is at least once, the same as with Kafka: an offset is committed after
+// the message is handled, so a crash or a lost lease hands the uncommitted messages to the next owner
+type MongoBroker struct {
+	Client     *mongo.Client
+	Messages   *mongo.Collection
+	Partitions *mongo.Collection
+	Offsets    *mongo.Collection
+	Logger     *zap.Logger
+	Settings   MongoBrokerSettings
+}
+
+type mongoMessage struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	Topic     string             `bson:"topic"`
+	Partition int                `bson:"partition"`
+	Offset    int64              `bson:"offset"`
+	Key       []byte             `bson:"key"`
+	Value     []byte             `bson:"value"`
+	Headers   []Header           `bson:"headers"`
+	Time      time.Time          `bson:"time"`
+}
+
+// Next offset of a partition
+type mongoPartition struct {
+	Id   string `bson:"_id"`
+	Next int64  `bson:"next"`
+}
+
+// Committed offset of a group on a partition, together with the lease of the member reading it
+type mongoGroupOffset struct {
+	Id         string    `bson:"_id"`
+	Group      string    `bson:"group"`
+	Topic      string    `bson:"topic"`
+	Partition  int       `bson:"partition"`
+	Committed  *int64    `bson:"committed,omitempty"`
+	Owner      string    `bson:"owner,omitempty"`
+	LeaseUntil time.Time `bson:"leaseUntil"`
+}
+
+func NewMongoBroker(ctx context.Context, mongodb *services.MongoDB, logger *zap.Logger, settings MongoBrokerSettings) (*MongoBroker, error) {
+	broker := &MongoBroker{
+		Client:     mongodb.MongoClient,
+		Messages:   mongodb.MongoDB.Collection(brokerMessagesCollection),
+		Partitions: mongodb.MongoDB.Collection(brokerPartitionsCollection),
+		Offsets:    mongodb.MongoDB.Collection(brokerOffsetsCollection),
+		Logger:     logger,
+		Settings:   settings,
+	}
+
+	// Offsets are handed out in transactions, which a standalone server doesn't support
+	if err := mongodb.RequireReplicaSet(ctx); err != nil {
+		return nil, fmt.Errorf("mongo broker can't be used: %w", err)
+	}
+
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "topic", Value: 1}, {Key: "partition", Value: 1}, {Key: "offset", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := broker.Messages.Indexes().CreateOne(ctx, index); err != nil {
+		return nil, fmt.Errorf("error happened while creating broker indexes: %w", err)
+	}
+	// Retention may change between deployments, the expiry of the index follows it
+	if err := services.EnsureTTLIndex(ctx, broker.Messages, "time", settings.Retention); err != nil {
+		return nil, fmt.Errorf("error happened while creating broker TTL index: %w", err)
+	}
+	return broker, nil
+}
+
+func (broker *MongoBroker) NewWriter(ctx context.Context, logger *zap.Logger, topic string) Producer {
+	// Counters are created upfront, so that transactions only ever increment them
+	for partition := 0; partition < broker.Settings.Partitions; partition++ {
+		_, err := broker.Partitions.UpdateOne(ctx,
+			bson.M{"_id": partitionId(topic, partition)},
+			bson.M{"$setOnInsert": bson.M{"next": int64(0)}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			logger.Error("Could not create partition counter", zap.String("topic", topic), zap.Int("partition", partition), zap.Error(err))
+		}
+	}
+	return &mongoWriter{Broker: broker, Topic: topic, Logger: logger}
+}
+
+func (broker *MongoBroker) NewConsumer(ctx context.Context, logger *zap.Logger, topic string, groupId string) Consumer {
+	watchCtx, cancel := context.WithCancel(context.Background())
+	reader := &mongoReader{
+		Broker:    broker,
+		Topic:     topic,
+		Group:     groupId,
+		Owner:     primitive.NewObjectID().Hex(),
+		Logger:    logger,
+		offsets:   newOffsetTracker(),
+		leases:    map[int]bool{},
+		positions: map[int]int64{},
+		wake:      make(chan struct{}, 1),
+		cancel:    cancel,
+	}
+	go reader.watch(watchCtx)
+	go reader.keepAlive(watchCtx)
+	return reader
+}
+
+func (broker *MongoBroker) NewAdmin(logger *zap.Logger) Admin {
+	return &mongoAdmin{Broker: broker, Logger: logger}
+}
+
+// The driver reconnects on its own, so health is whatever a ping says right now
+func (broker *MongoBroker) Health(ctx context.Context) Health {
+	now := time.Now().UTC()
+	if err := broker.Client.Ping(ctx, nil); err != nil {
+		return Health{LastError: err.Error(), LastErrorAt: &now}
+	}
+	return Health{Healthy: true, LastSuccess: &now}
+}
+
+func (broker *MongoBroker) partitionOf(key []byte) int {
+	if len(key) == 0 {
+		return int(time.Now().UnixNano() % int64(broker.Settings.Partitions))
+	}
+	hash := fnv.New32a()
+	hash.Write(key)
+	return int(hash.Sum32() % uint32(broker.Settings.Partitions))
+}
+
+// Offset the next written message gets
+func (broker *MongoBroker) last(ctx context.Context, topic string, partition int) (int64, error) {
+	var counter mongoPartition
+	err := broker.Partitions.FindOne(ctx, bson.M{"_id": partitionId(topic, partition)}).Decode(&counter)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return counter.Next, err
+}
+
+func partitionId(topic string, partition int) string {
+	return fmt.Sprintf("%v/%v", topic, partition)
+}
+
+func groupOffsetId(group string, topic string, partition int) string {
+	return fmt.Sprintf("%v/%v/%v", group, topic, partition)
+}
+
+func (message *mongoMessage) toMessage(highWaterMark int64) *Message {
+	return &Message{
+		Key:           message.Key,
+		Value:         message.Value,
+		Headers:       message.Headers,
+		Topic:         message.Topic,
+		Partition:     message.Partition,
+		Offset:        message.Offset,
+		HighWaterMark: highWaterMark,
+		Time:          message.Time,
+	}
+}
+
+type mongoWriter struct {
+	Broker *MongoBroker
+	Topic  string
+	Logger *zap.Logger
+}
+
+func (producer *mongoWriter) SendMessage(ctx context.Context, key []byte, value []byte) {
+	producer.PublishMessage(ctx, &Message{Key: key, Value: value})
+}
+
+func (producer *mongoWriter) PublishMessage(ctx context.Context, message *Message) error {
+	return producer.publish(ctx, message).Err
+}
+
+func (producer *mongoWriter) PublishAsync(ctx context.Context, message *Message) *Delivery {
+	return publishAsync(ctx, message, producer.publish)
+}
+
+func (producer *mongoWriter) publish(ctx context.Context, message *Message) DeliveryReport {
+	broker := producer.Broker
+	partition := broker.partitionOf(message.Key)
+	report := DeliveryReport{Topic: producer.Topic, Partition: partition}
+
+	session, err := broker.Client.StartSession()
+	if err != nil {
+		report.Err = fmt.Errorf("error happened while starting broker session: %w", err)
+		return report
+	}
+	defer session.EndSession(ctx)
+
+	// Concurrent writers conflict on the counter and the driver retries the loser, which keeps offsets in commit order
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		var counter mongoPartition
+		err := broker.Partitions.FindOneAndUpdate(sessionCtx,
+			bson.M{"_id": partitionId(producer.Topic, partition)},
+			bson.M{"$inc": bson.M{"next": int64(1)}},
+			options.FindOneAndUpdate().SetReturnDocument(options.Before),
+		).Decode(&counter)
+		if err != nil {
+			return nil, err
+		}
+		report.Offset = counter.Next
+
+		_, err = broker.Messages.InsertOne(sessionCtx, &mongoMessage{
+			Id:        primitive.NewObjectID(),
+			Topic:     producer.Topic,
+			Partition: partition,
+			Offset:    counter.Next,
+			Key:       message.Key,
+			Value:     message.Value,
+			Headers:   message.Headers,
+			Time:      time.Now().UTC(),
+		})
+		return nil, err
+	})
+	if err != nil {
+		producer.Logger.Error("Could not write a message to mongo", zap.String("topic", producer.Topic), zap.Error(err))
+		report.Err = err
+	}
+	return report
+}
+
+func (producer *mongoWriter) CheckConnection() bool {
+	return producer.Broker.Client.Ping(context.Background(), nil) == nil
+}
+
+func (producer *mongoWriter) Disconnect() {}
+
+type mongoReader struct {
+	Broker *MongoBroker
+	Topic  string
+	Group  string
+	// Identifies the member in partition leases
+	Owner  string
+	Logger *zap.Logger
+
+	offsets *offsetTracker
+
+	mu        sync.Mutex
+	leases    map[int]bool
+	renewedAt time.Time
+	positions map[int]int64
+	buffer    []*Message
+	next      int
+
+	wake   chan struct{}
+	cancel context.CancelFunc
+}
+
+// Nudges readers whenever a message of the topic is inserted. While the change stream is down readers just poll
+func (consumer *mongoReader) watch(ctx context.Context) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "operationType", Value: "insert"},
+			{Key: "fullDocument.topic", Value: consumer.Topic},
+		}}},
+	}
+
+	for ctx.Err() == nil {
+		stream, err := consumer.Broker.Messages.Watch(ctx, pipeline)
+		if err != nil {
+			if ctx.Err() == nil {
+				consumer.Logger.Warn("Could not watch broker messages, falling back to polling", zap.Error(err))
+			}
+			return
+		}
+
+		for stream.Next(ctx) {
+			select {
+			case consumer.wake <- struct{}{}:
+			default:
+			}
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			consumer.Logger.Warn("Broker change stream was interrupted", zap.Error(err))
+		}
+		stream.Close(context.Background())
+	}
+}
+
+// Leases are renewed even while every fetched message is still being handled
+func (consumer *mongoReader) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(mongoLeaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		consumer.mu.Lock()
+		err := consumer.renewLeases(ctx)
+		consumer.mu.Unlock()
+		if err != nil && ctx.Err() == nil {
+			consumer.Logger.Warn("Could not renew partition leases", zap.String("topic", consumer.Topic), zap.Error(err))
+		}
+	}
+}
+
+func (consumer *mongoReader) RecieveMessage(ctx context.Context, resultChan chan<- *Message, errChan chan<- error) {
+	for {
+		message, err := consumer.poll(ctx)
+		if err != nil {
+			consumer.Logger.Error("Could not read a message from mongo", zap.String("topic", consumer.Topic), zap.Error(err))
+			errChan <- err
+			return
+		}
+		if message != nil {
+			consumer.Logger.Info(
+				"Recieved a message from mongo",
+				zap.String("key", string(message.Key)),
+				zap.String("topic", message.Topic),
+				zap.Int("partition", message.Partition),
+				zap.Int64("offset", message.Offset),
+			)
+			consumer.offsets.fetched(message.Topic, message.Partition, message.Offset)
+			resultChan <- message
+			return
+		}
+
+		select {
+		case <-consumer.wake:
+		case <-time.After(mongoPollInterval):
+		case <-ctx.Done():
+			errChan <- ctx.Err()
+			return
+		}
+	}
+}
+
+// Hands over the next buffered message, fetching a batch of the owned partitions when the buffer is empty
+func (consumer *mongoReader) poll(ctx context.Context) (*Message, error) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	if consumer.renewedAt.IsZero() {
+		if err := consumer.renewLeases(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(consumer.buffer) == 0 {
+		if err := consumer.fill(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if len(consumer.buffer) == 0 {
+		return nil, nil
+	}
+
+	message := consumer.buffer[0]
+	consumer.buffer = consumer.buffer[1:]
+	return message, nil
+}
+
+// Renews the leases the member holds and takes over partitions nobody holds.
+// A newly leased partition is read from the offset the group has committed
+func (consumer *mongoReader) renewLeases(ctx context.Context) error {
+	now := time.Now().UTC()
+	leases := map[int]bool{}
+
+	for partition := 0; partition < consumer.Broker.Settings.Partitions; partition++ {
+		filter := bson.M{
+			"_id": groupOffsetId(consumer.Group, consumer.Topic, partition),
+			"$or": bson.A{
+				bson.M{"owner": consumer.Owner},
+				bson.M{"owner": bson.M{"$exists": false}},
+				bson.M{"leaseUntil": bson.M{"$lt": now}},
+			},
+		}
+		update := bson.M{"$set": bson.M{
+			"group":      consumer.Group,
+			"topic":      consumer.Topic,
+			"partition":  partition,
+			"owner":      consumer.Owner,
+			"leaseUntil": now.Add(mongoLeaseDuration),
+		}}
+
+		var offset mongoGroupOffset
+		err := consumer.Broker.Offsets.FindOneAndUpdate(ctx, filter, update,
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&offset)
+		if err != nil {
+			// Another member holds the lease
+			if mongo.IsDuplicateKeyError(err) {
+				continue
+			}
+			return fmt.Errorf("error happened while leasing partition %v of %v: %w", partition, consumer.Topic, err)
+		}
+
+		leases[partition] = true
+		if !consumer.leases[partition] {
+			consumer.positions[partition] = 0
+			if offset.Committed != nil {
+				consumer.positions[partition] = *offset.Committed
+			}
+			consumer.Logger.Info("Leased partition", zap.String("topic", consumer.Topic), zap.String("group", consumer.Group), zap.Int("partition", partition))
+		}
+	}
+
+	// Buffered messages of lost partitions belong to their new owner now
+	consumer.buffer = filterMessages(consumer.buffer, func(message *Message) bool {
+		return leases[message.Partition]
+	})
+	for partition := range consumer.positions {
+		if !leases[partition] {
+			delete(consumer.positions, partition)
+		}
+	}
+
+	consumer.leases = leases
+	consumer.renewedAt = now
+	return nil
+}
+
+func (consumer *mongoReader) fill(ctx context.Context) error {
+	partitions := []int{}
+	for partition := range consumer.positions {
+		partitions = append(partitions, partition)
+	}
+	sort.Ints(partitions)
+
+	for i := range partitions {
+		partition := partitions[(consumer.next+i)%len(partitions)]
+		filter := bson.M{
+			"topic":     consumer.Topic,
+			"partition": partition,
+			"offset":    bson.M{"$gte": consumer.positions[partition]},
+		}
+		opts := options.Find().SetSort(bson.D{{Key: "offset", Value: 1}}).SetLimit(mongoFetchBatch)
+
+		cursor, err := consumer.Broker.Messages.Find(ctx, filter, opts)
+		if err != nil {
+			return fmt.Errorf("error happened while reading partition %v of %v: %w", partition, consumer.Topic, err)
+		}
+		messages := []mongoMessage{}
+		if err := cursor.All(ctx, &messages); err != nil {
+			return fmt.Errorf("error happened while reading partition %v of %v: %w", partition, consumer.Topic, err)
+		}
+		if len(messages) == 0 {
+			continue
+		}
+
+		last, err := consumer.Broker.last(ctx, consumer.Topic, partition)
+		if err != nil {
+			return fmt.Errorf("error happened while reading high-water mark of %v: %w", consumer.Topic, err)
+		}
+		for i := range messages {
+			consumer.buffer = append(consumer.buffer, messages[i].toMessage(last))
+		}
+		consumer.positions[partition] = messages[len(messages)-1].Offset + 1
+		consumer.next = (consumer.next + i + 1) % len(partitions)
+		return nil
+	}
+	return nil
+}
+
+// Commits only while the member still holds the partition, so a stale member can't move the group back
+func (consumer *mongoReader) CommitMessage(ctx context.Context, message *Message) error {
+	offset, ok := consumer.offsets.complete(message.Topic, message.Partition, message.Offset)
+	if !ok {
+		return nil
+	}
+
+	result, err := consumer.Broker.Offsets.UpdateOne(ctx,
+		bson.M{"_id": groupOffsetId(consumer.Group, consumer.Topic, message.Partition), "owner": consumer.Owner},
+		bson.M{"$max": bson.M{"committed": offset + 1}},
+	)
+	if err == nil && result.MatchedCount == 0 {
+		err = errPartitionLost
+	}
+	if err != nil {
+		consumer.Logger.Error(
+			"Could not commit offset to mongo",
+			zap.String("topic", message.Topic),
+			zap.Int("partition", message.Partition),
+			zap.Int64("offset", offset),
+			zap.Error(err),
+		)
+	}
+	return err
+}
+
+func (consumer *mongoReader) CheckConnection() bool {
+	return consumer.Broker.Client.Ping(context.Background(), nil) == nil
+}
+
+// Releases the leases right away, so that other members don't wait for them to expire
+func (consumer *mongoReader) Disconnect() {
+	consumer.cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := consumer.Broker.Offsets.UpdateMany(ctx,
+		bson.M{"group": consumer.Group, "topic": consumer.Topic, "owner": consumer.Owner},
+		bson.M{"$unset": bson.M{"owner": ""}, "$set": bson.M{"leaseUntil": time.Time{}}},
+	)
+	if err != nil {
+		consumer.Logger.Warn("Could not release partition leases", zap.String("topic", consumer.Topic), zap.Error(err))
+	}
+}
+
+func filterMessages(messages []*Message, keep func(message *Message) bool) []*Message {
+	kept := []*Message{}
+	for _, message := range messages {
+		if keep(message) {
+			kept = append(kept, message)
+		}
+	}
+	return kept
+}
+
+type mongoAdmin struct {
+	Broker *MongoBroker
+	Logger *zap.Logger
+}
+
+func (admin *mongoAdmin) GroupOffsets(ctx context.Context, topic string, group string) ([]PartitionOffsets, error) {
+	broker := admin.Broker
+	result := make([]PartitionOffsets, broker.Settings.Partitions)
+
+	for partition := range result {
+		last, err := broker.last(ctx, topic, partition)
+		if err != nil {
+			return nil, fmt.Errorf("error happened while reading offsets of %v: %w", topic, err)
+		}
+
+		// Retention may have deleted the oldest messages already
+		first := last
+		var oldest mongoMessage
+		err = broker.Messages.FindOne(ctx,
+			bson.M{"topic": topic, "partition": partition},
+			options.FindOne().SetSort(bson.D{{Key: "offset", Value: 1}}),
+		).Decode(&oldest)
+		if err == nil {
+			first = oldest.Offset
+		} else if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("error happened while reading offsets of %v: %w", topic, err)
+		}
+
+		result[partition] = PartitionOffsets{
+			Partition: partition,
+			First:     first,
+			Last:      last,
+			Committed: -1,
+		}
+
+		var offset mongoGroupOffset
+		err = broker.Offsets.FindOne(ctx, bson.M{"_id": groupOffsetId(group, topic, partition)}).Decode(&offset)
+		if err == nil && offset.Committed != nil {
+			result[partition].Committed = *offset.Committed
+		} else if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("error happened while fetching offsets of %v: %w", group, err)
+		}
+		result[partition].Target = result[partition].Committed
+	}
+	return result, nil
+}
+
+func (admin *mongoAdmin) PlanReset(ctx context.Context, topic string, group string, reset OffsetReset) ([]PartitionOffsets, error) {
+	offsets, err := admin.GroupOffsets(ctx, topic, group)
+	if err != nil {
+		return nil, err
+	}
+	offsets, err = selectPartition(topic, offsets, reset.Partition)
+	if err != nil {
+		return nil, err
+	}
+
+	var lookupErr error
+	offsets, err = resetTargets(offsets, reset, func(partition int) (int64, bool) {
+		var message mongoMessage
+		err := admin.Broker.Messages.FindOne(ctx,
+			bson.M{"topic": topic, "partition": partition, "time": bson.M{"$gte": reset.Timestamp}},
+			options.FindOne().SetSort(bson.D{{Key: "offset", Value: 1}}),
+		).Decode(&message)
+		if err != nil {
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				lookupErr = err
+			}
+			return 0, false
+		}
+		return message.Offset, true
+	})
+	if lookupErr != nil {
+		return nil, fmt.Errorf("error happened while looking up offsets of %v by time: %w", topic, lookupErr)
+	}
+	return offsets, err
+}
+
+func (admin *mongoAdmin) CommitOffsets(ctx context.Context, topic string, group string, offsets []PartitionOffsets) error {
+	now := time.Now().UTC()
+	for _, partition := range offsets {
+		filter := bson.M{
+			"_id": groupOffsetId(group, topic, partition.Partition),
+			"$or": bson.A{
+				bson.M{"owner": bson.M{"$exists": false}},
+				bson.M{"leaseUntil": bson.M{"$lt": now}},
+			},
+		}
+		update := bson.M{"$set": bson.M{
+			"group":     group,
+			"topic":     topic,
+			"partition": partition.Partition,
+			"committed": partition.Target,
+		}}
+
+		_, err := admin.Broker.Offsets.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("partition %v of %v is leased by an active member of %v, make sure every consumer is stopped", partition.Partition, topic, group)
+		}
+		if err != nil {
+			return fmt.Errorf("error happened while committing offset of %v on partition %v: %w", group, partition.Partition, err)
+		}
+	}
+
+	admin.Logger.Info("Committed group offsets", zap.String("group", group), zap.String("topic", topic), zap.Int("partitions", len(offsets)))
+	return nil
+}
+
+func (admin *mongoAdmin) ReadPartition(ctx context.Context, topic string, partition int, from int64, to int64, handle func(message *Message) error) error {
+	if from >= to {
+		return nil
+	}
+
+	filter := bson.M{
+		"topic":     topic,
+		"partition": partition,
+		"offset":    bson.M{"$gte": from, "$lt": to},
+	}
+	cursor, err := admin.Broker.Messages.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "offset", Value: 1}}))
+	if err != nil {
+		return fmt.Errorf("error happened while reading partition %v: %w", partition, err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var message mongoMessage
+		if err := cursor.Decode(&message); err != nil {
+			return fmt.Errorf("error happened while reading partition %v: %w", partition, err)
+		}
+		if err := handle(message.toMessage(to)); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
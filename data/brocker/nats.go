@@ -0,0 +1,510 @@
+package brocker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/steadfastie/gokube/data"
+	"go.uber.org/zap"
+)
+
+// JetStream has no message keys, so the key travels as a header
+const natsKeyHeader = "x-message-key"
+
+// Pull requests wait this long for a message before the context is checked again
+const natsFetchWait = time.Second
+
+var errNatsPartition = errors.New("jetstream streams have a single partition")
+
+type NatsBrokerSettings struct {
+	Url      string
+	Replicas int
+	// Messages are deleted this long after they were written, consumed or not
+	Retention time.Duration
+	// Unacknowledged messages are redelivered after this long, unless kept alive with KeepAlive
+	AckWait time.Duration
+}
+
+// NatsBroker maps every topic to a JetStream stream with a single subject and every consumer group
+// to a durable pull consumer of the stream. Streams and consumers are provisioned on first use.
+//
+// A stream is a single partition whose offsets are stream sequences. Messages are acknowledged one by
+// one, so a message that stays unacknowledged for AckWait is redelivered even if later ones were acknowledged
+type NatsBroker struct {
+	Conn      *nats.Conn
+	JetStream jetstream.JetStream
+	Logger    *zap.Logger
+	Settings  NatsBrokerSettings
+}
+
+func NewNatsBroker(logger *zap.Logger, settings NatsBrokerSettings) (*NatsBroker, error) {
+	conn, err := nats.Connect(settings.Url,
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			logger.Warn("Disconnected from nats", zap.Error(err))
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			logger.Info("Reconnected to nats", zap.String("url", conn.ConnectedUrl()))
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error happened while connecting to nats: %w", err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error happened while opening jetstream: %w", err)
+	}
+
+	return &NatsBroker{
+		Conn:      conn,
+		JetStream: js,
+		Logger:    logger,
+		Settings:  settings,
+	}, nil
+}
+
+func (broker *NatsBroker) NewWriter(ctx context.Context, logger *zap.Logger, topic string) Producer {
+	if _, err := broker.stream(ctx, topic); err != nil {
+		logger.Error("Could not provision stream", zap.String("topic", topic), zap.Error(err))
+	}
+	return &natsWriter{Broker: broker, Topic: topic, Logger: logger}
+}
+
+func (broker *NatsBroker) NewConsumer(ctx context.Context, logger *zap.Logger, topic string, groupId string) Consumer {
+	reader := &natsReader{
+		Broker:  broker,
+		Topic:   topic,
+		Group:   groupId,
+		Logger:  logger,
+		pending: map[int64]jetstream.Msg{},
+	}
+	if _, err := reader.durable(ctx); err != nil {
+		logger.Error("Could not provision consumer", zap.String("topic", topic), zap.String("group", groupId), zap.Error(err))
+	}
+	return reader
+}
+
+func (broker *NatsBroker) NewAdmin(logger *zap.Logger) Admin {
+	return &natsAdmin{Broker: broker, Logger: logger}
+}
+
+// The client reconnects on its own and remembers the last error
+func (broker *NatsBroker) Health(ctx context.Context) Health {
+	health := Health{
+		Healthy:   broker.Conn.IsConnected(),
+		Addresses: broker.Conn.Servers(),
+	}
+	if health.Healthy {
+		health.Brokers = []string{broker.Conn.ConnectedUrl()}
+	}
+	if err := broker.Conn.LastError(); err != nil {
+		health.LastError = err.Error()
+	}
+	return health
+}
+
+// Stream names can't contain dots, e.g. counter.retry.5s is kept in COUNTER_RETRY_5S
+func streamName(topic string) string {
+	return strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(topic))
+}
+
+func (broker *NatsBroker) stream(ctx context.Context, topic string) (jetstream.Stream, error) {
+	return broker.JetStream.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      streamName(topic),
+		Subjects:  []string{topic},
+		Retention: jetstream.LimitsPolicy,
+		MaxAge:    broker.Settings.Retention,
+		Storage:   jetstream.FileStorage,
+		Replicas:  broker.Settings.Replicas,
+	})
+}
+
+// Retries are driven by the consumer processor, so the server redelivers without limit
+func (broker *NatsBroker) consumerConfig(topic string, group string) jetstream.ConsumerConfig {
+	return jetstream.ConsumerConfig{
+		Durable:       group,
+		DeliverPolicy: jetstream.DeliverAllPolicy,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       broker.Settings.AckWait,
+		MaxDeliver:    -1,
+		FilterSubject: topic,
+	}
+}
+
+func fromNatsMessage(topic string, msg jetstream.Msg) (*Message, error) {
+	metadata, err := msg.Metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	message := &Message{
+		Value:         msg.Data(),
+		Topic:         topic,
+		Partition:     0,
+		Offset:        int64(metadata.Sequence.Stream),
+		HighWaterMark: int64(metadata.Sequence.Stream + metadata.NumPending + 1),
+		Time:          metadata.Timestamp,
+	}
+	for key, values := range msg.Headers() {
+		if key == natsKeyHeader {
+			message.Key = []byte(values[0])
+			continue
+		}
+		for _, value := range values {
+			message.Headers = append(message.Headers, Header{Key: key, Value: []byte(value)})
+		}
+	}
+	return message, nil
+}
+
+type natsWriter struct {
+	Broker *NatsBroker
+	Topic  string
+	Logger *zap.Logger
+}
+
+func (producer *natsWriter) SendMessage(ctx context.Context, key []byte, value []byte) {
+	producer.PublishMessage(ctx, &Message{Key: key, Value: value})
+}
+
+// Returns once the stream has persisted the message
+func (producer *natsWriter) PublishMessage(ctx context.Context, message *Message) error {
+	return producer.publish(ctx, message).Err
+}
+
+func (producer *natsWriter) PublishAsync(ctx context.Context, message *Message) *Delivery {
+	return publishAsync(ctx, message, producer.publish)
+}
+
+func (producer *natsWriter) publish(ctx context.Context, message *Message) DeliveryReport {
+	report := DeliveryReport{Topic: producer.Topic}
+	msg := nats.NewMsg(producer.Topic)
+	msg.Data = message.Value
+	if len(message.Key) > 0 {
+		msg.Header.Set(natsKeyHeader, string(message.Key))
+	}
+	for _, header := range message.Headers {
+		msg.Header.Add(header.Key, string(header.Value))
+	}
+
+	retryConfig := &data.RetryConfig{
+		Context:           ctx,
+		Logger:            producer.Logger,
+		RecoverableErrors: []error{nats.ErrTimeout, nats.ErrNoResponders, context.DeadlineExceeded},
+	}
+	err := data.WithRetry(retryConfig, func() error {
+		ack, err := producer.Broker.JetStream.PublishMsg(retryConfig.Context, msg)
+		if err == nil {
+			report.Offset = int64(ack.Sequence)
+		}
+		return err
+	})
+	if err != nil {
+		producer.Logger.Error("Could not write a message to nats", zap.String("topic", producer.Topic), zap.Error(err))
+		report.Err = err
+	}
+	return report
+}
+
+func (producer *natsWriter) CheckConnection() bool {
+	return producer.Broker.Conn.IsConnected()
+}
+
+func (producer *natsWriter) Disconnect() {}
+
+type natsReader struct {
+	Broker *NatsBroker
+	Topic  string
+	Group  string
+	Logger *zap.Logger
+
+	mu       sync.Mutex
+	consumer jetstream.Consumer
+	// Fetched messages waiting for their acknowledgement, by stream sequence
+	pending map[int64]jetstream.Msg
+}
+
+// Provisions the durable consumer lazily, so that a reader created while nats is down recovers later
+func (consumer *natsReader) durable(ctx context.Context) (jetstream.Consumer, error) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	if consumer.consumer != nil {
+		return consumer.consumer, nil
+	}
+
+	broker := consumer.Broker
+	if _, err := broker.stream(ctx, consumer.Topic); err != nil {
+		return nil, fmt.Errorf("error happened while provisioning stream of %v: %w", consumer.Topic, err)
+	}
+	// An existing consumer is left as it is, as it may have been reset to a start sequence
+	durable, err := broker.JetStream.Consumer(ctx, streamName(consumer.Topic), consumer.Group)
+	if errors.Is(err, jetstream.ErrConsumerNotFound) {
+		durable, err = broker.JetStream.CreateConsumer(ctx, streamName(consumer.Topic), broker.consumerConfig(consumer.Topic, consumer.Group))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error happened while provisioning consumer %v: %w", consumer.Group, err)
+	}
+	consumer.consumer = durable
+	return durable, nil
+}
+
+func (consumer *natsReader) RecieveMessage(ctx context.Context, resultChan chan<- *Message, errChan chan<- error) {
+	durable, err := consumer.durable(ctx)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	for {
+		msg, err := durable.Next(jetstream.FetchMaxWait(natsFetchWait))
+		if errors.Is(err, nats.ErrTimeout) {
+			if ctx.Err() != nil {
+				errChan <- ctx.Err()
+				return
+			}
+			continue
+		}
+		if err != nil {
+			consumer.Logger.Error("Could not read a message from nats", zap.String("topic", consumer.Topic), zap.Error(err))
+			errChan <- err
+			return
+		}
+
+		message, err := fromNatsMessage(consumer.Topic, msg)
+		if err != nil {
+			errChan <- err
+			return
+		}
+		consumer.Logger.Info(
+			"Recieved a message from nats",
+			zap.String("key", string(message.Key)),
+			zap.String("topic", message.Topic),
+			zap.Int64("offset", message.Offset),
+			zap.Int64("messages in nats", message.HighWaterMark),
+		)
+
+		consumer.mu.Lock()
+		consumer.pending[message.Offset] = msg
+		consumer.mu.Unlock()
+
+		resultChan <- message
+		return
+	}
+}
+
+// Acknowledges the message alone and waits for the server to confirm it
+func (consumer *natsReader) CommitMessage(ctx context.Context, message *Message) error {
+	consumer.mu.Lock()
+	msg, ok := consumer.pending[message.Offset]
+	delete(consumer.pending, message.Offset)
+	consumer.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	err := msg.DoubleAck(ctx)
+	if err != nil {
+		consumer.Logger.Error(
+			"Could not acknowledge message in nats",
+			zap.String("topic", message.Topic),
+			zap.Int64("offset", message.Offset),
+			zap.Error(err),
+		)
+	}
+	return err
+}
+
+// Tells the server the message is still being worked on, a few times per AckWait
+func (consumer *natsReader) keepAlive(message *Message) func() {
+	consumer.mu.Lock()
+	msg, ok := consumer.pending[message.Offset]
+	consumer.mu.Unlock()
+	if !ok {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(max(consumer.Broker.Settings.AckWait/3, time.Millisecond))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := msg.InProgress(); err != nil {
+				consumer.Logger.Warn("Could not extend the ack wait of a message", zap.String("topic", message.Topic), zap.Int64("offset", message.Offset), zap.Error(err))
+			}
+		}
+	}()
+	return cancel
+}
+
+func (consumer *natsReader) CheckConnection() bool {
+	return consumer.Broker.Conn.IsConnected()
+}
+
+func (consumer *natsReader) Disconnect() {}
+
+type natsAdmin struct {
+	Broker *NatsBroker
+	Logger *zap.Logger
+}
+
+// Committed is the oldest unacknowledged message of the group
+func (admin *natsAdmin) GroupOffsets(ctx context.Context, topic string, group string) ([]PartitionOffsets, error) {
+	stream, err := admin.Broker.JetStream.Stream(ctx, streamName(topic))
+	if err != nil {
+		return nil, fmt.Errorf("error happened while reading stream of %v: %w", topic, err)
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error happened while reading stream of %v: %w", topic, err)
+	}
+
+	offsets := PartitionOffsets{
+		Partition: 0,
+		First:     int64(info.State.FirstSeq),
+		Last:      int64(info.State.LastSeq + 1),
+		Committed: -1,
+	}
+
+	consumer, err := stream.Consumer(ctx, group)
+	if err != nil && !errors.Is(err, jetstream.ErrConsumerNotFound) {
+		return nil, fmt.Errorf("error happened while reading consumer %v: %w", group, err)
+	}
+	if err == nil {
+		consumerInfo, err := consumer.Info(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error happened while reading consumer %v: %w", group, err)
+		}
+		offsets.Committed = max(int64(consumerInfo.AckFloor.Stream+1), offsets.First)
+	}
+	offsets.Target = offsets.Committed
+	return []PartitionOffsets{offsets}, nil
+}
+
+func (admin *natsAdmin) PlanReset(ctx context.Context, topic string, group string, reset OffsetReset) ([]PartitionOffsets, error) {
+	offsets, err := admin.GroupOffsets(ctx, topic, group)
+	if err != nil {
+		return nil, err
+	}
+	offsets, err = selectPartition(topic, offsets, reset.Partition)
+	if err != nil {
+		return nil, err
+	}
+
+	var lookupErr error
+	offsets, err = resetTargets(offsets, reset, func(partition int) (int64, bool) {
+		offset, ok, err := admin.offsetAt(ctx, topic, reset.Timestamp)
+		lookupErr = err
+		return offset, ok
+	})
+	if lookupErr != nil {
+		return nil, fmt.Errorf("error happened while looking up offsets of %v by time: %w", topic, lookupErr)
+	}
+	return offsets, err
+}
+
+// A durable consumer can't be moved, so it is recreated to start from the target sequence.
+// Messages the group has fetched but not acknowledged yet are forgotten
+func (admin *natsAdmin) CommitOffsets(ctx context.Context, topic string, group string, offsets []PartitionOffsets) error {
+	for _, partition := range offsets {
+		if partition.Partition != 0 {
+			return errNatsPartition
+		}
+
+		err := admin.Broker.JetStream.DeleteConsumer(ctx, streamName(topic), group)
+		if err != nil && !errors.Is(err, jetstream.ErrConsumerNotFound) {
+			return fmt.Errorf("error happened while deleting consumer %v: %w", group, err)
+		}
+
+		config := admin.Broker.consumerConfig(topic, group)
+		config.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		config.OptStartSeq = uint64(max(partition.Target, 1))
+		if _, err := admin.Broker.JetStream.CreateConsumer(ctx, streamName(topic), config); err != nil {
+			return fmt.Errorf("error happened while recreating consumer %v: %w", group, err)
+		}
+	}
+
+	admin.Logger.Info("Committed group offsets", zap.String("group", group), zap.String("topic", topic), zap.Int("partitions", len(offsets)))
+	return nil
+}
+
+func (admin *natsAdmin) ReadPartition(ctx context.Context, topic string, partition int, from int64, to int64, handle func(message *Message) error) error {
+	if partition != 0 {
+		return errNatsPartition
+	}
+	if from >= to {
+		return nil
+	}
+
+	reader, err := admin.Broker.JetStream.OrderedConsumer(ctx, streamName(topic), jetstream.OrderedConsumerConfig{
+		DeliverPolicy: jetstream.DeliverByStartSequencePolicy,
+		OptStartSeq:   uint64(max(from, 1)),
+	})
+	if err != nil {
+		return fmt.Errorf("error happened while reading %v: %w", topic, err)
+	}
+
+	for ctx.Err() == nil {
+		msg, err := reader.Next(jetstream.FetchMaxWait(natsFetchWait))
+		// Nothing was written after the last read message
+		if errors.Is(err, nats.ErrTimeout) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error happened while reading %v: %w", topic, err)
+		}
+
+		message, err := fromNatsMessage(topic, msg)
+		if err != nil {
+			return err
+		}
+		if message.Offset >= to {
+			return nil
+		}
+		if err := handle(message); err != nil {
+			return err
+		}
+		if message.Offset+1 >= to {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// Looks up the first sequence written at or after the timestamp
+func (admin *natsAdmin) offsetAt(ctx context.Context, topic string, timestamp time.Time) (int64, bool, error) {
+	reader, err := admin.Broker.JetStream.OrderedConsumer(ctx, streamName(topic), jetstream.OrderedConsumerConfig{
+		DeliverPolicy: jetstream.DeliverByStartTimePolicy,
+		OptStartTime:  &timestamp,
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	msg, err := reader.Next(jetstream.FetchMaxWait(natsFetchWait))
+	if errors.Is(err, nats.ErrTimeout) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	metadata, err := msg.Metadata()
+	if err != nil {
+		return 0, false, err
+	}
+	return int64(metadata.Sequence.Stream), true, nil
+}
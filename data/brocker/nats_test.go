@@ -0,0 +1,158 @@
+package brocker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"go.uber.org/zap"
+)
+
+func newTestNatsBroker(t *testing.T, ackWait time.Duration) *NatsBroker {
+	t.Helper()
+	options := natsserver.DefaultTestOptions
+	options.Port = -1
+	options.JetStream = true
+	options.StoreDir = t.TempDir()
+	server := natsserver.RunServer(&options)
+	t.Cleanup(server.Shutdown)
+
+	broker, err := NewNatsBroker(zap.NewNop(), NatsBrokerSettings{
+		Url:       server.ClientURL(),
+		Replicas:  1,
+		Retention: time.Hour,
+		AckWait:   ackWait,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(broker.Conn.Close)
+	return broker
+}
+
+func TestNatsPublishAndConsume(t *testing.T) {
+	ctx := context.Background()
+	broker := newTestNatsBroker(t, time.Minute)
+	producer := broker.NewWriter(ctx, zap.NewNop(), "counter.events")
+
+	for i, value := range []string{"first", "second"} {
+		report, err := producer.PublishAsync(ctx, &Message{
+			Key:     []byte("counter-1"),
+			Value:   []byte(value),
+			Headers: []Header{{Key: HeaderTenant, Value: []byte("acme")}},
+		}).Wait(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Offset != int64(i+1) {
+			t.Errorf("Message %v got offset %d, want the stream sequence %d", value, report.Offset, i+1)
+		}
+	}
+
+	consumer := broker.NewConsumer(ctx, zap.NewNop(), "counter.events", "test")
+	for i, value := range []string{"first", "second"} {
+		message := receiveMessage(t, consumer)
+		if string(message.Value) != value || string(message.Key) != "counter-1" || message.Offset != int64(i+1) {
+			t.Errorf("Received %s/%s at %d, want counter-1/%v at %d", message.Key, message.Value, message.Offset, value, i+1)
+		}
+		if tenant, _ := message.Header(HeaderTenant); tenant != "acme" {
+			t.Errorf("Tenant header = %q, want acme", tenant)
+		}
+		if err := consumer.CommitMessage(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	offsets, err := broker.NewAdmin(zap.NewNop()).GroupOffsets(ctx, "counter.events", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offsets[0].Committed != 3 || offsets[0].Last != 3 {
+		t.Errorf("Group offsets = %+v, want everything committed", offsets[0])
+	}
+}
+
+// The durable consumer outlives its readers, so a new member of the group continues after the last acknowledgement
+func TestNatsDurableConsumer(t *testing.T) {
+	ctx := context.Background()
+	broker := newTestNatsBroker(t, time.Minute)
+	producer := broker.NewWriter(ctx, zap.NewNop(), "counter.events")
+	for _, value := range []string{"first", "second"} {
+		if err := producer.PublishMessage(ctx, &Message{Value: []byte(value)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := broker.NewConsumer(ctx, zap.NewNop(), "counter.events", "test")
+	message := receiveMessage(t, first)
+	if err := first.CommitMessage(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+	first.Disconnect()
+
+	second := broker.NewConsumer(ctx, zap.NewNop(), "counter.events", "test")
+	if message := receiveMessage(t, second); string(message.Value) != "second" {
+		t.Errorf("New member read %q, want the unacknowledged second", message.Value)
+	}
+}
+
+func TestNatsRedeliversAfterAckWait(t *testing.T) {
+	ctx := context.Background()
+	broker := newTestNatsBroker(t, 300*time.Millisecond)
+	if err := broker.NewWriter(ctx, zap.NewNop(), "counter.events").PublishMessage(ctx, &Message{Value: []byte("event")}); err != nil {
+		t.Fatal(err)
+	}
+
+	consumer := broker.NewConsumer(ctx, zap.NewNop(), "counter.events", "test")
+	first := receiveMessage(t, consumer)
+	redelivered := receiveMessage(t, consumer)
+	if redelivered.Offset != first.Offset {
+		t.Fatalf("Received offset %d, want %d redelivered", redelivered.Offset, first.Offset)
+	}
+	if err := consumer.CommitMessage(ctx, redelivered); err != nil {
+		t.Fatal(err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if message, ok := tryReceive(waitCtx, consumer); ok {
+		t.Errorf("Acknowledged message %d was redelivered", message.Offset)
+	}
+}
+
+func TestNatsKeepAlivePostponesRedelivery(t *testing.T) {
+	ctx := context.Background()
+	broker := newTestNatsBroker(t, 300*time.Millisecond)
+	if err := broker.NewWriter(ctx, zap.NewNop(), "counter.events").PublishMessage(ctx, &Message{Value: []byte("event")}); err != nil {
+		t.Fatal(err)
+	}
+
+	consumer := broker.NewConsumer(ctx, zap.NewNop(), "counter.events", "test")
+	message := receiveMessage(t, consumer)
+	stop := KeepAlive(consumer, message)
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if redelivered, ok := tryReceive(waitCtx, consumer); ok {
+		t.Errorf("Message %d was redelivered while kept alive", redelivered.Offset)
+	}
+
+	stop()
+	if redelivered := receiveMessage(t, consumer); redelivered.Offset != message.Offset {
+		t.Errorf("Received offset %d, want %d redelivered once no longer kept alive", redelivered.Offset, message.Offset)
+	}
+}
+
+func tryReceive(ctx context.Context, consumer Consumer) (*Message, bool) {
+	messageChan := make(chan *Message, 1)
+	errChan := make(chan error, 1)
+	go consumer.RecieveMessage(ctx, messageChan, errChan)
+
+	select {
+	case message := <-messageChan:
+		return message, true
+	case <-errChan:
+		return nil, false
+	}
+}
@@ -0,0 +1,60 @@
+package brocker
+
+import "sync"
+
+type partitionKey struct {
+	topic     string
+	partition int
+}
+
+// offsetTracker lets messages be processed out of order while committing offsets in order.
+// A partition offset becomes committable only when every message fetched before it is done
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[partitionKey]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	pending []int64
+	done    map[int64]bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		partitions: map[partitionKey]*partitionOffsets{},
+	}
+}
+
+func (tracker *offsetTracker) fetched(topic string, partition int, offset int64) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	key := partitionKey{topic: topic, partition: partition}
+	offsets, ok := tracker.partitions[key]
+	// A rewind means the partition got reassigned and is being read from the last commit again
+	if !ok || (len(offsets.pending) > 0 && offset <= offsets.pending[len(offsets.pending)-1]) {
+		offsets = &partitionOffsets{done: map[int64]bool{}}
+		tracker.partitions[key] = offsets
+	}
+	offsets.pending = append(offsets.pending, offset)
+}
+
+// Marks the offset as done and returns the highest offset that is safe to commit
+func (tracker *offsetTracker) complete(topic string, partition int, offset int64) (int64, bool) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	offsets, ok := tracker.partitions[partitionKey{topic: topic, partition: partition}]
+	if !ok {
+		return 0, false
+	}
+	offsets.done[offset] = true
+
+	committable, found := int64(0), false
+	for len(offsets.pending) > 0 && offsets.done[offsets.pending[0]] {
+		committable, found = offsets.pending[0], true
+		delete(offsets.done, offsets.pending[0])
+		offsets.pending = offsets.pending[1:]
+	}
+	return committable, found
+}
@@ -0,0 +1,65 @@
+package brocker
+
+import "testing"
+
+func TestOffsetTrackerCommitsInOrder(t *testing.T) {
+	tracker := newOffsetTracker()
+	for offset := int64(0); offset < 4; offset++ {
+		tracker.fetched("counter", 0, offset)
+	}
+
+	steps := []struct {
+		complete    int64
+		committable int64
+		ok          bool
+	}{
+		// Nothing before 2 is done, so nothing is safe to commit yet
+		{2, 0, false},
+		{1, 0, false},
+		// 0 unblocks 1 and 2
+		{0, 2, true},
+		{3, 3, true},
+	}
+	for _, step := range steps {
+		committable, ok := tracker.complete("counter", 0, step.complete)
+		if committable != step.committable || ok != step.ok {
+			t.Errorf("complete(%d) = %d, %v, want %d, %v", step.complete, committable, ok, step.committable, step.ok)
+		}
+	}
+}
+
+func TestOffsetTrackerKeepsPartitionsApart(t *testing.T) {
+	tracker := newOffsetTracker()
+	tracker.fetched("counter", 0, 5)
+	tracker.fetched("counter", 1, 7)
+	tracker.fetched("archive", 0, 5)
+
+	if committable, ok := tracker.complete("counter", 1, 7); !ok || committable != 7 {
+		t.Errorf("complete(counter/1, 7) = %d, %v, want 7, true", committable, ok)
+	}
+	if _, ok := tracker.complete("archive", 1, 5); ok {
+		t.Error("Partition that was never fetched became committable")
+	}
+	if committable, ok := tracker.complete("counter", 0, 5); !ok || committable != 5 {
+		t.Errorf("complete(counter/0, 5) = %d, %v, want 5, true", committable, ok)
+	}
+}
+
+// After a rebalance the partition is read again from the last commit, and what was pending before is forgotten
+func TestOffsetTrackerResetsOnRewind(t *testing.T) {
+	tracker := newOffsetTracker()
+	tracker.fetched("counter", 0, 3)
+	tracker.fetched("counter", 0, 4)
+	tracker.fetched("counter", 0, 5)
+
+	tracker.fetched("counter", 0, 4)
+	tracker.fetched("counter", 0, 5)
+
+	// 3 was never completed, yet it no longer blocks the offsets read after the rewind
+	if committable, ok := tracker.complete("counter", 0, 4); !ok || committable != 4 {
+		t.Errorf("complete(4) = %d, %v, want 4, true", committable, ok)
+	}
+	if committable, ok := tracker.complete("counter", 0, 5); !ok || committable != 5 {
+		t.Errorf("complete(5) = %d, %v, want 5, true", committable, ok)
+	}
+}
@@ -9,51 +9,109 @@ import (
 	"go.uber.org/zap"
 )
 
-const topic = "counter"
+type Compression string
+
+const (
+	CompressionNone   Compression = "none"
+	CompressionGzip   Compression = "gzip"
+	CompressionSnappy Compression = "snappy"
+	CompressionLz4    Compression = "lz4"
+	CompressionZstd   Compression = "zstd"
+)
+
+type Acks string
+
+const (
+	// The leader doesn't even answer, so a delivery only means the message has left the process
+	AcksNone   Acks = "none"
+	AcksLeader Acks = "leader"
+	// Every in-sync replica has the message
+	AcksAll Acks = "all"
+)
+
+// ProducerSettings tune how the Kafka writer batches messages
+type ProducerSettings struct {
+	// Writes return before the batch is flushed and deliveries resolve later
+	Async bool
+	// A batch is flushed when it is this old, has BatchSize messages or BatchBytes bytes, whichever comes first
+	Linger      time.Duration
+	BatchSize   int
+	BatchBytes  int64
+	Compression Compression
+	Acks        Acks
+}
 
 type Producer interface {
 	SendMessage(ctx context.Context, key []byte, value []byte)
+	// PublishMessage writes key, value and headers of the message and reports whether it succeeded
+	PublishMessage(ctx context.Context, message *Message) error
+	// PublishAsync hands the message over and returns a delivery that resolves with the partition and offset
+	// it was written to. Only the async Kafka writer returns before the broker has it. Messages published
+	// one after another keep their order
+	PublishAsync(ctx context.Context, message *Message) *Delivery
 	CheckConnection() bool
 	Disconnect()
 }
 
 type kafkaWriter struct {
-	Conn   *kafka.Conn
-	Writer *kafka.Writer
-	Logger *zap.Logger
+	Manager  *ConnectionManager
+	Writer   *kafka.Writer
+	Settings ProducerSettings
+	Logger   *zap.Logger
 }
 
-func NewWriter(ctx context.Context, logger *zap.Logger, addresses ...string) Producer {
-	conn, _ := kafka.DialLeader(ctx, "tcp", addresses[0], topic, 0)
-
+// Topics are auto-created on first write only when they are left to the cluster. Otherwise a topic that failed
+// to be provisioned would be created with a single partition and the cluster defaults
+func NewWriter(ctx context.Context, logger *zap.Logger, topic string, manager *ConnectionManager, settings ProducerSettings, autoCreateTopics bool) Producer {
+	connection := manager.Connection
 	w := &kafka.Writer{
-		Addr:                   kafka.TCP(addresses...),
+		Addr:                   kafka.TCP(connection.Addresses...),
+		Transport:              connection.Transport,
 		Topic:                  topic,
-		AllowAutoTopicCreation: true,
-		Balancer:               &kafka.LeastBytes{},
-		RequiredAcks:           1,
-		WriteTimeout:           10 * time.Second,
+		AllowAutoTopicCreation: autoCreateTopics,
+		// Messages of a counter share its key and land in the same partition, which keeps them in order
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: requiredAcks(settings.Acks),
+		Async:        settings.Async,
+		BatchTimeout: settings.Linger,
+		BatchSize:    settings.BatchSize,
+		BatchBytes:   settings.BatchBytes,
+		Compression:  compression(settings.Compression),
+		WriteTimeout: 10 * time.Second,
+		// Called for every batch in both modes, with partitions and offsets filled in
+		Completion: func(messages []kafka.Message, err error) {
+			for _, message := range messages {
+				if delivery, ok := message.WriterData.(*Delivery); ok {
+					delivery.resolve(DeliveryReport{Topic: message.Topic, Partition: message.Partition, Offset: message.Offset, Err: err})
+				}
+			}
+		},
 	}
 
 	connector := &kafkaWriter{
-		Conn:   conn,
-		Writer: w,
-		Logger: logger,
+		Manager:  manager,
+		Writer:   w,
+		Settings: settings,
+		Logger:   logger,
 	}
 	return connector
 }
 
+// Waits for the pending batches to be flushed
 func (producer *kafkaWriter) Disconnect() {
 	producer.Writer.Close()
 }
 
-// Calls metadata endpoint. See https://github.com/segmentio/kafka-go/issues/389#issuecomment-569334516
+// The writer has no connection of its own until it writes, so it relies on the manager's checks
 func (producer *kafkaWriter) CheckConnection() bool {
-	_, err := producer.Conn.Brokers()
-	return err == nil
+	return producer.Manager.Health().Healthy
 }
 
 func (producer *kafkaWriter) SendMessage(ctx context.Context, key []byte, value []byte) {
+	producer.PublishMessage(ctx, &Message{Key: key, Value: value})
+}
+
+func (producer *kafkaWriter) PublishMessage(ctx context.Context, message *Message) error {
 	retryConfig := &data.RetryConfig{
 		Context:           ctx,
 		Logger:            producer.Logger,
@@ -61,14 +119,59 @@ func (producer *kafkaWriter) SendMessage(ctx context.Context, key []byte, value
 	}
 
 	err := data.WithRetry(retryConfig, func() error {
-		return producer.Writer.WriteMessages(retryConfig.Context,
-			kafka.Message{
-				Key:   key,
-				Value: value,
-			},
-		)
+		_, err := producer.PublishAsync(retryConfig.Context, message).Wait(retryConfig.Context)
+		return err
 	})
 	if err != nil {
-		producer.Logger.Error("Could not write a message to kafka", zap.Error(err))
+		producer.Logger.Error("Could not write a message to kafka", zap.String("topic", producer.Writer.Topic), zap.Error(err))
+	}
+	return err
+}
+
+// In async mode the writer queues the message and returns. Otherwise WriteMessages blocks until the batch
+// is flushed, and so does PublishAsync. Either way messages written one after another keep their order
+func (producer *kafkaWriter) PublishAsync(ctx context.Context, message *Message) *Delivery {
+	headers := make([]kafka.Header, len(message.Headers))
+	for i, header := range message.Headers {
+		headers[i] = kafka.Header{Key: header.Key, Value: header.Value}
+	}
+
+	delivery := newDelivery()
+	err := producer.Writer.WriteMessages(ctx, kafka.Message{
+		Key:        message.Key,
+		Value:      message.Value,
+		Headers:    headers,
+		WriterData: delivery,
+	})
+	// Completion is not called for messages that never made it into a batch, nor before ctx is done
+	if err != nil {
+		delivery.resolve(DeliveryReport{Topic: producer.Writer.Topic, Err: err})
+	}
+	return delivery
+}
+
+func requiredAcks(acks Acks) kafka.RequiredAcks {
+	switch acks {
+	case AcksNone:
+		return kafka.RequireNone
+	case AcksAll:
+		return kafka.RequireAll
+	default:
+		return kafka.RequireOne
+	}
+}
+
+func compression(compression Compression) kafka.Compression {
+	switch compression {
+	case CompressionGzip:
+		return kafka.Gzip
+	case CompressionSnappy:
+		return kafka.Snappy
+	case CompressionLz4:
+		return kafka.Lz4
+	case CompressionZstd:
+		return kafka.Zstd
+	default:
+		return 0
 	}
 }
@@ -0,0 +1,77 @@
+package brocker
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers carried by messages travelling through the retry topics
+const (
+	HeaderRetryCount = "x-retry-count"
+	HeaderRetryDue   = "x-retry-due"
+	HeaderRetryError = "x-retry-error"
+	// Comma separated names of the consumer handlers that have to run again
+	HeaderRetryHandlers = "x-retry-handlers"
+)
+
+const retryHeaderPrefix = "x-retry-"
+
+func RetryConsumerGroup(tier string) string {
+	return CounterConsumerGroup + "-retry-" + tier
+}
+
+// NewRetryMessage keeps key, value and headers of the original message and schedules it for the given time.
+// When handlers are listed, only they run once the message is due
+func NewRetryMessage(message *Message, cause error, count int, due time.Time, handlers []string) *Message {
+	headers := StripDeadLetterHeaders(message).Headers
+	headers = append(headers,
+		Header{Key: HeaderRetryCount, Value: []byte(strconv.Itoa(count))},
+		Header{Key: HeaderRetryDue, Value: []byte(strconv.FormatInt(due.UnixMilli(), 10))},
+		Header{Key: HeaderRetryError, Value: []byte(cause.Error())},
+	)
+	if len(handlers) > 0 {
+		headers = append(headers, Header{Key: HeaderRetryHandlers, Value: []byte(strings.Join(handlers, ","))})
+	}
+
+	return &Message{
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: headers,
+	}
+}
+
+// RetryCount tells how many times the message has already been sent to the retry topics
+func RetryCount(message *Message) int {
+	value, ok := message.Header(HeaderRetryCount)
+	if !ok {
+		return 0
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+	return count
+}
+
+// RetryHandlers lists the handlers the message is retried for. Empty means every handler
+func RetryHandlers(message *Message) []string {
+	value, ok := message.Header(HeaderRetryHandlers)
+	if !ok || value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// RetryDue tells when the message is supposed to be handled again
+func RetryDue(message *Message) (time.Time, bool) {
+	value, ok := message.Header(HeaderRetryDue)
+	if !ok {
+		return time.Time{}, false
+	}
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(millis), true
+}
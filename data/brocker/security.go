@@ -0,0 +1,112 @@
+package brocker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// KafkaSecurity describes how clients authenticate to the cluster. The zero value is plaintext without authentication
+type KafkaSecurity struct {
+	TLS bool
+	// PEM bundle to verify brokers with. System roots are used when empty
+	CAFile string
+	// Client certificate and key for mutual TLS
+	CertFile string
+	KeyFile  string
+	// One of PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512. Empty disables SASL
+	SASLMechanism string
+	Username      string
+	Password      string
+}
+
+// KafkaConnection carries the addresses and the secured dialer and transport every Kafka client is built with
+type KafkaConnection struct {
+	Addresses []string
+	// Used by readers and the health-check connection
+	Dialer *kafka.Dialer
+	// Used by writers and the admin client
+	Transport *kafka.Transport
+}
+
+func NewKafkaConnection(addresses []string, security KafkaSecurity) (*KafkaConnection, error) {
+	tlsConfig, err := security.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	mechanism, err := security.mechanism()
+	if err != nil {
+		return nil, err
+	}
+
+	return &KafkaConnection{
+		Addresses: addresses,
+		Dialer: &kafka.Dialer{
+			Timeout:       10 * time.Second,
+			DualStack:     true,
+			TLS:           tlsConfig,
+			SASLMechanism: mechanism,
+		},
+		Transport: &kafka.Transport{
+			TLS:  tlsConfig,
+			SASL: mechanism,
+		},
+	}, nil
+}
+
+func (security KafkaSecurity) tlsConfig() (*tls.Config, error) {
+	if !security.TLS {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if security.CAFile != "" {
+		bundle, err := os.ReadFile(security.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error happened while reading kafka CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("kafka CA bundle %v has no PEM certificates", security.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if security.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(security.CertFile, security.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error happened while loading kafka client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+func (security KafkaSecurity) mechanism() (sasl.Mechanism, error) {
+	switch strings.ToUpper(security.SASLMechanism) {
+	case "":
+		return nil, nil
+	case SASLPlain:
+		return plain.Mechanism{Username: security.Username, Password: security.Password}, nil
+	case SASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, security.Username, security.Password)
+	case SASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, security.Username, security.Password)
+	default:
+		return nil, fmt.Errorf("unknown SASL mechanism %q", security.SASLMechanism)
+	}
+}
@@ -0,0 +1,335 @@
+package brocker
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/steadfastie/gokube/data/errors"
+)
+
+// Variables every service talking to the broker reads the same way
+const (
+	EnvBroker                = "BROKER"
+	EnvKafkaAddresses        = "KAFKA_ADDRESSES"
+	EnvKafkaTLS              = "KAFKA_TLS"
+	EnvKafkaCAFile           = "KAFKA_CA_FILE"
+	EnvKafkaCertFile         = "KAFKA_CERT_FILE"
+	EnvKafkaKeyFile          = "KAFKA_KEY_FILE"
+	EnvKafkaSASLMechanism    = "KAFKA_SASL_MECHANISM"
+	EnvKafkaUsername         = "KAFKA_USERNAME"
+	EnvKafkaPassword         = "KAFKA_PASSWORD"
+	EnvMongoBrokerPartitions = "MONGO_BROKER_PARTITIONS"
+	EnvMongoBrokerRetention  = "MONGO_BROKER_RETENTION"
+	EnvNatsUrl               = "NATS_URL"
+	EnvNatsReplicas          = "NATS_REPLICAS"
+	EnvNatsRetention         = "NATS_RETENTION"
+	EnvNatsAckWait           = "NATS_ACK_WAIT"
+	EnvCounterTopic          = "COUNTER_TOPIC"
+	EnvTopicPartitions       = "TOPIC_PARTITIONS"
+	EnvTopicReplication      = "TOPIC_REPLICATION_FACTOR"
+	EnvTopicRetention        = "TOPIC_RETENTION"
+	EnvTopicCleanupPolicy    = "TOPIC_CLEANUP_POLICY"
+	EnvTopicProvisioning     = "TOPIC_PROVISIONING"
+	EnvBrokerStartup         = "BROKER_STARTUP"
+	EnvBrokerMinBackoff      = "BROKER_MIN_BACKOFF"
+	EnvBrokerMaxBackoff      = "BROKER_MAX_BACKOFF"
+	EnvBrokerCheckInterval   = "BROKER_CHECK_INTERVAL"
+	EnvProducerAsync         = "PRODUCER_ASYNC"
+	EnvProducerLinger        = "PRODUCER_LINGER"
+	EnvProducerBatchSize     = "PRODUCER_BATCH_SIZE"
+	EnvProducerBatchBytes    = "PRODUCER_BATCH_BYTES"
+	EnvProducerCompression   = "PRODUCER_COMPRESSION"
+	EnvProducerAcks          = "PRODUCER_ACKS"
+)
+
+// SettingsFromEnv reads the backend, its connection, the producer and the topics from the environment.
+// Panics on invalid values like the service configs do. Topics other than the counter one are left to the services
+func SettingsFromEnv() BrokerSettings {
+	kafkaBootstrapServer := os.Getenv(EnvKafkaAddresses)
+	addresses := []string{}
+	if kafkaBootstrapServer == "" {
+		addresses = append(addresses, "localhost")
+	} else {
+		addresses = append(addresses, strings.Split(kafkaBootstrapServer, ",")...)
+	}
+
+	// The mongo broker keeps topics next to the data, for deployments that would rather not run Kafka.
+	// The memory one is built by tests only: the outbox and the consumer run as separate processes,
+	// so events published to one would never reach the other and would be lost once removed from the outbox
+	broker := Backend(strings.ToLower(os.Getenv(EnvBroker)))
+	switch broker {
+	case "":
+		broker = KafkaBackend
+	case KafkaBackend, MongoBackend, NatsBackend:
+	case "memory":
+		panic(errors.NewBusinessRuleError("Memory broker can't be configured, it only lives within a process and would lose every event"))
+	default:
+		panic(errors.NewBusinessRuleError("Broker must be one of kafka, mongo or nats"))
+	}
+
+	mongoBrokerPartitions := 1 // Defaults to a single partition, i.e. a single active consumer per group
+	if value := os.Getenv(EnvMongoBrokerPartitions); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			panic(errors.NewBusinessRuleError("Mongo broker partitions must be a positive integer"))
+		}
+		mongoBrokerPartitions = parsed
+	}
+
+	mongoBrokerRetention := 7 * 24 * time.Hour // Defaults to keeping messages for a week, as Kafka does
+	if value := os.Getenv(EnvMongoBrokerRetention); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < time.Second {
+			panic(errors.NewBusinessRuleError("Mongo broker retention must be a duration of at least one second"))
+		}
+		mongoBrokerRetention = parsed
+	}
+
+	natsUrl := os.Getenv(EnvNatsUrl)
+	if natsUrl == "" {
+		natsUrl = "nats://localhost:4222"
+	}
+
+	natsReplicas := 1 // Defaults to a single replica of every stream
+	if value := os.Getenv(EnvNatsReplicas); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 5 {
+			panic(errors.NewBusinessRuleError("Nats replicas must be between 1 and 5"))
+		}
+		natsReplicas = parsed
+	}
+
+	natsRetention := 7 * 24 * time.Hour // Defaults to keeping messages for a week, as Kafka does
+	if value := os.Getenv(EnvNatsRetention); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			panic(errors.NewBusinessRuleError("Nats retention must be a positive duration"))
+		}
+		natsRetention = parsed
+	}
+
+	natsAckWait := time.Minute // Defaults to redelivering messages left unacknowledged for a minute
+	if value := os.Getenv(EnvNatsAckWait); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			panic(errors.NewBusinessRuleError("Nats ack wait must be a positive duration"))
+		}
+		natsAckWait = parsed
+	}
+
+	kafkaSecurity := KafkaSecurity{
+		CAFile:        os.Getenv(EnvKafkaCAFile),
+		CertFile:      os.Getenv(EnvKafkaCertFile),
+		KeyFile:       os.Getenv(EnvKafkaKeyFile),
+		SASLMechanism: strings.ToUpper(os.Getenv(EnvKafkaSASLMechanism)),
+		Username:      os.Getenv(EnvKafkaUsername),
+		Password:      os.Getenv(EnvKafkaPassword),
+	}
+	// Defaults to plaintext, unless a CA bundle or a client certificate is given
+	kafkaSecurity.TLS = kafkaSecurity.CAFile != "" || kafkaSecurity.CertFile != ""
+	if value := os.Getenv(EnvKafkaTLS); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			panic(errors.NewBusinessRuleError("Kafka TLS must be a boolean"))
+		}
+		if !parsed && kafkaSecurity.TLS {
+			panic(errors.NewBusinessRuleError("Kafka TLS cannot be disabled while a CA bundle or a client certificate is set"))
+		}
+		kafkaSecurity.TLS = parsed
+	}
+	if (kafkaSecurity.CertFile == "") != (kafkaSecurity.KeyFile == "") {
+		panic(errors.NewBusinessRuleError("Kafka client certificate and key must be set together"))
+	}
+	switch kafkaSecurity.SASLMechanism {
+	case "":
+	case SASLPlain, SASLScramSHA256, SASLScramSHA512:
+		if kafkaSecurity.Username == "" || kafkaSecurity.Password == "" {
+			panic(errors.NewBusinessRuleError("Kafka SASL needs both a username and a password"))
+		}
+	default:
+		panic(errors.NewBusinessRuleError("Kafka SASL mechanism must be one of PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512"))
+	}
+
+	counterTopic := os.Getenv(EnvCounterTopic)
+	if counterTopic == "" {
+		counterTopic = DefaultCounterTopic
+	}
+	if !ValidTopicName(counterTopic) {
+		panic(errors.NewBusinessRuleError("Counter topic may only contain letters, digits, '.', '_' and '-'"))
+	}
+
+	topicPartitions := 1 // Defaults to what Kafka auto-creates, so that existing topics don't drift
+	if value := os.Getenv(EnvTopicPartitions); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			panic(errors.NewBusinessRuleError("Topic partitions must be a positive integer"))
+		}
+		topicPartitions = parsed
+	}
+
+	topicReplication := 1 // Defaults to a single replica, which is all docker-compose has
+	if value := os.Getenv(EnvTopicReplication); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			panic(errors.NewBusinessRuleError("Topic replication factor must be a positive integer"))
+		}
+		topicReplication = parsed
+	}
+
+	topicRetention := 7 * 24 * time.Hour // Defaults to a week, as Kafka does
+	if value := os.Getenv(EnvTopicRetention); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < time.Millisecond {
+			panic(errors.NewBusinessRuleError("Topic retention must be a duration of at least one millisecond"))
+		}
+		topicRetention = parsed
+	}
+
+	topicCleanupPolicy := CleanupPolicy(strings.ToLower(os.Getenv(EnvTopicCleanupPolicy)))
+	switch topicCleanupPolicy {
+	case "":
+		topicCleanupPolicy = CleanupDelete
+	case CleanupDelete, CleanupCompact, CleanupCompactDelete:
+	default:
+		panic(errors.NewBusinessRuleError("Topic cleanup policy must be one of delete, compact or compact,delete"))
+	}
+
+	topicProvisioning := ProvisioningMode(strings.ToLower(os.Getenv(EnvTopicProvisioning)))
+	switch topicProvisioning {
+	case "":
+		topicProvisioning = ProvisioningCreate
+	case ProvisioningOff, ProvisioningCreate, ProvisioningStrict:
+	default:
+		panic(errors.NewBusinessRuleError("Topic provisioning must be one of off, create or strict"))
+	}
+
+	brokerStartup := StartupMode(strings.ToLower(os.Getenv(EnvBrokerStartup)))
+	switch brokerStartup {
+	case "":
+		brokerStartup = StartupDegraded
+	case StartupWait, StartupDegraded:
+	default:
+		panic(errors.NewBusinessRuleError("Broker startup must be either wait or degraded"))
+	}
+
+	brokerMinBackoff := 500 * time.Millisecond // Defaults to retrying the first failed dial after half a second
+	if value := os.Getenv(EnvBrokerMinBackoff); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			panic(errors.NewBusinessRuleError("Broker min backoff must be a positive duration"))
+		}
+		brokerMinBackoff = parsed
+	}
+
+	brokerMaxBackoff := 30 * time.Second // Defaults to dialing at least twice a minute while the broker is down
+	if value := os.Getenv(EnvBrokerMaxBackoff); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < brokerMinBackoff {
+			panic(errors.NewBusinessRuleError("Broker max backoff must be a duration no shorter than the min backoff"))
+		}
+		brokerMaxBackoff = parsed
+	}
+
+	brokerCheckInterval := 15 * time.Second // Defaults to checking an established connection every 15 seconds
+	if value := os.Getenv(EnvBrokerCheckInterval); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			panic(errors.NewBusinessRuleError("Broker check interval must be a positive duration"))
+		}
+		brokerCheckInterval = parsed
+	}
+
+	producerAsync := false // Defaults to writes that return once their batch is flushed
+	if value := os.Getenv(EnvProducerAsync); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			panic(errors.NewBusinessRuleError("Producer async must be a boolean"))
+		}
+		producerAsync = parsed
+	}
+
+	producerLinger := 10 * time.Millisecond // Defaults to waiting 10ms for a batch to fill up
+	if value := os.Getenv(EnvProducerLinger); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			panic(errors.NewBusinessRuleError("Producer linger must be a positive duration"))
+		}
+		producerLinger = parsed
+	}
+
+	producerBatchSize := 100 // Defaults to flushing after 100 messages
+	if value := os.Getenv(EnvProducerBatchSize); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			panic(errors.NewBusinessRuleError("Producer batch size must be a positive integer"))
+		}
+		producerBatchSize = parsed
+	}
+
+	producerBatchBytes := int64(1 << 20) // Defaults to 1MB, which is Kafka's default max message size
+	if value := os.Getenv(EnvProducerBatchBytes); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 {
+			panic(errors.NewBusinessRuleError("Producer batch bytes must be a positive integer"))
+		}
+		producerBatchBytes = parsed
+	}
+
+	producerCompression := Compression(strings.ToLower(os.Getenv(EnvProducerCompression)))
+	switch producerCompression {
+	case "":
+		producerCompression = CompressionNone
+	case CompressionNone, CompressionGzip, CompressionSnappy, CompressionLz4, CompressionZstd:
+	default:
+		panic(errors.NewBusinessRuleError("Producer compression must be one of none, gzip, snappy, lz4 or zstd"))
+	}
+
+	producerAcks := Acks(strings.ToLower(os.Getenv(EnvProducerAcks)))
+	switch producerAcks {
+	case "":
+		producerAcks = AcksLeader
+	case AcksNone, AcksLeader, AcksAll:
+	default:
+		panic(errors.NewBusinessRuleError("Producer acks must be one of none, leader or all"))
+	}
+
+	return BrokerSettings{
+		Backend: broker,
+		Topics: TopicSettings{
+			Counter:           counterTopic,
+			Partitions:        topicPartitions,
+			ReplicationFactor: topicReplication,
+			Retention:         topicRetention,
+			CleanupPolicy:     topicCleanupPolicy,
+			Provisioning:      topicProvisioning,
+		},
+		Addresses: addresses,
+		Kafka:     kafkaSecurity,
+		Connection: ConnectionSettings{
+			Startup:       brokerStartup,
+			MinBackoff:    brokerMinBackoff,
+			MaxBackoff:    brokerMaxBackoff,
+			CheckInterval: brokerCheckInterval,
+		},
+		Producer: ProducerSettings{
+			Async:       producerAsync,
+			Linger:      producerLinger,
+			BatchSize:   producerBatchSize,
+			BatchBytes:  producerBatchBytes,
+			Compression: producerCompression,
+			Acks:        producerAcks,
+		},
+		Mongo: MongoBrokerSettings{
+			Partitions: mongoBrokerPartitions,
+			Retention:  mongoBrokerRetention,
+		},
+		Nats: NatsBrokerSettings{
+			Url:       natsUrl,
+			Replicas:  natsReplicas,
+			Retention: natsRetention,
+			AckWait:   natsAckWait,
+		},
+	}
+}
@@ -0,0 +1,79 @@
+package brocker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSettingsFromEnvDefaults(t *testing.T) {
+	settings := SettingsFromEnv()
+
+	if settings.Backend != KafkaBackend {
+		t.Errorf("Backend = %v, want %v", settings.Backend, KafkaBackend)
+	}
+	if settings.Topics.Counter != DefaultCounterTopic || settings.Topics.DeadLetter != "" {
+		t.Errorf("Topics = %+v, want only the default counter topic", settings.Topics)
+	}
+	if len(settings.Addresses) != 1 || settings.Addresses[0] != "localhost" {
+		t.Errorf("Addresses = %v, want localhost", settings.Addresses)
+	}
+	if settings.Nats.AckWait != time.Minute || settings.Mongo.Partitions != 1 {
+		t.Errorf("Backend settings = %+v, %+v, want the defaults", settings.Nats, settings.Mongo)
+	}
+}
+
+func TestSettingsFromEnv(t *testing.T) {
+	t.Setenv(EnvBroker, "NATS")
+	t.Setenv(EnvKafkaAddresses, "kafka-0:9092,kafka-1:9092")
+	t.Setenv(EnvCounterTopic, "tenant-a.counter")
+	t.Setenv(EnvNatsAckWait, "2m")
+	t.Setenv(EnvProducerCompression, "zstd")
+
+	settings := SettingsFromEnv()
+	if settings.Backend != NatsBackend {
+		t.Errorf("Backend = %v, want %v", settings.Backend, NatsBackend)
+	}
+	if len(settings.Addresses) != 2 {
+		t.Errorf("Addresses = %v, want both", settings.Addresses)
+	}
+	if settings.Topics.Counter != "tenant-a.counter" {
+		t.Errorf("Counter topic = %v", settings.Topics.Counter)
+	}
+	if settings.Nats.AckWait != 2*time.Minute {
+		t.Errorf("Nats ack wait = %v, want 2m", settings.Nats.AckWait)
+	}
+	if settings.Producer.Compression != CompressionZstd {
+		t.Errorf("Producer compression = %v, want zstd", settings.Producer.Compression)
+	}
+}
+
+func TestSettingsFromEnvRejectsInvalidValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value string
+	}{
+		{"unknown backend", EnvBroker, "rabbitmq"},
+		{"memory backend", EnvBroker, "memory"},
+		{"zero mongo partitions", EnvMongoBrokerPartitions, "0"},
+		{"too many nats replicas", EnvNatsReplicas, "6"},
+		{"negative ack wait", EnvNatsAckWait, "-1s"},
+		{"certificate without key", EnvKafkaCertFile, "client.pem"},
+		{"unknown SASL mechanism", EnvKafkaSASLMechanism, "GSSAPI"},
+		{"invalid topic", EnvCounterTopic, "counter/events"},
+		{"unknown cleanup policy", EnvTopicCleanupPolicy, "forever"},
+		{"unknown acks", EnvProducerAcks, "some"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(test.key, test.value)
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v=%q was accepted", test.key, test.value)
+				}
+			}()
+			SettingsFromEnv()
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package brocker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+const DefaultCounterTopic = "counter"
+
+type CleanupPolicy string
+
+const (
+	CleanupDelete        CleanupPolicy = "delete"
+	CleanupCompact       CleanupPolicy = "compact"
+	CleanupCompactDelete CleanupPolicy = "compact,delete"
+)
+
+type ProvisioningMode string
+
+const (
+	// Topics are left to the cluster, e.g. to auto-creation or to an operator
+	ProvisioningOff ProvisioningMode = "off"
+	// Missing topics are created, drift of existing ones is only logged
+	ProvisioningCreate ProvisioningMode = "create"
+	// Missing topics are created, drift of existing ones fails startup
+	ProvisioningStrict ProvisioningMode = "strict"
+)
+
+// TopicSettings names the topics and tells how they should look on the cluster
+type TopicSettings struct {
+	Counter    string
+	DeadLetter string
+
+	Partitions        int
+	ReplicationFactor int
+	Retention         time.Duration
+	CleanupPolicy     CleanupPolicy
+	Provisioning      ProvisioningMode
+}
+
+func (settings TopicSettings) Retry(tier string) string {
+	return settings.Counter + ".retry." + tier
+}
+
+var topicName = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,249}$`)
+
+// ValidTopicName follows the rules Kafka has for topic names
+func ValidTopicName(name string) bool {
+	return topicName.MatchString(name) && name != "." && name != ".."
+}
+
+// Provisioner is implemented by admins of backends whose topics have to exist before they are used
+type Provisioner interface {
+	// Provision creates missing topics and reports how existing ones differ from the settings
+	Provision(ctx context.Context, settings TopicSettings, topics []string) (drift []string, err error)
+}
+
+// Provision makes sure the given topics exist. Backends that create topics on first use have nothing to do.
+// A service starting degraded only logs that the cluster could not be reached, unless provisioning is strict
+func Provision(ctx context.Context, admin Admin, broker BrokerSettings, logger *zap.Logger, topics ...string) error {
+	settings := broker.Topics
+	if settings.Provisioning == ProvisioningOff {
+		return nil
+	}
+	provisioner, ok := admin.(Provisioner)
+	if !ok {
+		return nil
+	}
+
+	drift, err := provisioner.Provision(ctx, settings, topics)
+	if err != nil && broker.Connection.Startup == StartupDegraded && settings.Provisioning != ProvisioningStrict {
+		logger.Warn("Could not provision topics, starting degraded", zap.Strings("topics", topics), zap.Error(err))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(drift) == 0 {
+		return nil
+	}
+	if settings.Provisioning == ProvisioningStrict {
+		return fmt.Errorf("topics drifted from configuration: %v", strings.Join(drift, "; "))
+	}
+	for _, difference := range drift {
+		logger.Warn("Topic drifted from configuration", zap.String("drift", difference))
+	}
+	return nil
+}
+
+func (admin *kafkaAdmin) Provision(ctx context.Context, settings TopicSettings, topics []string) ([]string, error) {
+	metadata, err := admin.Client.Metadata(ctx, &kafka.MetadataRequest{Topics: topics})
+	if err != nil {
+		return nil, fmt.Errorf("error happened while reading topic metadata: %w", err)
+	}
+
+	existing := map[string]kafka.Topic{}
+	for _, topic := range metadata.Topics {
+		if topic.Error == nil {
+			existing[topic.Name] = topic
+		} else if !errors.Is(topic.Error, kafka.UnknownTopicOrPartition) {
+			return nil, fmt.Errorf("error happened while reading metadata of topic %v: %w", topic.Name, topic.Error)
+		}
+	}
+
+	missing := []kafka.TopicConfig{}
+	for _, name := range topics {
+		if _, ok := existing[name]; !ok {
+			missing = append(missing, kafka.TopicConfig{
+				Topic:             name,
+				NumPartitions:     settings.Partitions,
+				ReplicationFactor: settings.ReplicationFactor,
+				ConfigEntries:     topicConfigEntries(settings),
+			})
+		}
+	}
+	if err := admin.createTopics(ctx, missing); err != nil {
+		return nil, err
+	}
+
+	drift := []string{}
+	for _, name := range topics {
+		topic, ok := existing[name]
+		if !ok {
+			continue
+		}
+		if len(topic.Partitions) != settings.Partitions {
+			drift = append(drift, fmt.Sprintf("%v has %v partitions instead of %v", name, len(topic.Partitions), settings.Partitions))
+		}
+		if len(topic.Partitions) > 0 && len(topic.Partitions[0].Replicas) != settings.ReplicationFactor {
+			drift = append(drift, fmt.Sprintf("%v has replication factor %v instead of %v", name, len(topic.Partitions[0].Replicas), settings.ReplicationFactor))
+		}
+	}
+
+	configDrift, err := admin.configDrift(ctx, settings, existing)
+	if err != nil {
+		return nil, err
+	}
+	return append(drift, configDrift...), nil
+}
+
+func (admin *kafkaAdmin) createTopics(ctx context.Context, topics []kafka.TopicConfig) error {
+	if len(topics) == 0 {
+		return nil
+	}
+
+	response, err := admin.Client.CreateTopics(ctx, &kafka.CreateTopicsRequest{Topics: topics})
+	if err != nil {
+		return fmt.Errorf("error happened while creating topics: %w", err)
+	}
+	for _, topic := range topics {
+		err := response.Errors[topic.Topic]
+		// Another replica may have created it in the meantime
+		if err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+			return fmt.Errorf("error happened while creating topic %v: %w", topic.Topic, err)
+		}
+		admin.Logger.Info(
+			"Created topic",
+			zap.String("topic", topic.Topic),
+			zap.Int("partitions", topic.NumPartitions),
+			zap.Int("replicationFactor", topic.ReplicationFactor),
+		)
+	}
+	return nil
+}
+
+func (admin *kafkaAdmin) configDrift(ctx context.Context, settings TopicSettings, topics map[string]kafka.Topic) ([]string, error) {
+	if len(topics) == 0 {
+		return nil, nil
+	}
+
+	expected := map[string]string{}
+	resources := []kafka.DescribeConfigRequestResource{}
+	for _, entry := range topicConfigEntries(settings) {
+		expected[entry.ConfigName] = entry.ConfigValue
+	}
+	for name := range topics {
+		resources = append(resources, kafka.DescribeConfigRequestResource{
+			ResourceType: kafka.ResourceTypeTopic,
+			ResourceName: name,
+			ConfigNames:  []string{"retention.ms", "cleanup.policy"},
+		})
+	}
+
+	response, err := admin.Client.DescribeConfigs(ctx, &kafka.DescribeConfigsRequest{Resources: resources})
+	if err != nil {
+		return nil, fmt.Errorf("error happened while describing topic configs: %w", err)
+	}
+
+	drift := []string{}
+	for _, resource := range response.Resources {
+		if resource.Error != nil {
+			return nil, fmt.Errorf("error happened while describing config of topic %v: %w", resource.ResourceName, resource.Error)
+		}
+		for _, entry := range resource.ConfigEntries {
+			if want, ok := expected[entry.ConfigName]; ok && entry.ConfigValue != want {
+				drift = append(drift, fmt.Sprintf("%v has %v=%v instead of %v", resource.ResourceName, entry.ConfigName, entry.ConfigValue, want))
+			}
+		}
+	}
+	return drift, nil
+}
+
+func topicConfigEntries(settings TopicSettings) []kafka.ConfigEntry {
+	return []kafka.ConfigEntry{
+		{ConfigName: "retention.ms", ConfigValue: strconv.FormatInt(settings.Retention.Milliseconds(), 10)},
+		{ConfigName: "cleanup.policy", ConfigValue: string(settings.CleanupPolicy)},
+	}
+}
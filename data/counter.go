@@ -1,6 +1,7 @@
 package data
 
 import (
+	"slices"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -13,15 +14,22 @@ type CounterDocument struct {
 	CreatedAt time.Time `bson:"createdAt"`
 	UpdatedAt time.Time `bson:"updatedAt"`
 	UpdatedBy string    `bson:"updatedBy,omitempty"`
+	// Counters created before ownership was recorded have no owner and can be accessed by no one until they are claimed
+	Owner  string        `bson:"owner,omitempty"`
+	Grants []AccessGrant `bson:"grants,omitempty"`
+	Tenant string        `bson:"tenant,omitempty"`
 }
 
-func NewCounterDocument(now time.Time) *CounterDocument {
+func NewCounterDocument(owner string, tenant string, now time.Time) *CounterDocument {
 	return &CounterDocument{
 		Id:        primitive.NewObjectID(),
 		Counter:   0,
 		Version:   0,
 		CreatedAt: now,
 		UpdatedAt: now,
+		Owner:     owner,
+		Grants:    []AccessGrant{},
+		Tenant:    tenant,
 	}
 }
 
@@ -33,6 +41,9 @@ func (document *CounterDocument) Copy() *CounterDocument {
 		CreatedAt: document.CreatedAt,
 		UpdatedAt: document.UpdatedAt,
 		UpdatedBy: document.UpdatedBy,
+		Owner:     document.Owner,
+		Grants:    slices.Clone(document.Grants),
+		Tenant:    document.Tenant,
 	}
 }
 
@@ -51,6 +62,7 @@ func (document *CounterDocument) DecreaseCounter(updatedBy string) {
 type CounterResponse struct {
 	Id        primitive.ObjectID `example:"60c7c02ea38e3c3c4426c1bd"`
 	Counter   int                `example:"5"`
+	Owner     string             `example:"auth0|5f8d0d55b54764421b7156c9"`
 	CreatedAt time.Time          `example:"2022-02-30T12:00:00Z"`
 	UpdatedAt time.Time          `example:"2022-02-30T12:00:00Z"`
 }
@@ -59,6 +71,7 @@ func (document *CounterDocument) MapToResponseModel() *CounterResponse {
 	return &CounterResponse{
 		Id:        document.Id,
 		Counter:   document.Counter,
+		Owner:     document.Owner,
 		CreatedAt: document.CreatedAt,
 		UpdatedAt: document.UpdatedAt,
 	}
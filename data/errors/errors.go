@@ -2,23 +2,39 @@ package errors
 
 import "fmt"
 
+// HTTPError is the RFC 7807 problem details object every error response carries as application/problem+json
 type HTTPError struct {
-	Code    int    `json:"code" example:"400"`
-	Message string `json:"message" example:"status bad request"`
+	Type      string `json:"type" example:"urn:gokube:problem:not-found"`
+	Title     string `json:"title" example:"Not found"`
+	Status    int    `json:"status" example:"404"`
+	Detail    string `json:"detail,omitempty" example:"Document {Counter} with id: {60c7c02ea38e3c3c4426c1bd}"`
+	Instance  string `json:"instance" example:"/api/counter/60c7c02ea38e3c3c4426c1bd"`
+	RequestId string `json:"requestId" example:"3f2a9c1e5b7d4f60a8e2c4b6d8f0a1c3"`
+	// Only requests that failed validation list their invalid fields
+	InvalidParams []InvalidParam `json:"invalidParams,omitempty"`
+}
+
+type InvalidParam struct {
+	Name   string `json:"name" example:"Principal"`
+	Reason string `json:"reason" example:"required"`
 }
 
 type domainError struct {
 	Message string
-	Error   error
+	Details string
 }
 
 func newDomainError(message, details string) *domainError {
 	return &domainError{
 		Message: message,
-		Error:   fmt.Errorf(details),
+		Details: details,
 	}
 }
 
+func (err *domainError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Message, err.Details)
+}
+
 // OptimisticLockError is a custom error type for optimistic locking errors
 type OptimisticLockError struct {
 	*domainError
@@ -1,6 +1,8 @@
 package events
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/steadfastie/gokube/data"
@@ -21,6 +23,15 @@ type CounterEvent struct {
 	Who       string             `bson:"who" json:"who"`
 	What      data.EventType     `bson:"what" json:"what"`
 	Trail     []Trail            `bson:"trail" json:"trail"`
+	Tenant    string             `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	// Set on access events only
+	Access *AccessChange `bson:"access,omitempty" json:"access,omitempty"`
+}
+
+type AccessChange struct {
+	Kind      data.PrincipalKind `bson:"kind" json:"kind"`
+	Principal string             `bson:"principal" json:"principal"`
+	Access    data.AccessLevel   `bson:"access,omitempty" json:"access,omitempty"`
 }
 
 type Trail struct {
@@ -34,3 +45,39 @@ func (event *CounterEvent) AddTrail(service ServiceName, timestamp time.Time) {
 		Timestamp: timestamp,
 	})
 }
+
+var (
+	ErrInvalidTenant  = errors.New("event names an invalid tenant")
+	ErrTenantMismatch = errors.New("message header names another tenant than its event")
+)
+
+// CheckTenant makes sure the tenant of the event is valid, and that the header of its message, if any, agrees.
+// The payload is what the API wrote, so a header can't move an event into another tenant
+func (event *CounterEvent) CheckTenant(header string) error {
+	tenant := event.TenantOrDefault()
+	if !data.ValidTenant(tenant) {
+		return fmt.Errorf("%w: %q", ErrInvalidTenant, tenant)
+	}
+	if header != "" && header != tenant {
+		return fmt.Errorf("%w: %q in the header, %q in the event", ErrTenantMismatch, header, tenant)
+	}
+	return nil
+}
+
+// TenantOrDefault treats events raised before tenants existed as the default tenant's
+func (event *CounterEvent) TenantOrDefault() string {
+	if event.Tenant == "" {
+		return data.DefaultTenant
+	}
+	return event.Tenant
+}
+
+// OccurredAt is the moment the event was raised by the API
+func (event *CounterEvent) OccurredAt() time.Time {
+	for _, trail := range event.Trail {
+		if trail.Service == Api {
+			return trail.Timestamp
+		}
+	}
+	return time.Now().UTC()
+}
@@ -0,0 +1,19 @@
+package data
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// InboxKey identifies an event processed by a single handler
+type InboxKey struct {
+	EventId primitive.ObjectID `bson:"eventId"`
+	Handler string             `bson:"handler"`
+}
+
+// InboxDocument marks an event as processed by a handler. Entries expire after the inbox TTL
+type InboxDocument struct {
+	Id          InboxKey  `bson:"_id"`
+	ProcessedAt time.Time `bson:"processedAt"`
+}
@@ -14,6 +14,8 @@ type EventType string
 const (
 	CounterCreated EventType = "Created"
 	CounterUpdated EventType = "Updated"
+	AccessGranted  EventType = "AccessGranted"
+	AccessRevoked  EventType = "AccessRevoked"
 )
 
 type CounterCreatedEvent struct {
@@ -54,6 +56,7 @@ type OutboxEvent struct {
 	EventId   primitive.ObjectID `bson:"_id"`
 	Payload   any                `bson:"payload"`
 	Timestamp time.Time          `bson:"timestamp"`
+	Tenant    string             `bson:"tenant,omitempty"`
 }
 
 func (event *OutboxEvent) UnmarshalBSON(data []byte) error {
@@ -71,6 +74,9 @@ func (event *OutboxEvent) UnmarshalBSON(data []byte) error {
 	}
 	event.Timestamp = timestamp
 
+	// Events raised before tenants existed have none
+	event.Tenant, _ = raw.Lookup("tenant").StringValueOK()
+
 	payload := raw.Lookup("payload").Document()
 	payloadType, ok := payload.Lookup("type").StringValueOK()
 	if !ok {
@@ -92,6 +98,16 @@ func (event *OutboxEvent) UnmarshalBSON(data []byte) error {
 			UpdatedBy: payload.Lookup("updatedBy").StringValue(),
 			UserAlias: payload.Lookup("userAlias").StringValue(),
 		}
+	case string(AccessGranted), string(AccessRevoked):
+		access, _ := payload.Lookup("access").StringValueOK()
+		event.Payload = &CounterAccessEvent{
+			Type:      EventType(payloadType),
+			CounterId: payload.Lookup("counterId").ObjectID(),
+			Kind:      PrincipalKind(payload.Lookup("kind").StringValue()),
+			Principal: payload.Lookup("principal").StringValue(),
+			Access:    AccessLevel(access),
+			UserAlias: payload.Lookup("userAlias").StringValue(),
+		}
 	default:
 		return fmt.Errorf("unknown event type %q", payloadType)
 	}
@@ -105,11 +121,12 @@ func (a ByTimestamp) Len() int           { return len(a) }
 func (a ByTimestamp) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByTimestamp) Less(i, j int) bool { return a[i].Timestamp.Before(a[j].Timestamp) }
 
-func NewOutboxEvent(event any, now time.Time) *OutboxEvent {
+func NewOutboxEvent(event any, tenant string, now time.Time) *OutboxEvent {
 	return &OutboxEvent{
 		EventId:   primitive.NewObjectID(),
 		Payload:   event,
 		Timestamp: now,
+		Tenant:    tenant,
 	}
 }
 
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimit lets a client make Requests requests per Period. Buckets hold as many tokens,
+// so a client that has been idle for a Period can spend all of them at once
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Unlimited marks routes that are not rate limited
+var Unlimited = RateLimit{}
+
+const unlimitedText = "off"
+
+// ParseRateLimit reads limits written as <requests>/<period>, e.g. 60/1m, or off
+func ParseRateLimit(value string) (RateLimit, error) {
+	if value == unlimitedText {
+		return Unlimited, nil
+	}
+
+	requests, period, found := strings.Cut(value, "/")
+	if !found {
+		return Unlimited, fmt.Errorf("rate limit %q must look like <requests>/<period>, e.g. 60/1m", value)
+	}
+	limit := RateLimit{}
+	var err error
+	if limit.Requests, err = strconv.Atoi(requests); err != nil || limit.Requests < 1 {
+		return Unlimited, fmt.Errorf("rate limit %q must allow at least one request", value)
+	}
+	if limit.Period, err = time.ParseDuration(period); err != nil || limit.Period < time.Second {
+		return Unlimited, fmt.Errorf("rate limit %q must have a period of at least one second", value)
+	}
+	return limit, nil
+}
+
+func (limit RateLimit) String() string {
+	if limit.IsUnlimited() {
+		return unlimitedText
+	}
+	return fmt.Sprintf("%d/%v", limit.Requests, limit.Period)
+}
+
+func (limit RateLimit) IsUnlimited() bool {
+	return limit == Unlimited
+}
+
+func (limit *RateLimit) UnmarshalText(text []byte) error {
+	parsed, err := ParseRateLimit(string(text))
+	if err != nil {
+		return err
+	}
+	*limit = parsed
+	return nil
+}
+
+func (limit RateLimit) MarshalText() ([]byte, error) {
+	return []byte(limit.String()), nil
+}
+
+// Tokens earned per nanosecond
+func (limit RateLimit) rate() float64 {
+	return float64(limit.Requests) / float64(limit.Period)
+}
+
+// RateLimitBucket is the token bucket of a client on a route
+type RateLimitBucket struct {
+	Key       string    `bson:"_id"`
+	Tokens    float64   `bson:"tokens"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+	// Whether the last request got a token
+	Allowed bool `bson:"allowed"`
+	// The bucket is full again by then, which is as good as not having one
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// Take refills the bucket for the time passed since its last request and spends a token if there is one.
+// A nil bucket starts full
+func (limit RateLimit) Take(key string, bucket *RateLimitBucket, now time.Time) *RateLimitBucket {
+	tokens := float64(limit.Requests)
+	if bucket != nil {
+		elapsed := max(now.Sub(bucket.UpdatedAt), 0)
+		tokens = math.Min(tokens, bucket.Tokens+float64(elapsed)*limit.rate())
+	}
+
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	return &RateLimitBucket{
+		Key:       key,
+		Tokens:    tokens,
+		UpdatedAt: now,
+		Allowed:   allowed,
+		ExpiresAt: now.Add(limit.Period),
+	}
+}
+
+type RateLimitDecision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Time until the bucket is full again
+	Reset time.Duration
+	// Time until the next token, zero for allowed requests
+	RetryAfter time.Duration
+}
+
+func (limit RateLimit) Decide(bucket *RateLimitBucket) *RateLimitDecision {
+	decision := &RateLimitDecision{
+		Allowed:   bucket.Allowed,
+		Limit:     limit.Requests,
+		Remaining: int(math.Floor(bucket.Tokens)),
+		Reset:     time.Duration((float64(limit.Requests) - bucket.Tokens) / limit.rate()),
+	}
+	if !bucket.Allowed {
+		decision.RetryAfter = time.Duration((1 - bucket.Tokens) / limit.rate())
+	}
+	return decision
+}
@@ -0,0 +1,71 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		limit RateLimit
+		ok    bool
+	}{
+		{"60/1m", RateLimit{Requests: 60, Period: time.Minute}, true},
+		{"1/1s", RateLimit{Requests: 1, Period: time.Second}, true},
+		{"off", Unlimited, true},
+		{"60", Unlimited, false},
+		{"0/1m", Unlimited, false},
+		{"60/500ms", Unlimited, false},
+		{"x/1m", Unlimited, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			limit, err := ParseRateLimit(test.value)
+			if (err == nil) != test.ok || limit != test.limit {
+				t.Errorf("ParseRateLimit(%q) = %v, %v, want %v", test.value, limit, err, test.limit)
+			}
+		})
+	}
+}
+
+func TestTakeAndDecide(t *testing.T) {
+	limit := RateLimit{Requests: 2, Period: 10 * time.Second}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		name       string
+		elapsed    time.Duration
+		allowed    bool
+		remaining  int
+		reset      time.Duration
+		retryAfter time.Duration
+	}{
+		{"full bucket", 0, true, 1, 5 * time.Second, 0},
+		{"last token", 0, true, 0, 10 * time.Second, 0},
+		{"empty bucket", 0, false, 0, 10 * time.Second, 5 * time.Second},
+		{"partially refilled", 2 * time.Second, false, 0, 8 * time.Second, 3 * time.Second},
+		{"refilled token", 3 * time.Second, true, 0, 10 * time.Second, 0},
+		{"idle for a period", time.Minute, true, 1, 5 * time.Second, 0},
+	}
+
+	var bucket *RateLimitBucket
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			now = now.Add(step.elapsed)
+			bucket = limit.Take("key", bucket, now)
+			decision := limit.Decide(bucket)
+
+			if decision.Allowed != step.allowed || decision.Limit != 2 || decision.Remaining != step.remaining {
+				t.Errorf("decision = %+v, want allowed %v with %d remaining", decision, step.allowed, step.remaining)
+			}
+			if decision.Reset != step.reset || decision.RetryAfter != step.retryAfter {
+				t.Errorf("reset = %v, retry after = %v, want %v, %v", decision.Reset, decision.RetryAfter, step.reset, step.retryAfter)
+			}
+			if !bucket.ExpiresAt.Equal(now.Add(limit.Period)) {
+				t.Errorf("bucket expires at %v, want a period after the request", bucket.ExpiresAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/steadfastie/gokube/data"
+	"github.com/steadfastie/gokube/data/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const apiKeysCollection = "api_keys"
+
+// How stale the last-used timestamp of a key may get, so that busy keys don't cost a write per request
+const lastUsedPrecision = time.Minute
+
+type ApiKeyRepository interface {
+	Create(ctx context.Context, key *data.ApiKeyDocument, resultChan chan<- *data.ApiKeyDocument, errChan chan<- error)
+	// List sends the keys of the tenant of the request
+	List(ctx context.Context, resultChan chan<- []data.ApiKeyDocument, errChan chan<- error)
+	// Revoke sends nil when the tenant has no such key or it has been revoked already
+	Revoke(ctx context.Context, id string, resultChan chan<- *data.ApiKeyDocument, errChan chan<- error)
+
+	// Authenticate returns the active key with the hash, or nil, and records that it has been used
+	Authenticate(ctx context.Context, hash string) (*data.ApiKeyDocument, error)
+}
+
+type apiKeyRepository struct {
+	Collection *mongo.Collection
+	Logger     *zap.Logger
+}
+
+// NewApiKeyRepository makes sure hashes are unique, which also makes authentication an index lookup
+func NewApiKeyRepository(ctx context.Context, mongodb *services.MongoDB, logger *zap.Logger) (ApiKeyRepository, error) {
+	collection := mongodb.MongoDB.Collection(apiKeysCollection)
+
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := collection.Indexes().CreateOne(ctx, index); err != nil {
+		return nil, fmt.Errorf("error happened while creating API key hash index: %w", err)
+	}
+
+	return &apiKeyRepository{
+		Collection: collection,
+		Logger:     logger,
+	}, nil
+}
+
+func (repo *apiKeyRepository) Create(ctx context.Context, key *data.ApiKeyDocument, resultChan chan<- *data.ApiKeyDocument, errChan chan<- error) {
+	if _, err := repo.Collection.InsertOne(ctx, key); err != nil {
+		repo.Logger.Error("Could not create API key", zap.String("name", key.Name), zap.Error(err))
+		errChan <- err
+		return
+	}
+	resultChan <- key
+}
+
+func (repo *apiKeyRepository) List(ctx context.Context, resultChan chan<- []data.ApiKeyDocument, errChan chan<- error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetProjection(bson.D{{Key: "hash", Value: 0}})
+
+	cursor, err := repo.Collection.Find(ctx, bson.D{tenantFilter(ctx, "tenant")}, opts)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	results := []data.ApiKeyDocument{}
+	if err := cursor.All(ctx, &results); err != nil {
+		errChan <- err
+		return
+	}
+	resultChan <- results
+}
+
+func (repo *apiKeyRepository) Revoke(ctx context.Context, id string, resultChan chan<- *data.ApiKeyDocument, errChan chan<- error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	filter := bson.D{{Key: "_id", Value: objectID}, {Key: "revokedAt", Value: bson.M{"$exists": false}}, tenantFilter(ctx, "tenant")}
+	update := bson.M{"$set": bson.M{"revokedAt": time.Now().UTC()}}
+	opts := options.FindOneAndUpdate().SetProjection(bson.D{{Key: "hash", Value: 0}}).SetReturnDocument(options.After)
+
+	var result data.ApiKeyDocument
+	if err := repo.Collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&result); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			resultChan <- nil
+			return
+		}
+		errChan <- err
+		return
+	}
+	resultChan <- &result
+}
+
+func (repo *apiKeyRepository) Authenticate(ctx context.Context, hash string) (*data.ApiKeyDocument, error) {
+	var key data.ApiKeyDocument
+	if err := repo.Collection.FindOne(ctx, bson.M{"hash": hash}).Decode(&key); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error happened while looking up API key: %w", err)
+	}
+
+	now := time.Now().UTC()
+	if !key.Active(now) {
+		return nil, nil
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= lastUsedPrecision {
+		filter := bson.M{"_id": key.Id, "$or": bson.A{
+			bson.M{"lastUsedAt": bson.M{"$exists": false}},
+			bson.M{"lastUsedAt": bson.M{"$lte": now.Add(-lastUsedPrecision)}},
+		}}
+		if _, err := repo.Collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"lastUsedAt": now}}); err != nil {
+			// A missed timestamp shouldn't fail the request
+			repo.Logger.Warn("Could not record API key usage", zap.String("id", key.Id.Hex()), zap.Error(err))
+		}
+		key.LastUsedAt = &now
+	}
+	return &key, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/steadfastie/gokube/data"
@@ -18,10 +19,21 @@ import (
 
 const collection = "counter"
 
+var (
+	ErrOwnerGrant = errors.New("the owner already has admin access")
+	ErrAccessBusy = errors.New("access to the counter keeps changing concurrently")
+)
+
 type CounterRepository interface {
 	GetById(ctx context.Context, id string, resultChan chan<- *data.CounterDocument, errChan chan<- error)
 	Create(ctx context.Context, resultChan chan<- primitive.ObjectID, errChan chan<- error)
 	Patch(ctx context.Context, id string, patch *data.PatchModel, resultChan chan<- *data.PatchCounterResponse, errChan chan<- error)
+	// CanAccess reports false for counters that don't exist as well
+	CanAccess(ctx context.Context, id primitive.ObjectID, access data.AccessLevel, resultChan chan<- bool, errChan chan<- error)
+	// Access methods send nil when the counter doesn't exist, the caller is not its admin or there is no grant to revoke
+	GetAccess(ctx context.Context, id string, resultChan chan<- *data.CounterAccessResponse, errChan chan<- error)
+	Grant(ctx context.Context, id string, request *data.GrantAccessRequest, resultChan chan<- *data.CounterAccessResponse, errChan chan<- error)
+	Revoke(ctx context.Context, id string, kind data.PrincipalKind, principal string, resultChan chan<- *data.CounterAccessResponse, errChan chan<- error)
 }
 
 type counterRepository struct {
@@ -36,6 +48,30 @@ func NewCounterRepository(mongodb *services.MongoDB, logger *zap.Logger) Counter
 	}
 }
 
+// ClaimOwnerlessCounters makes the owner the owner of every counter created before ownership was recorded
+func ClaimOwnerlessCounters(ctx context.Context, mongodb *services.MongoDB, owner string, logger *zap.Logger) error {
+	filter := bson.M{"document.owner": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"document.owner": owner}}
+
+	result, err := mongodb.MongoDB.Collection(collection).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("error happened while claiming ownerless counters: %w", err)
+	}
+	if result.ModifiedCount > 0 {
+		logger.Info("Claimed ownerless counters", zap.String("owner", owner), zap.Int64("counters", result.ModifiedCount))
+	}
+	return nil
+}
+
+// CountOwnerlessCounters counts the counters created before ownership was recorded, which no one can access
+func CountOwnerlessCounters(ctx context.Context, mongodb *services.MongoDB) (int64, error) {
+	count, err := mongodb.MongoDB.Collection(collection).CountDocuments(ctx, bson.M{"document.owner": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, fmt.Errorf("error happened while counting ownerless counters: %w", err)
+	}
+	return count, nil
+}
+
 func (repo *counterRepository) GetById(ctx context.Context, id string, resultChan chan<- *data.CounterDocument, errChan chan<- error) {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -46,31 +82,34 @@ func (repo *counterRepository) GetById(ctx context.Context, id string, resultCha
 	var result struct {
 		Document data.CounterDocument `bson:"document"`
 	}
-	filter := bson.M{"_id": objectID}
+	filter := bson.D{{Key: "_id", Value: objectID}, tenantFilter(ctx, "document.tenant"), accessFilter(ctx, data.ReadAccess)}
 	opts := options.FindOne().SetProjection(bson.D{{Key: "document", Value: 1}})
 
 	if err := repo.Collection.FindOne(ctx, filter, opts).Decode(&result); err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			panic(domainErrors.NewNotFoundError("Counter", id))
+			errChan <- domainErrors.NewNotFoundError("Counter", id)
+			return
 		}
 		errChan <- err
+		return
 	}
 	resultChan <- &result.Document
 }
 
 func (repo *counterRepository) Create(ctx context.Context, resultChan chan<- primitive.ObjectID, errChan chan<- error) {
 	now := time.Now().UTC()
-	counterDocument := data.NewCounterDocument(now)
+	counterDocument := data.NewCounterDocument(ctx.Value("user").(string), data.TenantOrDefault(ctx), now)
 	document := data.NewDocument(counterDocument, counterDocument.Id)
 	event := data.NewCounterCreatedEvent(counterDocument.Id, ctx.Value("user").(string))
-	outbox := data.NewOutboxEvent(event, now)
+	outbox := data.NewOutboxEvent(event, data.TenantOrDefault(ctx), now)
 
 	document.Outbox.AddEvent(outbox)
 
 	result, err := repo.Collection.InsertOne(ctx, document)
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
-			panic(domainErrors.NewOptimisticLockError(fmt.Sprintf("Document with id - {%v} - has already been modified", counterDocument.Id.Hex())))
+			errChan <- domainErrors.NewOptimisticLockError(fmt.Sprintf("Document with id - {%v} - has already been modified", counterDocument.Id.Hex()))
+			return
 		}
 		repo.Logger.Error("Could not create document", zap.Any("Document", counterDocument), zap.Error(err))
 		errChan <- err
@@ -98,7 +137,8 @@ func (repo *counterRepository) Patch(ctx context.Context, id string, patch *data
 
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			panic(domainErrors.NewOptimisticLockError(fmt.Sprintf("Could not update document %v due to high service load", id)))
+			errChan <- domainErrors.NewOptimisticLockError(fmt.Sprintf("Could not update document %v due to high service load", id))
+			return
 		}
 		errChan <- err
 	}
@@ -109,12 +149,12 @@ func (repo *counterRepository) findOneAndUpdate(ctx context.Context, id primitiv
 		Document data.CounterDocument `bson:"document"`
 	}
 
-	filter := bson.M{"_id": id}
+	filter := bson.D{{Key: "_id", Value: id}, tenantFilter(ctx, "document.tenant"), accessFilter(ctx, data.WriteAccess)}
 	opts := options.FindOne().SetProjection(bson.D{{Key: "document", Value: 1}})
 
 	if err := repo.Collection.FindOne(ctx, filter, opts).Decode(&counterBefore); err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			panic(domainErrors.NewNotFoundError("Counter", id.Hex()))
+			return domainErrors.NewNotFoundError("Counter", id.Hex())
 		}
 		return err
 	}
@@ -132,9 +172,10 @@ func (repo *counterRepository) findOneAndUpdate(ctx context.Context, id primitiv
 
 	now := time.Now().UTC()
 	event := data.NewCounterUpdatedEvent(counterUpdate.Id, counterUpdate.Counter, counterUpdate.UpdatedBy, ctx.Value("user").(string))
-	outbox := data.NewOutboxEvent(event, now)
+	outbox := data.NewOutboxEvent(event, data.TenantOrDefault(ctx), now)
 
-	updateFilter := bson.D{{Key: "_id", Value: counterUpdate.Id}, {Key: "document.version", Value: counterBefore.Document.Version}}
+	// Access revoked in the meantime makes the update miss and the retry report not found
+	updateFilter := bson.D{{Key: "_id", Value: counterUpdate.Id}, {Key: "document.version", Value: counterBefore.Document.Version}, tenantFilter(ctx, "document.tenant"), accessFilter(ctx, data.WriteAccess)}
 	update := bson.D{
 		{Key: "$set", Value: bson.D{{Key: "document.counter", Value: counterUpdate.Counter}}},
 		{Key: "$set", Value: bson.D{{Key: "document.updatedAt", Value: now}}},
@@ -150,3 +191,167 @@ func (repo *counterRepository) findOneAndUpdate(ctx context.Context, id primitiv
 	resultChan <- data.CreatePatchCounterResponse(&counterBefore.Document, &counterAfter.Document)
 	return nil
 }
+
+func (repo *counterRepository) CanAccess(ctx context.Context, id primitive.ObjectID, access data.AccessLevel, resultChan chan<- bool, errChan chan<- error) {
+	filter := bson.D{{Key: "_id", Value: id}, tenantFilter(ctx, "document.tenant"), accessFilter(ctx, access)}
+	count, err := repo.Collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		errChan <- err
+		return
+	}
+	resultChan <- count > 0
+}
+
+func (repo *counterRepository) GetAccess(ctx context.Context, id string, resultChan chan<- *data.CounterAccessResponse, errChan chan<- error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	document, err := repo.findAdministered(ctx, objectID)
+	if err != nil {
+		errChan <- err
+		return
+	}
+	if document == nil {
+		resultChan <- nil
+		return
+	}
+	resultChan <- document.MapToAccessResponseModel()
+}
+
+func (repo *counterRepository) Grant(ctx context.Context, id string, request *data.GrantAccessRequest, resultChan chan<- *data.CounterAccessResponse, errChan chan<- error) {
+	grant := data.AccessGrant{
+		Kind:      request.Kind,
+		Principal: request.Principal,
+		Access:    request.Access,
+		GrantedBy: ctx.Value("user").(string),
+		GrantedAt: time.Now().UTC(),
+	}
+
+	repo.changeAccess(ctx, id, resultChan, errChan, func(document *data.CounterDocument) (any, error) {
+		if grant.Kind == data.UserPrincipal && grant.Principal == document.Owner {
+			return nil, ErrOwnerGrant
+		}
+
+		document.Grants = slices.DeleteFunc(document.Grants, func(existing data.AccessGrant) bool {
+			return existing.Matches(grant.Kind, grant.Principal)
+		})
+		document.Grants = append(document.Grants, grant)
+		return data.NewAccessGrantedEvent(document.Id, &grant), nil
+	})
+}
+
+func (repo *counterRepository) Revoke(ctx context.Context, id string, kind data.PrincipalKind, principal string, resultChan chan<- *data.CounterAccessResponse, errChan chan<- error) {
+	repo.changeAccess(ctx, id, resultChan, errChan, func(document *data.CounterDocument) (any, error) {
+		granted := len(document.Grants)
+		document.Grants = slices.DeleteFunc(document.Grants, func(existing data.AccessGrant) bool {
+			return existing.Matches(kind, principal)
+		})
+		if len(document.Grants) == granted {
+			return nil, nil
+		}
+		return data.NewAccessRevokedEvent(document.Id, kind, principal, ctx.Value("user").(string)), nil
+	})
+}
+
+// changeAccess applies the change to the grants and records the event it returns in the outbox.
+// A change without an event leaves the counter as it is
+func (repo *counterRepository) changeAccess(ctx context.Context, id string, resultChan chan<- *data.CounterAccessResponse, errChan chan<- error, change func(*data.CounterDocument) (any, error)) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	retryConfig := &data.RetryConfig{
+		Context:           ctx,
+		Logger:            repo.Logger,
+		RecoverableErrors: []error{mongo.ErrNoDocuments},
+	}
+
+	var response *data.CounterAccessResponse
+	err = data.WithRetry(retryConfig, func() error {
+		document, err := repo.findAdministered(ctx, objectID)
+		if err != nil || document == nil {
+			response = nil
+			return err
+		}
+
+		version := document.Version
+		event, err := change(document)
+		if err != nil || event == nil {
+			response = nil
+			return err
+		}
+
+		now := time.Now().UTC()
+		filter := bson.D{{Key: "_id", Value: objectID}, {Key: "document.version", Value: version}, tenantFilter(ctx, "document.tenant"), accessFilter(ctx, data.AdminAccess)}
+		update := bson.D{
+			{Key: "$set", Value: bson.D{{Key: "document.grants", Value: document.Grants}}},
+			{Key: "$inc", Value: bson.D{{Key: "document.version", Value: 1}}},
+			{Key: "$addToSet", Value: bson.D{{Key: "outbox.events", Value: data.NewOutboxEvent(event, data.TenantOrDefault(ctx), now)}}},
+		}
+
+		result, err := repo.Collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		response = document.MapToAccessResponseModel()
+		return nil
+	})
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			err = fmt.Errorf("could not change access to counter %v: %w", id, ErrAccessBusy)
+		}
+		errChan <- err
+		return
+	}
+	resultChan <- response
+}
+
+// findAdministered returns nil when the counter doesn't exist or the caller can't administer it
+func (repo *counterRepository) findAdministered(ctx context.Context, id primitive.ObjectID) (*data.CounterDocument, error) {
+	var result struct {
+		Document data.CounterDocument `bson:"document"`
+	}
+	filter := bson.D{{Key: "_id", Value: id}, tenantFilter(ctx, "document.tenant"), accessFilter(ctx, data.AdminAccess)}
+	opts := options.FindOne().SetProjection(bson.D{{Key: "document", Value: 1}})
+
+	if err := repo.Collection.FindOne(ctx, filter, opts).Decode(&result); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &result.Document, nil
+}
+
+// accessFilter matches counters the caller owns or was granted at least the access level on,
+// directly or through one of the groups of the token. Counters without an owner match no one
+func accessFilter(ctx context.Context, access data.AccessLevel) bson.E {
+	subject, _ := ctx.Value("user").(string)
+	groups, _ := ctx.Value("groups").([]string)
+	if groups == nil {
+		groups = []string{}
+	}
+
+	return bson.E{Key: "$or", Value: bson.A{
+		bson.M{"document.owner": subject},
+		bson.M{"document.grants": bson.M{"$elemMatch": bson.M{
+			"kind":      data.UserPrincipal,
+			"principal": subject,
+			"access":    bson.M{"$in": access.AtLeast()},
+		}}},
+		bson.M{"document.grants": bson.M{"$elemMatch": bson.M{
+			"kind":      data.GroupPrincipal,
+			"principal": bson.M{"$in": groups},
+			"access":    bson.M{"$in": access.AtLeast()},
+		}}},
+	}}
+}
@@ -0,0 +1,38 @@
+package repositories
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/steadfastie/gokube/data"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Counters created before ownership was recorded stay invisible until they are claimed
+func TestAccessFilterOwnerlessCounters(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "user", "auth0|alice")
+	ownerless := bson.M{"document.owner": bson.M{"$exists": false}}
+
+	tests := []struct {
+		access data.AccessLevel
+		match  bool
+	}{
+		{data.ReadAccess, false},
+		{data.WriteAccess, false},
+		{data.AdminAccess, false},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.access), func(t *testing.T) {
+			filter := accessFilter(ctx, test.access)
+			matches := slices.ContainsFunc(filter.Value.(bson.A), func(branch any) bool {
+				return reflect.DeepEqual(branch, ownerless)
+			})
+			if matches != test.match {
+				t.Errorf("accessFilter(%v) matches ownerless counters = %v, want %v", test.access, matches, test.match)
+			}
+		})
+	}
+}
@@ -2,18 +2,27 @@ package repositories
 
 import (
 	"context"
+	"fmt"
+	"slices"
 	"time"
 
+	"github.com/steadfastie/gokube/data"
 	"github.com/steadfastie/gokube/data/events"
 	"github.com/steadfastie/gokube/data/services"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
 )
 
-const eventsCollection = "events"
+// EventsCollection is the live archive consumers write to
+const EventsCollection = "events"
 
 type EventsRepository interface {
-	SaveEvent(ctx context.Context, event *events.CounterEvent)
+	// SaveEvent archives the event. An event that has already been archived counts as saved
+	SaveEvent(ctx context.Context, event *events.CounterEvent) error
+	// ForEach walks archived events in the order they were raised. Outside of a request it walks the events of every tenant
+	ForEach(ctx context.Context, handle func(event *events.CounterEvent) error) error
 }
 
 type eventsRepository struct {
@@ -22,20 +31,58 @@ type eventsRepository struct {
 }
 
 func NewEventsRepository(mongodb *services.MongoDB, logger *zap.Logger) EventsRepository {
+	return NewEventsRepositoryFor(mongodb, logger, EventsCollection)
+}
+
+// NewEventsRepositoryFor archives events into another collection, e.g. to compare a replay against the live one
+func NewEventsRepositoryFor(mongodb *services.MongoDB, logger *zap.Logger, collection string) EventsRepository {
 	return &eventsRepository{
-		Collection: mongodb.MongoDB.Collection(eventsCollection),
+		Collection: mongodb.MongoDB.Collection(collection),
 		Logger:     logger,
 	}
 }
 
-func (repo *eventsRepository) SaveEvent(ctx context.Context, event *events.CounterEvent) {
-	event.AddTrail(events.Consumer, time.Now().UTC())
+func (repo *eventsRepository) SaveEvent(ctx context.Context, event *events.CounterEvent) error {
+	// Saving may be retried, so the event itself is left untouched
+	record := *event
+	record.Trail = slices.Clone(event.Trail)
+	record.AddTrail(events.Consumer, time.Now().UTC())
+	record.Tenant = event.TenantOrDefault()
 
-	_, err := repo.Collection.InsertOne(ctx, event)
+	_, err := repo.Collection.InsertOne(ctx, &record)
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
 			repo.Logger.Info("Event has been already consumed", zap.String("id", event.EventId.Hex()))
+			return nil
 		}
 		repo.Logger.Error("Error saving event", zap.String("id", event.EventId.Hex()), zap.Error(err))
+		return fmt.Errorf("error happened while saving event %v: %w", event.EventId.Hex(), err)
+	}
+	return nil
+}
+
+func (repo *eventsRepository) ForEach(ctx context.Context, handle func(event *events.CounterEvent) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	filter := bson.D{}
+	if tenant, ok := data.TenantFrom(ctx); ok {
+		filter = append(filter, tenantFilterOf(tenant, "tenant"))
+	}
+
+	cursor, err := repo.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return fmt.Errorf("error happened while reading events: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var event events.CounterEvent
+		if err := cursor.Decode(&event); err != nil {
+			return fmt.Errorf("error happened while decoding event: %w", err)
+		}
+		if err := handle(&event); err != nil {
+			return err
+		}
 	}
+	return cursor.Err()
 }
@@ -0,0 +1,78 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/steadfastie/gokube/data"
+	"github.com/steadfastie/gokube/data/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+const inboxCollection = "inbox"
+
+var errAlreadyProcessed = errors.New("event has been already processed")
+
+type InboxRepository interface {
+	// Process runs apply unless the handler has already processed the event. The inbox entry and
+	// every write apply makes with the given context are committed in a single transaction.
+	// Reports whether apply ran
+	Process(ctx context.Context, eventId primitive.ObjectID, handler string, apply func(ctx context.Context) error) (bool, error)
+}
+
+type inboxRepository struct {
+	Client     *mongo.Client
+	Collection *mongo.Collection
+	Logger     *zap.Logger
+}
+
+// NewInboxRepository makes sure inbox entries expire after ttl. Redeliveries later than that are processed again
+func NewInboxRepository(ctx context.Context, mongodb *services.MongoDB, logger *zap.Logger, ttl time.Duration) (InboxRepository, error) {
+	collection := mongodb.MongoDB.Collection(inboxCollection)
+
+	if err := services.EnsureTTLIndex(ctx, collection, "processedAt", ttl); err != nil {
+		return nil, fmt.Errorf("error happened while creating inbox TTL index: %w", err)
+	}
+
+	return &inboxRepository{
+		Client:     mongodb.MongoClient,
+		Collection: collection,
+		Logger:     logger,
+	}, nil
+}
+
+func (repo *inboxRepository) Process(ctx context.Context, eventId primitive.ObjectID, handler string, apply func(ctx context.Context) error) (bool, error) {
+	session, err := repo.Client.StartSession()
+	if err != nil {
+		return false, fmt.Errorf("error happened while starting inbox session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	entry := data.InboxDocument{
+		Id:          data.InboxKey{EventId: eventId, Handler: handler},
+		ProcessedAt: time.Now().UTC(),
+	}
+
+	// Concurrent deliveries of the same event collide on the entry and the loser is retried by the driver
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		if _, err := repo.Collection.InsertOne(sessionCtx, entry); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, errAlreadyProcessed
+			}
+			return nil, err
+		}
+		return nil, apply(sessionCtx)
+	})
+	if errors.Is(err, errAlreadyProcessed) {
+		repo.Logger.Info("Event has been already processed", zap.String("id", eventId.Hex()), zap.String("handler", handler))
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error happened while processing event %v by %v: %w", eventId.Hex(), handler, err)
+	}
+	return true, nil
+}
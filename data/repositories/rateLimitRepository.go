@@ -0,0 +1,115 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/steadfastie/gokube/data"
+	"github.com/steadfastie/gokube/data/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const rateLimitsCollection = "rate_limits"
+
+type RateLimitRepository interface {
+	// Take spends a token of the bucket under the key, if there is one, and reports what is left of it
+	Take(ctx context.Context, key string, limit data.RateLimit) (*data.RateLimitDecision, error)
+}
+
+// mongoRateLimitRepository shares buckets between API replicas
+type mongoRateLimitRepository struct {
+	Collection *mongo.Collection
+	Logger     *zap.Logger
+}
+
+// NewMongoRateLimitRepository makes sure buckets of idle clients are removed once they are full again
+func NewMongoRateLimitRepository(ctx context.Context, mongodb *services.MongoDB, logger *zap.Logger) (RateLimitRepository, error) {
+	collection := mongodb.MongoDB.Collection(rateLimitsCollection)
+
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	if _, err := collection.Indexes().CreateOne(ctx, index); err != nil {
+		return nil, fmt.Errorf("error happened while creating rate limit TTL index: %w", err)
+	}
+
+	return &mongoRateLimitRepository{
+		Collection: collection,
+		Logger:     logger,
+	}, nil
+}
+
+// Take does what data.RateLimit.Take does in a single update. Time is taken from the database,
+// so that the clocks of the replicas don't matter
+func (repo *mongoRateLimitRepository) Take(ctx context.Context, key string, limit data.RateLimit) (*data.RateLimitDecision, error) {
+	capacity := float64(limit.Requests)
+	elapsed := bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{"$$NOW", bson.M{"$ifNull": bson.A{"$updatedAt", "$$NOW"}}}}}}
+	refilled := bson.M{"$min": bson.A{capacity, bson.M{"$add": bson.A{
+		bson.M{"$ifNull": bson.A{"$tokens", capacity}},
+		bson.M{"$multiply": bson.A{elapsed, capacity / float64(limit.Period.Milliseconds())}},
+	}}}}
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{{Key: "tokens", Value: refilled}, {Key: "updatedAt", Value: "$$NOW"}}}},
+		{{Key: "$set", Value: bson.D{{Key: "allowed", Value: bson.M{"$gte": bson.A{"$tokens", 1}}}}}},
+		{{Key: "$set", Value: bson.D{
+			{Key: "tokens", Value: bson.M{"$cond": bson.A{"$allowed", bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}}},
+			{Key: "expiresAt", Value: bson.M{"$add": bson.A{"$$NOW", limit.Period.Milliseconds()}}},
+		}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var bucket data.RateLimitBucket
+	err := repo.Collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&bucket)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another replica created the bucket in the meantime
+		err = repo.Collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&bucket)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error happened while taking a token of %v: %w", key, err)
+	}
+	return limit.Decide(&bucket), nil
+}
+
+// How often the memory backend drops buckets that are full again
+const rateLimitSweepInterval = time.Minute
+
+// memoryRateLimitRepository keeps buckets per process, so every replica enforces the limits on its own
+type memoryRateLimitRepository struct {
+	mutex   sync.Mutex
+	buckets map[string]*data.RateLimitBucket
+	sweptAt time.Time
+}
+
+func NewMemoryRateLimitRepository() RateLimitRepository {
+	return &memoryRateLimitRepository{
+		buckets: map[string]*data.RateLimitBucket{},
+		sweptAt: time.Now(),
+	}
+}
+
+func (repo *memoryRateLimitRepository) Take(ctx context.Context, key string, limit data.RateLimit) (*data.RateLimitDecision, error) {
+	now := time.Now()
+
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if now.Sub(repo.sweptAt) >= rateLimitSweepInterval {
+		for bucketKey, bucket := range repo.buckets {
+			if !now.Before(bucket.ExpiresAt) {
+				delete(repo.buckets, bucketKey)
+			}
+		}
+		repo.sweptAt = now
+	}
+
+	bucket := limit.Take(key, repo.buckets[key], now)
+	repo.buckets[key] = bucket
+	return limit.Decide(bucket), nil
+}
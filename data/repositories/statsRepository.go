@@ -0,0 +1,240 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/steadfastie/gokube/data"
+	"github.com/steadfastie/gokube/data/events"
+	"github.com/steadfastie/gokube/data/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	statsCollection      = "counter_stats"
+	dailyStatsCollection = "counter_stats_daily"
+)
+
+// Every stats document remembers the latest applied event ids, so that ReplayEvent doesn't count an event twice.
+// An event older than the window is counted again, which is why only rebuild-stats may skip the inbox
+const appliedEventsWindow = 500
+
+type StatsRepository interface {
+	GetByCounterId(ctx context.Context, id string, resultChan chan<- *data.CounterStatsDocument, errChan chan<- error)
+	GetDaily(ctx context.Context, from time.Time, to time.Time, resultChan chan<- []data.DailyStatsDocument, errChan chan<- error)
+	// ApplyEvent folds the event into the projection. The caller makes sure every event is applied once,
+	// as the inbox does, and any failed write is returned so that the inbox transaction is retried as a whole
+	ApplyEvent(ctx context.Context, event *events.CounterEvent) error
+	// ReplayEvent folds the event into the projection unless it is among the latest appliedEventsWindow events
+	// applied to the counter or the day. Only for rebuilding the projection, which can't go through the inbox
+	ReplayEvent(ctx context.Context, event *events.CounterEvent) error
+	// Reset drops the projection, so that it can be rebuilt from the archived events
+	Reset(ctx context.Context) error
+}
+
+type statsRepository struct {
+	Stats  *mongo.Collection
+	Daily  *mongo.Collection
+	Logger *zap.Logger
+}
+
+func NewStatsRepository(mongodb *services.MongoDB, logger *zap.Logger) StatsRepository {
+	return &statsRepository{
+		Stats:  mongodb.MongoDB.Collection(statsCollection),
+		Daily:  mongodb.MongoDB.Collection(dailyStatsCollection),
+		Logger: logger,
+	}
+}
+
+// Sends nil when no event of the counter has been projected yet
+func (repo *statsRepository) GetByCounterId(ctx context.Context, id string, resultChan chan<- *data.CounterStatsDocument, errChan chan<- error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	var result data.CounterStatsDocument
+	if err := repo.Stats.FindOne(ctx, bson.M{"_id": objectID}).Decode(&result); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			resultChan <- nil
+			return
+		}
+		errChan <- err
+		return
+	}
+	resultChan <- &result
+}
+
+func (repo *statsRepository) GetDaily(ctx context.Context, from time.Time, to time.Time, resultChan chan<- []data.DailyStatsDocument, errChan chan<- error) {
+	tenant := data.TenantOrDefault(ctx)
+	filter := bson.M{"_id": bson.M{
+		"$gte": data.DailyStatsId(tenant, from.Format(data.DayLayout)),
+		"$lte": data.DailyStatsId(tenant, to.Format(data.DayLayout)),
+	}}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	cursor, err := repo.Daily.Find(ctx, filter, opts)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	results := []data.DailyStatsDocument{}
+	if err := cursor.All(ctx, &results); err != nil {
+		errChan <- err
+		return
+	}
+	resultChan <- results
+}
+
+func (repo *statsRepository) ApplyEvent(ctx context.Context, event *events.CounterEvent) error {
+	return repo.apply(ctx, event, false)
+}
+
+func (repo *statsRepository) ReplayEvent(ctx context.Context, event *events.CounterEvent) error {
+	return repo.apply(ctx, event, true)
+}
+
+// Event ids are recorded either way, so that a rebuild running next to the consumers skips what they have applied
+func (repo *statsRepository) apply(ctx context.Context, event *events.CounterEvent, guarded bool) error {
+	at := event.OccurredAt().UTC()
+
+	var err error
+	switch event.What {
+	case data.CounterCreated:
+		err = repo.applyCreated(ctx, event, at, guarded)
+	case data.CounterUpdated:
+		err = repo.applyUpdated(ctx, event, at, guarded)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error happened while projecting event %v: %w", event.EventId.Hex(), err)
+	}
+
+	return repo.applyDaily(ctx, event, at, guarded)
+}
+
+func (repo *statsRepository) Reset(ctx context.Context) error {
+	if err := repo.Stats.Drop(ctx); err != nil {
+		return fmt.Errorf("error happened while dropping %v: %w", statsCollection, err)
+	}
+	if err := repo.Daily.Drop(ctx); err != nil {
+		return fmt.Errorf("error happened while dropping %v: %w", dailyStatsCollection, err)
+	}
+	return nil
+}
+
+func (repo *statsRepository) applyCreated(ctx context.Context, event *events.CounterEvent, at time.Time, guarded bool) error {
+	update := bson.D{
+		{Key: "$min", Value: bson.D{{Key: "createdAt", Value: at}}},
+		{Key: "$setOnInsert", Value: bson.D{
+			{Key: "totalUpdates", Value: 0},
+			{Key: "users", Value: bson.A{}},
+		}},
+		{Key: "$push", Value: bson.D{{Key: "appliedEvents", Value: appliedEvent(event.EventId)}}},
+	}
+	return upsert(ctx, repo.Stats, filterOf(event.CounterId, event.EventId, guarded), update, guarded)
+}
+
+// Users are kept in an array, as their names are not safe to use as field names.
+// The first update of a user pushes a new entry, the following ones increment it
+func (repo *statsRepository) applyUpdated(ctx context.Context, event *events.CounterEvent, at time.Time, guarded bool) error {
+	timestamps := []bson.E{
+		{Key: "$min", Value: bson.D{{Key: "firstUpdateAt", Value: at}}},
+		{Key: "$max", Value: bson.D{{Key: "lastUpdateAt", Value: at}}},
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		knownUser := append(filterOf(event.CounterId, event.EventId, guarded), bson.E{Key: "users.who", Value: event.Who})
+		increment := append(bson.D{
+			{Key: "$inc", Value: bson.D{
+				{Key: "totalUpdates", Value: 1},
+				{Key: "users.$.updates", Value: 1},
+			}},
+			{Key: "$push", Value: bson.D{{Key: "appliedEvents", Value: appliedEvent(event.EventId)}}},
+		}, timestamps...)
+
+		result, err := repo.Stats.UpdateOne(ctx, knownUser, increment)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount > 0 {
+			return nil
+		}
+
+		newUser := append(filterOf(event.CounterId, event.EventId, guarded), bson.E{Key: "users.who", Value: bson.D{{Key: "$ne", Value: event.Who}}})
+		push := append(bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "totalUpdates", Value: 1}}},
+			{Key: "$push", Value: bson.D{
+				{Key: "appliedEvents", Value: appliedEvent(event.EventId)},
+				{Key: "users", Value: data.UserUpdates{Who: event.Who, Updates: 1}},
+			}},
+		}, timestamps...)
+
+		_, err = repo.Stats.UpdateOne(ctx, newUser, push, options.Update().SetUpsert(true))
+		if err == nil {
+			return nil
+		}
+		// Either the event has been applied already, or another update has just added the user.
+		// Within a transaction the failed write has aborted it, so only a retry of the whole transaction helps
+		if !guarded || !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+
+	repo.Logger.Info("Event has been already projected", zap.String("id", event.EventId.Hex()))
+	return nil
+}
+
+func (repo *statsRepository) applyDaily(ctx context.Context, event *events.CounterEvent, at time.Time, guarded bool) error {
+	field := "updated"
+	if event.What == data.CounterCreated {
+		field = "created"
+	}
+
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: field, Value: 1}}},
+		{Key: "$push", Value: bson.D{{Key: "appliedEvents", Value: appliedEvent(event.EventId)}}},
+	}
+	id := data.DailyStatsId(event.TenantOrDefault(), at.Format(data.DayLayout))
+	if err := upsert(ctx, repo.Daily, filterOf(id, event.EventId, guarded), update, guarded); err != nil {
+		return fmt.Errorf("error happened while projecting daily stats of event %v: %w", event.EventId.Hex(), err)
+	}
+	return nil
+}
+
+// Guarded filters match the document unless the event has already been applied to it
+func filterOf(id any, eventId primitive.ObjectID, guarded bool) bson.D {
+	if !guarded {
+		return bson.D{{Key: "_id", Value: id}}
+	}
+	return bson.D{
+		{Key: "_id", Value: id},
+		{Key: "appliedEvents", Value: bson.D{{Key: "$ne", Value: eventId}}},
+	}
+}
+
+func appliedEvent(eventId primitive.ObjectID) bson.D {
+	return bson.D{
+		{Key: "$each", Value: bson.A{eventId}},
+		{Key: "$slice", Value: -appliedEventsWindow},
+	}
+}
+
+// When the guard filters out an existing document, the upsert collides with its id. That means the event is already applied.
+// Unguarded upserts only collide with a concurrent insert, which is returned
+func upsert(ctx context.Context, collection *mongo.Collection, filter bson.D, update bson.D, guarded bool) error {
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil && !(guarded && mongo.IsDuplicateKeyError(err)) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package repositories
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Events applied through the inbox are applied once already, so only replays check the applied events
+func TestStatsFilter(t *testing.T) {
+	counterId := primitive.NewObjectID()
+	eventId := primitive.NewObjectID()
+
+	if filter := filterOf(counterId, eventId, false); !reflect.DeepEqual(filter, bson.D{{Key: "_id", Value: counterId}}) {
+		t.Errorf("unguarded filter = %v, want only the id", filter)
+	}
+
+	guarded := bson.D{
+		{Key: "_id", Value: counterId},
+		{Key: "appliedEvents", Value: bson.D{{Key: "$ne", Value: eventId}}},
+	}
+	if filter := filterOf(counterId, eventId, true); !reflect.DeepEqual(filter, guarded) {
+		t.Errorf("guarded filter = %v, want %v", filter, guarded)
+	}
+}
@@ -0,0 +1,21 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/steadfastie/gokube/data"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// tenantFilter matches documents of the tenant of the request.
+// Documents written before tenants existed have no tenant and belong to the default one
+func tenantFilter(ctx context.Context, field string) bson.E {
+	return tenantFilterOf(data.TenantOrDefault(ctx), field)
+}
+
+func tenantFilterOf(tenant string, field string) bson.E {
+	if tenant == data.DefaultTenant {
+		return bson.E{Key: field, Value: bson.D{{Key: "$in", Value: bson.A{tenant, nil}}}}
+	}
+	return bson.E{Key: field, Value: tenant}
+}
@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/steadfastie/gokube/data"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// matchesTenant evaluates a tenant filter the way the server would, for the two shapes tenantFilterOf builds
+func matchesTenant(t *testing.T, filter bson.E, tenant any) bool {
+	t.Helper()
+	switch value := filter.Value.(type) {
+	case string:
+		return tenant == value
+	case bson.D:
+		if len(value) != 1 || value[0].Key != "$in" {
+			t.Fatalf("unexpected tenant filter %v", filter)
+		}
+		return slices.Contains(value[0].Value.(bson.A), tenant)
+	default:
+		t.Fatalf("unexpected tenant filter %v", filter)
+		return false
+	}
+}
+
+func TestTenantFilter(t *testing.T) {
+	// Documents written before tenants existed have no tenant field at all
+	documents := []any{"acme", "globex", data.DefaultTenant, nil}
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		matches []any
+	}{
+		{"tenant", context.WithValue(context.Background(), data.TenantKey, "acme"), []any{"acme"}},
+		{"other tenant", context.WithValue(context.Background(), data.TenantKey, "globex"), []any{"globex"}},
+		{"default tenant", context.WithValue(context.Background(), data.TenantKey, data.DefaultTenant), []any{data.DefaultTenant, nil}},
+		{"no tenant", context.Background(), []any{data.DefaultTenant, nil}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := tenantFilter(test.ctx, "document.tenant")
+			if filter.Key != "document.tenant" {
+				t.Errorf("filter key = %q, want the given field", filter.Key)
+			}
+
+			matches := []any{}
+			for _, tenant := range documents {
+				if matchesTenant(t, filter, tenant) {
+					matches = append(matches, tenant)
+				}
+			}
+			if !slices.Equal(matches, test.matches) {
+				t.Errorf("filter matches documents of %v, want %v", matches, test.matches)
+			}
+		})
+	}
+}
+
+func TestTenantFilterOf(t *testing.T) {
+	if matchesTenant(t, tenantFilterOf("acme", "tenant"), "globex") {
+		t.Error("filter of acme matches documents of globex")
+	}
+	if matchesTenant(t, tenantFilterOf("acme", "tenant"), nil) {
+		t.Error("filter of acme matches documents without a tenant")
+	}
+	if !matchesTenant(t, tenantFilterOf(data.DefaultTenant, "tenant"), nil) {
+		t.Error("filter of the default tenant doesn't match documents without a tenant")
+	}
+}
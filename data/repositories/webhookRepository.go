@@ -0,0 +1,224 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/steadfastie/gokube/data"
+	"github.com/steadfastie/gokube/data/events"
+	"github.com/steadfastie/gokube/data/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	webhooksCollection          = "webhooks"
+	webhookDeliveriesCollection = "webhook_deliveries"
+)
+
+// How many deliveries of a subscription are listed at once
+const deliveriesPageSize = 100
+
+type WebhookRepository interface {
+	Create(ctx context.Context, webhook *data.WebhookDocument, resultChan chan<- *data.WebhookDocument, errChan chan<- error)
+	// List sends subscriptions created by the user
+	List(ctx context.Context, resultChan chan<- []data.WebhookDocument, errChan chan<- error)
+	// Delete sends false when the user has no such subscription
+	Delete(ctx context.Context, id string, resultChan chan<- bool, errChan chan<- error)
+	// ListDeliveries sends the latest deliveries of a subscription of the user, or nil when there is no such subscription
+	ListDeliveries(ctx context.Context, id string, resultChan chan<- []data.WebhookDelivery, errChan chan<- error)
+
+	// FindMatching returns enabled subscriptions interested in the event whose creator may read the counter.
+	// Access changes only reach subscriptions of the counter admins
+	FindMatching(ctx context.Context, event *events.CounterEvent) ([]data.WebhookDocument, error)
+	LogDelivery(ctx context.Context, delivery *data.WebhookDelivery) error
+	RecordSuccess(ctx context.Context, id primitive.ObjectID) error
+	// RecordFailure disables the subscription once it failed disableAfter deliveries in a row. Reports whether it did
+	RecordFailure(ctx context.Context, id primitive.ObjectID, disableAfter int) (bool, error)
+}
+
+type webhookRepository struct {
+	Webhooks   *mongo.Collection
+	Deliveries *mongo.Collection
+	Counters   *mongo.Collection
+	Logger     *zap.Logger
+}
+
+func NewWebhookRepository(mongodb *services.MongoDB, logger *zap.Logger) WebhookRepository {
+	return &webhookRepository{
+		Webhooks:   mongodb.MongoDB.Collection(webhooksCollection),
+		Deliveries: mongodb.MongoDB.Collection(webhookDeliveriesCollection),
+		Counters:   mongodb.MongoDB.Collection(collection),
+		Logger:     logger,
+	}
+}
+
+func (repo *webhookRepository) Create(ctx context.Context, webhook *data.WebhookDocument, resultChan chan<- *data.WebhookDocument, errChan chan<- error) {
+	if _, err := repo.Webhooks.InsertOne(ctx, webhook); err != nil {
+		repo.Logger.Error("Could not create webhook", zap.String("url", webhook.Url), zap.Error(err))
+		errChan <- err
+		return
+	}
+	resultChan <- webhook
+}
+
+func (repo *webhookRepository) List(ctx context.Context, resultChan chan<- []data.WebhookDocument, errChan chan<- error) {
+	filter := bson.D{{Key: "createdBy", Value: ctx.Value("user").(string)}, tenantFilter(ctx, "tenant")}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	cursor, err := repo.Webhooks.Find(ctx, filter, opts)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	results := []data.WebhookDocument{}
+	if err := cursor.All(ctx, &results); err != nil {
+		errChan <- err
+		return
+	}
+	resultChan <- results
+}
+
+func (repo *webhookRepository) Delete(ctx context.Context, id string, resultChan chan<- bool, errChan chan<- error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	filter := bson.D{{Key: "_id", Value: objectID}, {Key: "createdBy", Value: ctx.Value("user").(string)}, tenantFilter(ctx, "tenant")}
+	result, err := repo.Webhooks.DeleteOne(ctx, filter)
+	if err != nil {
+		errChan <- err
+		return
+	}
+	resultChan <- result.DeletedCount > 0
+}
+
+func (repo *webhookRepository) ListDeliveries(ctx context.Context, id string, resultChan chan<- []data.WebhookDelivery, errChan chan<- error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	owned, err := repo.Webhooks.CountDocuments(ctx, bson.D{{Key: "_id", Value: objectID}, {Key: "createdBy", Value: ctx.Value("user").(string)}, tenantFilter(ctx, "tenant")})
+	if err != nil {
+		errChan <- err
+		return
+	}
+	if owned == 0 {
+		resultChan <- nil
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(deliveriesPageSize)
+	cursor, err := repo.Deliveries.Find(ctx, bson.M{"subscriptionId": objectID}, opts)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	results := []data.WebhookDelivery{}
+	if err := cursor.All(ctx, &results); err != nil {
+		errChan <- err
+		return
+	}
+	resultChan <- results
+}
+
+func (repo *webhookRepository) FindMatching(ctx context.Context, event *events.CounterEvent) ([]data.WebhookDocument, error) {
+	filter := bson.D{
+		{Key: "disabled", Value: false},
+		{Key: "counterId", Value: bson.D{{Key: "$in", Value: bson.A{nil, event.CounterId}}}},
+		{Key: "eventType", Value: bson.D{{Key: "$in", Value: bson.A{nil, event.What}}}},
+		tenantFilterOf(event.TenantOrDefault(), "tenant"),
+	}
+
+	cursor, err := repo.Webhooks.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("error happened while finding webhooks of event %v: %w", event.EventId.Hex(), err)
+	}
+
+	candidates := []data.WebhookDocument{}
+	if err := cursor.All(ctx, &candidates); err != nil {
+		return nil, fmt.Errorf("error happened while reading webhooks of event %v: %w", event.EventId.Hex(), err)
+	}
+	if len(candidates) == 0 {
+		return candidates, nil
+	}
+
+	// Access is checked on delivery rather than on creation only, as it may have been revoked since
+	var counter struct {
+		Document data.CounterDocument `bson:"document"`
+	}
+	opts := options.FindOne().SetProjection(bson.D{{Key: "document.owner", Value: 1}, {Key: "document.grants", Value: 1}})
+	err = repo.Counters.FindOne(ctx, bson.D{{Key: "_id", Value: event.CounterId}, tenantFilterOf(event.TenantOrDefault(), "document.tenant")}, opts).Decode(&counter)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return []data.WebhookDocument{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error happened while reading access to counter of event %v: %w", event.EventId.Hex(), err)
+	}
+
+	return matchAccess(candidates, &counter.Document, event.What), nil
+}
+
+// Grants name other principals, so only those who could change them hear about them
+func matchAccess(webhooks []data.WebhookDocument, counter *data.CounterDocument, eventType data.EventType) []data.WebhookDocument {
+	access := data.ReadAccess
+	if eventType == data.AccessGranted || eventType == data.AccessRevoked {
+		access = data.AdminAccess
+	}
+
+	results := []data.WebhookDocument{}
+	for _, webhook := range webhooks {
+		if counter.Allows(webhook.CreatedBy, webhook.CreatorGroups, access) {
+			results = append(results, webhook)
+		}
+	}
+	return results
+}
+
+func (repo *webhookRepository) LogDelivery(ctx context.Context, delivery *data.WebhookDelivery) error {
+	if _, err := repo.Deliveries.InsertOne(ctx, delivery); err != nil {
+		return fmt.Errorf("error happened while logging delivery to %v: %w", delivery.SubscriptionId.Hex(), err)
+	}
+	return nil
+}
+
+func (repo *webhookRepository) RecordSuccess(ctx context.Context, id primitive.ObjectID) error {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "consecutiveFailures", Value: 0}}}}
+	if _, err := repo.Webhooks.UpdateByID(ctx, id, update); err != nil {
+		return fmt.Errorf("error happened while recording success of %v: %w", id.Hex(), err)
+	}
+	return nil
+}
+
+func (repo *webhookRepository) RecordFailure(ctx context.Context, id primitive.ObjectID, disableAfter int) (bool, error) {
+	update := bson.D{{Key: "$inc", Value: bson.D{{Key: "consecutiveFailures", Value: 1}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var webhook data.WebhookDocument
+	if err := repo.Webhooks.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&webhook); err != nil {
+		return false, fmt.Errorf("error happened while recording failure of %v: %w", id.Hex(), err)
+	}
+	if webhook.Disabled || webhook.ConsecutiveFailures < disableAfter {
+		return false, nil
+	}
+
+	disable := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "disabled", Value: true},
+		{Key: "disabledAt", Value: time.Now().UTC()},
+	}}}
+	if _, err := repo.Webhooks.UpdateByID(ctx, id, disable); err != nil {
+		return false, fmt.Errorf("error happened while disabling %v: %w", id.Hex(), err)
+	}
+	return true, nil
+}
@@ -0,0 +1,56 @@
+package repositories
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/steadfastie/gokube/data"
+)
+
+func TestMatchAccess(t *testing.T) {
+	counter := &data.CounterDocument{
+		Owner: "auth0|owner",
+		Grants: []data.AccessGrant{
+			{Kind: data.UserPrincipal, Principal: "auth0|reader", Access: data.ReadAccess},
+			{Kind: data.GroupPrincipal, Principal: "admins", Access: data.AdminAccess},
+		},
+	}
+	webhooks := []data.WebhookDocument{
+		{CreatedBy: "auth0|owner"},
+		{CreatedBy: "auth0|reader"},
+		{CreatedBy: "auth0|admin", CreatorGroups: []string{"admins"}},
+		{CreatedBy: "auth0|stranger", CreatorGroups: []string{"readers"}},
+	}
+
+	tests := []struct {
+		eventType data.EventType
+		creators  []string
+	}{
+		{data.CounterUpdated, []string{"auth0|owner", "auth0|reader", "auth0|admin"}},
+		{data.AccessGranted, []string{"auth0|owner", "auth0|admin"}},
+		{data.AccessRevoked, []string{"auth0|owner", "auth0|admin"}},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.eventType), func(t *testing.T) {
+			creators := []string{}
+			for _, webhook := range matchAccess(webhooks, counter, test.eventType) {
+				creators = append(creators, webhook.CreatedBy)
+			}
+			if !slices.Equal(creators, test.creators) {
+				t.Errorf("got webhooks of %v, want %v", creators, test.creators)
+			}
+		})
+	}
+}
+
+func TestMatchAccessOwnerlessCounter(t *testing.T) {
+	webhooks := []data.WebhookDocument{{CreatedBy: "auth0|anyone"}}
+
+	if got := matchAccess(webhooks, &data.CounterDocument{}, data.CounterUpdated); len(got) != 0 {
+		t.Errorf("got %d webhooks for an update of an ownerless counter, want 0", len(got))
+	}
+	if got := matchAccess(webhooks, &data.CounterDocument{}, data.AccessGranted); len(got) != 0 {
+		t.Errorf("got %d webhooks for a grant on an ownerless counter, want 0", len(got))
+	}
+}
@@ -2,8 +2,11 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
@@ -73,3 +76,62 @@ func (mongodb *MongoDB) CheckConnection(ctx context.Context) bool {
 	err := mongodb.MongoClient.Ping(ctx, readpref.PrimaryPreferred())
 	return err == nil
 }
+
+// RequireReplicaSet fails unless the deployment supports transactions, i.e. is a replica set or a sharded cluster
+func (mongodb *MongoDB) RequireReplicaSet(ctx context.Context) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := mongodb.MongoDB.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return fmt.Errorf("error happened while asking mongo for its topology: %w", err)
+	}
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return errors.New("mongo must run as a replica set or a sharded cluster to support transactions")
+	}
+	return nil
+}
+
+// EnsureTTLIndex creates a TTL index on the field, or changes the expiry of the existing one.
+// Creating it again with another expiry would fail with IndexOptionsConflict
+func EnsureTTLIndex(ctx context.Context, collection *mongo.Collection, field string, ttl time.Duration) error {
+	seconds := int64(ttl.Seconds())
+
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	var indexes []struct {
+		Name               string `bson:"name"`
+		Key                bson.D `bson:"key"`
+		ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+	}
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		if len(index.Key) != 1 || index.Key[0].Key != field {
+			continue
+		}
+		if index.ExpireAfterSeconds == nil {
+			return fmt.Errorf("index %v on %v is not a TTL index, drop it to let documents expire", index.Name, field)
+		}
+		if *index.ExpireAfterSeconds == seconds {
+			return nil
+		}
+		return collection.Database().RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: collection.Name()},
+			{Key: "index", Value: bson.D{
+				{Key: "name", Value: index.Name},
+				{Key: "expireAfterSeconds", Value: seconds},
+			}},
+		}).Err()
+	}
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(seconds)),
+	})
+	return err
+}
@@ -0,0 +1,85 @@
+package data
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const DayLayout = "2006-01-02"
+
+type UserUpdates struct {
+	Who     string `bson:"who" json:"who" example:"John (auth0|65a0ae4d4b4e5b1f4c1f7a2c)"`
+	Updates int    `bson:"updates" json:"updates" example:"3"`
+}
+
+// CounterStatsDocument is a read model built by the consumer out of counter events
+type CounterStatsDocument struct {
+	CounterId     primitive.ObjectID   `bson:"_id"`
+	TotalUpdates  int                  `bson:"totalUpdates"`
+	Users         []UserUpdates        `bson:"users"`
+	CreatedAt     *time.Time           `bson:"createdAt,omitempty"`
+	FirstUpdateAt *time.Time           `bson:"firstUpdateAt,omitempty"`
+	LastUpdateAt  *time.Time           `bson:"lastUpdateAt,omitempty"`
+	AppliedEvents []primitive.ObjectID `bson:"appliedEvents"`
+}
+
+type CounterStatsResponse struct {
+	CounterId     primitive.ObjectID `example:"60c7c02ea38e3c3c4426c1bd"`
+	TotalUpdates  int                `example:"5"`
+	Users         []UserUpdates
+	CreatedAt     *time.Time `example:"2022-02-30T12:00:00Z"`
+	FirstUpdateAt *time.Time `example:"2022-02-30T12:00:00Z"`
+	LastUpdateAt  *time.Time `example:"2022-02-30T12:00:00Z"`
+}
+
+func (document *CounterStatsDocument) MapToResponseModel() *CounterStatsResponse {
+	return &CounterStatsResponse{
+		CounterId:     document.CounterId,
+		TotalUpdates:  document.TotalUpdates,
+		Users:         document.Users,
+		CreatedAt:     document.CreatedAt,
+		FirstUpdateAt: document.FirstUpdateAt,
+		LastUpdateAt:  document.LastUpdateAt,
+	}
+}
+
+// DailyStatsDocument counts counter events of a single UTC day. Days of tenants other than the default one are prefixed with the tenant
+type DailyStatsDocument struct {
+	Day           string               `bson:"_id"`
+	Created       int                  `bson:"created"`
+	Updated       int                  `bson:"updated"`
+	AppliedEvents []primitive.ObjectID `bson:"appliedEvents"`
+}
+
+type DailyStatsResponse struct {
+	Day     string `example:"2022-02-28"`
+	Created int    `example:"2"`
+	Updated int    `example:"15"`
+}
+
+// DailyStatsId keeps the ids of the default tenant as they were before tenants existed
+func DailyStatsId(tenant string, day string) string {
+	if tenant == DefaultTenant {
+		return day
+	}
+	return tenant + "/" + day
+}
+
+func (document *DailyStatsDocument) MapToResponseModel() *DailyStatsResponse {
+	day := document.Day
+	if i := strings.LastIndexByte(day, '/'); i >= 0 {
+		day = day[i+1:]
+	}
+	return &DailyStatsResponse{
+		Day:     day,
+		Created: document.Created,
+		Updated: document.Updated,
+	}
+}
+
+type DailyStatsQuery struct {
+	From string `form:"from" example:"2022-02-01"`
+	To   string `form:"to" example:"2022-02-28"`
+}
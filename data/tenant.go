@@ -0,0 +1,33 @@
+package data
+
+import (
+	"context"
+	"regexp"
+)
+
+// DefaultTenant owns everything written before tenants existed, and requests that don't name a tenant
+const DefaultTenant = "default"
+
+// TenantKey is where a request keeps its tenant. gin contexts resolve it among their keys
+const TenantKey = "tenant"
+
+// Tenant ids start with a letter, so that they never collide with the days daily stats are keyed by
+var tenantPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{0,62}$`)
+
+func ValidTenant(tenant string) bool {
+	return tenantPattern.MatchString(tenant)
+}
+
+// TenantFrom reports false for contexts outside of a request, such as maintenance commands
+func TenantFrom(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(TenantKey).(string)
+	return tenant, ok && tenant != ""
+}
+
+// TenantOrDefault is the tenant of the request, or the default one
+func TenantOrDefault(ctx context.Context) string {
+	if tenant, ok := TenantFrom(ctx); ok {
+		return tenant
+	}
+	return DefaultTenant
+}
@@ -0,0 +1,92 @@
+package data
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WebhookDocument is a subscription of a partner to counter events, scoped to a counter, an event type or both
+type WebhookDocument struct {
+	Id        primitive.ObjectID  `bson:"_id"`
+	Url       string              `bson:"url"`
+	Secret    string              `bson:"secret"`
+	CounterId *primitive.ObjectID `bson:"counterId,omitempty"`
+	EventType EventType           `bson:"eventType,omitempty"`
+	CreatedBy string              `bson:"createdBy"`
+	// Groups of the creator when the subscription was created, access to counters is checked with them on every delivery
+	CreatorGroups       []string   `bson:"creatorGroups,omitempty"`
+	CreatedAt           time.Time  `bson:"createdAt"`
+	Disabled            bool       `bson:"disabled"`
+	DisabledAt          *time.Time `bson:"disabledAt,omitempty"`
+	ConsecutiveFailures int        `bson:"consecutiveFailures"`
+	Tenant              string     `bson:"tenant,omitempty"`
+}
+
+func NewWebhookDocument(url string, secret string, counterId *primitive.ObjectID, eventType EventType, createdBy string, creatorGroups []string, tenant string, now time.Time) *WebhookDocument {
+	return &WebhookDocument{
+		Id:            primitive.NewObjectID(),
+		Url:           url,
+		Secret:        secret,
+		CounterId:     counterId,
+		EventType:     eventType,
+		CreatedBy:     createdBy,
+		CreatorGroups: creatorGroups,
+		CreatedAt:     now,
+		Tenant:        tenant,
+	}
+}
+
+type WebhookRequest struct {
+	Url       string    `json:"url" binding:"required,url" example:"https://partner.example.com/hooks/gokube"`
+	CounterId string    `json:"counterId" example:"60c7c02ea38e3c3c4426c1bd"`
+	EventType EventType `json:"eventType" example:"Updated"`
+}
+
+type WebhookResponse struct {
+	Id                  primitive.ObjectID  `example:"65a0ae4d4b4e5b1f4c1f7a2c"`
+	Url                 string              `example:"https://partner.example.com/hooks/gokube"`
+	CounterId           *primitive.ObjectID `example:"60c7c02ea38e3c3c4426c1bd"`
+	EventType           EventType           `example:"Updated"`
+	CreatedAt           time.Time           `example:"2022-02-30T12:00:00Z"`
+	Disabled            bool                `example:"false"`
+	ConsecutiveFailures int                 `example:"0"`
+}
+
+func (document *WebhookDocument) MapToResponseModel() *WebhookResponse {
+	return &WebhookResponse{
+		Id:                  document.Id,
+		Url:                 document.Url,
+		CounterId:           document.CounterId,
+		EventType:           document.EventType,
+		CreatedAt:           document.CreatedAt,
+		Disabled:            document.Disabled,
+		ConsecutiveFailures: document.ConsecutiveFailures,
+	}
+}
+
+// CreatedWebhookResponse is the only response that ever carries the signing secret
+type CreatedWebhookResponse struct {
+	WebhookResponse
+	Secret string `example:"whsec_5f2b6c0e9d..."`
+}
+
+func (document *WebhookDocument) MapToCreatedResponseModel() *CreatedWebhookResponse {
+	return &CreatedWebhookResponse{
+		WebhookResponse: *document.MapToResponseModel(),
+		Secret:          document.Secret,
+	}
+}
+
+// WebhookDelivery is a single attempt to deliver an event to a subscription
+type WebhookDelivery struct {
+	Id             primitive.ObjectID `bson:"_id" example:"65a0ae4d4b4e5b1f4c1f7a2d"`
+	SubscriptionId primitive.ObjectID `bson:"subscriptionId" example:"65a0ae4d4b4e5b1f4c1f7a2c"`
+	EventId        primitive.ObjectID `bson:"eventId" example:"65a0ae4d4b4e5b1f4c1f7a2e"`
+	Attempt        int                `bson:"attempt" example:"1"`
+	StatusCode     int                `bson:"statusCode,omitempty" example:"200"`
+	Error          string             `bson:"error,omitempty"`
+	Succeeded      bool               `bson:"succeeded" example:"true"`
+	Duration       time.Duration      `bson:"duration" swaggertype:"integer" example:"120000000"`
+	DeliveredAt    time.Time          `bson:"deliveredAt" example:"2022-02-30T12:00:00Z"`
+}
@@ -0,0 +1,105 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var ErrForbiddenWebhookTarget = errors.New("webhook target is not a public address")
+
+// Ranges webhooks may never reach, so that a subscription can't be used to probe the internal network
+// or cloud metadata endpoints. Loopback, private, link-local, multicast and unspecified addresses are checked separately
+var forbiddenPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	// Carrier-grade NAT, also used by some clouds internally
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	// NAT64 can wrap any of the above
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// ForbiddenWebhookAddress tells whether the address belongs to a range webhooks may not be delivered to
+func ForbiddenWebhookAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() ||
+		addr.IsLoopback() ||
+		addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() ||
+		addr.IsUnspecified() {
+		return true
+	}
+	for _, prefix := range forbiddenPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckWebhookUrl accepts only http and https URLs whose host resolves to public addresses.
+// Checked when a subscription is created, and once more by WebhookTransport on every delivery,
+// as the host may resolve differently by then
+func CheckWebhookUrl(ctx context.Context, resolver *net.Resolver, rawUrl string) error {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("webhook URL must use http or https, not %q", parsed.Scheme)
+	}
+	if parsed.User != nil {
+		return errors.New("webhook URL must not carry credentials")
+	}
+	host := parsed.Hostname()
+	if host == "" {
+		return errors.New("webhook URL must have a host")
+	}
+
+	addrs, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("webhook host %q could not be resolved: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if ForbiddenWebhookAddress(addr) {
+			return fmt.Errorf("%w: %v resolves to %v", ErrForbiddenWebhookTarget, host, addr)
+		}
+	}
+	return nil
+}
+
+// WebhookTransport dials public addresses only. The check runs on the address actually being connected to,
+// after resolution, so a host that resolved to a public address at creation can't be rebound to an internal one.
+// Proxies are not used, they would connect on the transport's behalf
+func WebhookTransport(timeout time.Duration) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if ForbiddenWebhookAddress(addrPort.Addr()) {
+				return fmt.Errorf("%w: %v", ErrForbiddenWebhookTarget, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
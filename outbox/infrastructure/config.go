@@ -2,8 +2,8 @@ package infrastructure
 
 import (
 	"os"
-	"strings"
 
+	"github.com/steadfastie/gokube/data/brocker"
 	"github.com/steadfastie/gokube/data/errors"
 	"github.com/steadfastie/gokube/data/services"
 )
@@ -13,14 +13,13 @@ const (
 	EnvMongoDatabase         = "MONGO_DATABASE"
 	EnvLogLevel              = "LOGLEVEL"
 	EnvCron                  = "CRON"
-	EnvKafkaAddresses        = "KAFKA_ADDRESSES"
 )
 
 type Config struct {
 	MongoSettings services.MongoSettings
 	LogLevel      string
 	Cron          string
-	KafkaServers  []string
+	Broker        brocker.BrokerSettings
 }
 
 func (config *Config) GetMongoSettings() services.MongoSettings {
@@ -52,22 +51,14 @@ func NewConfig() (*Config, error) {
 		cronExpression = "*/5 * * * * *" // Defaults to every 5 seconds
 	}
 
-	kafkaBootstrapServer := os.Getenv(EnvKafkaAddresses)
-	addresses := []string{}
-	if kafkaBootstrapServer == "" {
-		addresses = append(addresses, "localhost")
-	} else {
-		addresses = append(addresses, strings.Split(kafkaBootstrapServer, ",")...)
-	}
-
 	config := &Config{
 		MongoSettings: services.MongoSettings{
 			ConnectionString: mongoConnectionString,
 			Database:         mongoDatabase,
 		},
-		LogLevel:     logLevel,
-		Cron:         cronExpression,
-		KafkaServers: addresses,
+		LogLevel: logLevel,
+		Cron:     cronExpression,
+		Broker:   brocker.SettingsFromEnv(),
 	}
 
 	return config, nil
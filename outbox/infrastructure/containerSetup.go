@@ -33,8 +33,22 @@ func InitializeServices(ctx context.Context, logger *zap.Logger) {
 		log.Fatalf("can't register MongoDB client: %v", err)
 	}
 
-	err = container.Singleton(func(config *Config, logger *zap.Logger) brocker.Producer {
-		return brocker.NewWriter(ctx, logger, config.KafkaServers...)
+	err = container.Singleton(func(config *Config, mongodb *services.MongoDB, logger *zap.Logger) (brocker.Broker, error) {
+		return brocker.NewBroker(ctx, config.Broker, mongodb, logger)
+	})
+	if err != nil {
+		log.Fatalf("can't register broker: %v", err)
+	}
+
+	err = container.Call(func(config *Config, broker brocker.Broker, logger *zap.Logger) error {
+		return brocker.Provision(ctx, broker.NewAdmin(logger), config.Broker, logger, config.Broker.Topics.Counter)
+	})
+	if err != nil {
+		log.Fatalf("can't provision topics: %v", err)
+	}
+
+	err = container.Singleton(func(config *Config, broker brocker.Broker, logger *zap.Logger) brocker.Producer {
+		return broker.NewWriter(ctx, logger, config.Broker.Topics.Counter)
 	})
 	if err != nil {
 		log.Fatalf("can't register Basic repo: %v", err)
@@ -72,15 +86,16 @@ func GetOutboxProcessor() job.OutboxProcessor {
 	return processor
 }
 
-func CheckConnections(ctx context.Context) bool {
+// CheckConnections also reports what the broker knows about its connection, so that a failing probe tells why
+func CheckConnections(ctx context.Context) (bool, brocker.Health) {
 	var mongodb *services.MongoDB
 	container.Resolve(&mongodb)
 
-	var broker brocker.Producer
+	var broker brocker.Broker
 	container.Resolve(&broker)
 
 	mongoConnHealthy := mongodb.CheckConnection(ctx)
-	brokerConnHealthy := broker.CheckConnection()
+	brokerHealth := broker.Health(ctx)
 
-	return mongoConnHealthy && brokerConnHealthy
+	return mongoConnHealthy && brokerHealth.Healthy, brokerHealth
 }
@@ -121,8 +121,10 @@ func (processor *outboxProcessor) handleEvents(ctx context.Context, docId primit
 	}
 	go processor.lockOutbox(lockOptions)
 
+	// Another replica is on it
 	locked := <-lockChan
 	if !locked {
+		errChan <- nil
 		return
 	}
 
@@ -133,25 +135,64 @@ func (processor *outboxProcessor) handleEvents(ctx context.Context, docId primit
 
 	events := <-eventsChan
 	if len(events) == 0 {
+		errChan <- nil
 		return
 	}
 
 	sort.Sort(data.ByTimestamp(events))
 
-	for _, event := range events {
-		eventChan := make(chan bool)
-		defer close(eventChan)
+	err := processor.publishEvents(ctx, events, func(eventId primitive.ObjectID) error {
+		return processor.removeEvent(ctx, docId, eventId)
+	})
+	errChan <- err
+}
+
+// Events are handed over to the producer at once. They share the key of their counter, so the async Kafka writer
+// batches them into the same partition and keeps their order. They are removed from the outbox in order as their
+// deliveries resolve, and after a failure that event and every later one stay in the outbox. Other backends resolve
+// a delivery before returning it, so there a failed event stops the later ones from being handed over at all
+func (processor *outboxProcessor) publishEvents(ctx context.Context, events []data.OutboxEvent, remove func(eventId primitive.ObjectID) error) error {
+	deliveries := make([]*brocker.Delivery, 0, len(events))
+	var sendErr error
+	for i := range events {
+		delivery, err := processor.sendEvent(ctx, &events[i])
+		if err != nil {
+			sendErr = err
+			break
+		}
+		deliveries = append(deliveries, delivery)
+		if failed(delivery) {
+			break
+		}
+	}
 
-		go processor.handleEvent(ctx, &event, eventChan, errChan)
+	for i, delivery := range deliveries {
+		if delivery != nil {
+			report, err := delivery.Wait(ctx)
+			if err != nil {
+				return fmt.Errorf("error happened while delivering event %v: %w", events[i].EventId.Hex(), err)
+			}
+			processor.Logger.Debug("Delivered event", zap.String("eventId", events[i].EventId.Hex()), zap.Int("partition", report.Partition), zap.Int64("offset", report.Offset))
+		}
 
-		handled := <-eventChan
-		if !handled {
-			continue
+		if err := remove(events[i].EventId); err != nil {
+			return err
 		}
+	}
+	return sendErr
+}
 
-		go processor.removeEvent(ctx, docId, event.EventId, eventChan, errChan)
+// Tells apart deliveries that have failed already from those still pending
+func failed(delivery *brocker.Delivery) bool {
+	if delivery == nil {
+		return false
+	}
+	select {
+	case <-delivery.Done():
+		return delivery.Report().Err != nil
+	default:
+		return false
 	}
-	errChan <- nil
 }
 
 type LockOutboxOptions struct {
@@ -215,51 +256,68 @@ func (processor *outboxProcessor) getEvents(ctx context.Context, docId primitive
 	resultChan <- result.Outbox.Events
 }
 
-func (processor *outboxProcessor) handleEvent(ctx context.Context, event *data.OutboxEvent, resultChan chan bool, errChan chan<- error) {
+// Returns no delivery for events that are not shipped, so that they are removed right away
+func (processor *outboxProcessor) sendEvent(ctx context.Context, event *data.OutboxEvent) (*brocker.Delivery, error) {
+	var message *events.CounterEvent
+	var key []byte
 	switch payload := event.Payload.(type) {
 	case *data.CounterCreatedEvent:
-		message := &events.CounterEvent{
+		message = &events.CounterEvent{
 			EventId:   event.EventId,
 			CounterId: payload.CounterId,
 			Who:       payload.UserAlias,
 			What:      payload.Type,
 		}
-		message.AddTrail(events.Api, event.Timestamp)
-		message.AddTrail(events.Outbox, time.Now().UTC())
-
-		value, err := json.Marshal(message)
-		if err != nil {
-			processor.Logger.Error("Error encoding event", zap.Error(err))
-			return
-		}
+		key = []byte(string(payload.Type))
 		processor.Logger.Info("Sending create counter event", zap.Any("Event", event))
-		processor.Producer.SendMessage(ctx, []byte(string(payload.Type)), value)
 	case *data.CounterUpdatedEvent:
-		message := &events.CounterEvent{
+		message = &events.CounterEvent{
 			EventId:   event.EventId,
 			CounterId: payload.CounterId,
 			Who:       fmt.Sprintf("%v (%v)", payload.UpdatedBy, payload.UserAlias),
 			What:      payload.Type,
 		}
-		message.AddTrail(events.Api, event.Timestamp)
-		message.AddTrail(events.Outbox, time.Now().UTC())
-
-		value, err := json.Marshal(message)
-		if err != nil {
-			processor.Logger.Error("Error encoding event", zap.Error(err))
-			return
-		}
+		key = []byte(string(payload.Type))
 		processor.Logger.Info("Sending update counter event", zap.Any("Event", event))
-		processor.Producer.SendMessage(ctx, []byte(string(payload.Type)), value)
+	case *data.CounterAccessEvent:
+		message = &events.CounterEvent{
+			EventId:   event.EventId,
+			CounterId: payload.CounterId,
+			Who:       payload.UserAlias,
+			What:      payload.Type,
+			Access: &events.AccessChange{
+				Kind:      payload.Kind,
+				Principal: payload.Principal,
+				Access:    payload.Access,
+			},
+		}
+		key = []byte(string(payload.Type))
+		processor.Logger.Info("Sending counter access event", zap.Any("Event", event))
 	default:
-		message := "Unknown event has been found. Won't ship that"
-		processor.Logger.Info(message, zap.Any("Event", event))
+		processor.Logger.Info("Unknown event has been found. Won't ship that", zap.Any("Event", event))
+		return nil, nil
 	}
 
-	resultChan <- true
+	// Events written before tenants existed belong to the default one
+	message.Tenant = event.Tenant
+	if message.Tenant == "" {
+		message.Tenant = data.DefaultTenant
+	}
+	message.AddTrail(events.Api, event.Timestamp)
+	message.AddTrail(events.Outbox, time.Now().UTC())
+
+	value, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("error happened while encoding event %v: %w", event.EventId.Hex(), err)
+	}
+	return processor.Producer.PublishAsync(ctx, &brocker.Message{
+		Key:     key,
+		Value:   value,
+		Headers: []brocker.Header{{Key: brocker.HeaderTenant, Value: []byte(message.Tenant)}},
+	}), nil
 }
 
-func (processor *outboxProcessor) removeEvent(ctx context.Context, docId primitive.ObjectID, eventId primitive.ObjectID, resultChan chan bool, errChan chan<- error) {
+func (processor *outboxProcessor) removeEvent(ctx context.Context, docId primitive.ObjectID, eventId primitive.ObjectID) error {
 	filter := bson.M{"_id": docId}
 	update := bson.M{
 		"$pull": bson.M{
@@ -269,6 +327,7 @@ func (processor *outboxProcessor) removeEvent(ctx context.Context, docId primiti
 
 	_, err := processor.Collection.UpdateOne(ctx, filter, update)
 	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
-		errChan <- fmt.Errorf("error happened while removing events from %v: %w", docId.Hex(), err)
+		return fmt.Errorf("error happened while removing events from %v: %w", docId.Hex(), err)
 	}
+	return nil
 }
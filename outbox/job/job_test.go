@@ -0,0 +1,262 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/steadfastie/gokube/data"
+	"github.com/steadfastie/gokube/data/brocker"
+	"github.com/steadfastie/gokube/data/events"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// Fails the publish with the given number, counting from 1
+type failingProducer struct {
+	brocker.Producer
+	failAt int
+	calls  int
+}
+
+func (producer *failingProducer) PublishAsync(ctx context.Context, message *brocker.Message) *brocker.Delivery {
+	producer.calls++
+	if producer.calls == producer.failAt {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		return producer.Producer.PublishAsync(cancelled, message)
+	}
+	return producer.Producer.PublishAsync(ctx, message)
+}
+
+func TestPublishEventsStopsAtFirstFailure(t *testing.T) {
+	ctx := context.Background()
+	logger := zap.NewNop()
+	broker := brocker.NewMemoryBroker(1)
+	producer := &failingProducer{Producer: broker.NewWriter(ctx, logger, "counter"), failAt: 2}
+	processor := &outboxProcessor{Producer: producer, Logger: logger}
+
+	counterId := primitive.NewObjectID()
+	outbox := make([]data.OutboxEvent, 3)
+	for i := range outbox {
+		outbox[i] = data.OutboxEvent{
+			EventId:   primitive.NewObjectID(),
+			Payload:   data.NewCounterUpdatedEvent(counterId, i+1, "user", "alias"),
+			Timestamp: time.Now().UTC(),
+		}
+	}
+
+	removed := []primitive.ObjectID{}
+	err := processor.publishEvents(ctx, outbox, func(eventId primitive.ObjectID) error {
+		removed = append(removed, eventId)
+		return nil
+	})
+	if err == nil {
+		t.Fatal("Failed delivery was not reported")
+	}
+
+	if producer.calls != 2 {
+		t.Errorf("Producer was called %d times, want the third event never published", producer.calls)
+	}
+	if !slices.Equal(removed, []primitive.ObjectID{outbox[0].EventId}) {
+		t.Errorf("Removed %v, want only the first event", removed)
+	}
+
+	offsets, err := broker.NewAdmin(logger).GroupOffsets(ctx, "counter", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offsets[0].Last != 1 {
+		t.Fatalf("Topic has %d messages, want only the first event", offsets[0].Last)
+	}
+	err = broker.NewAdmin(logger).ReadPartition(ctx, "counter", 0, 0, 1, func(message *brocker.Message) error {
+		var event events.CounterEvent
+		if err := json.Unmarshal(message.Value, &event); err != nil {
+			return err
+		}
+		if event.EventId != outbox[0].EventId {
+			t.Errorf("Published %v, want the first event", event.EventId.Hex())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishEventsInOrder(t *testing.T) {
+	ctx := context.Background()
+	logger := zap.NewNop()
+	broker := brocker.NewMemoryBroker(1)
+	processor := &outboxProcessor{Producer: broker.NewWriter(ctx, logger, "counter"), Logger: logger}
+
+	counterId := primitive.NewObjectID()
+	outbox := make([]data.OutboxEvent, 5)
+	for i := range outbox {
+		outbox[i] = data.OutboxEvent{
+			EventId:   primitive.NewObjectID(),
+			Payload:   data.NewCounterUpdatedEvent(counterId, i+1, "user", "alias"),
+			Timestamp: time.Now().UTC(),
+		}
+	}
+
+	removed := 0
+	err := processor.publishEvents(ctx, outbox, func(eventId primitive.ObjectID) error {
+		// Every event is in the topic before it leaves the outbox, and events leave it in order
+		offsets, err := broker.NewAdmin(logger).GroupOffsets(ctx, "counter", "test")
+		if err != nil {
+			return err
+		}
+		if offsets[0].Last <= int64(removed) {
+			t.Errorf("Topic has %d messages when event %d is removed", offsets[0].Last, removed+1)
+		}
+		if eventId != outbox[removed].EventId {
+			t.Errorf("Removed %v, want event %d", eventId.Hex(), removed)
+		}
+		removed++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offset := 0
+	err = broker.NewAdmin(logger).ReadPartition(ctx, "counter", 0, 0, 5, func(message *brocker.Message) error {
+		var event events.CounterEvent
+		if err := json.Unmarshal(message.Value, &event); err != nil {
+			return err
+		}
+		if event.EventId != outbox[offset].EventId {
+			t.Errorf("Offset %d holds %v, want %v", offset, event.EventId.Hex(), outbox[offset].EventId.Hex())
+		}
+		offset++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSendEventTenant(t *testing.T) {
+	tests := []struct {
+		name   string
+		tenant string
+		want   string
+	}{
+		{"tenant", "acme", "acme"},
+		{"event written before tenants", "", data.DefaultTenant},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			logger := zap.NewNop()
+			broker := brocker.NewMemoryBroker(1)
+			processor := &outboxProcessor{Producer: broker.NewWriter(ctx, logger, "counter"), Logger: logger}
+
+			delivery, err := processor.sendEvent(ctx, &data.OutboxEvent{
+				EventId:   primitive.NewObjectID(),
+				Payload:   data.NewCounterUpdatedEvent(primitive.NewObjectID(), 1, "user", "alias"),
+				Timestamp: time.Now().UTC(),
+				Tenant:    test.tenant,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := delivery.Wait(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			err = broker.NewAdmin(logger).ReadPartition(ctx, "counter", 0, 0, 1, func(message *brocker.Message) error {
+				if tenant, _ := message.Header(brocker.HeaderTenant); tenant != test.want {
+					t.Errorf("Tenant header = %q, want %q", tenant, test.want)
+				}
+				var event events.CounterEvent
+				if err := json.Unmarshal(message.Value, &event); err != nil {
+					return err
+				}
+				if event.Tenant != test.want {
+					t.Errorf("Event tenant = %q, want %q", event.Tenant, test.want)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// Queues messages like the async Kafka writer, leaving the test to resolve their deliveries
+type queueingProducer struct {
+	brocker.Producer
+	queued chan func(report brocker.DeliveryReport)
+}
+
+func (producer *queueingProducer) PublishAsync(ctx context.Context, message *brocker.Message) *brocker.Delivery {
+	delivery, resolve := brocker.NewDelivery()
+	producer.queued <- resolve
+	return delivery
+}
+
+func TestPublishEventsPipelined(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	producer := &queueingProducer{queued: make(chan func(report brocker.DeliveryReport), 3)}
+	processor := &outboxProcessor{Producer: producer, Logger: zap.NewNop()}
+
+	counterId := primitive.NewObjectID()
+	outbox := make([]data.OutboxEvent, 3)
+	for i := range outbox {
+		outbox[i] = data.OutboxEvent{
+			EventId:   primitive.NewObjectID(),
+			Payload:   data.NewCounterUpdatedEvent(counterId, i+1, "user", "alias"),
+			Timestamp: time.Now().UTC(),
+		}
+	}
+
+	removed := make(chan primitive.ObjectID, len(outbox))
+	result := make(chan error, 1)
+	go func() {
+		result <- processor.publishEvents(ctx, outbox, func(eventId primitive.ObjectID) error {
+			removed <- eventId
+			return nil
+		})
+	}()
+
+	// Every event is handed over before the first one is delivered
+	resolvers := make([]func(report brocker.DeliveryReport), len(outbox))
+	for i := range resolvers {
+		select {
+		case resolvers[i] = <-producer.queued:
+		case <-ctx.Done():
+			t.Fatalf("Only %d events were handed over before any delivery", i)
+		}
+	}
+
+	// Deliveries resolving out of order don't make events leave the outbox out of order
+	resolvers[1](brocker.DeliveryReport{Offset: 1})
+	select {
+	case eventId := <-removed:
+		t.Fatalf("Removed %v before the first event was delivered", eventId.Hex())
+	case <-time.After(50 * time.Millisecond):
+	}
+	resolvers[0](brocker.DeliveryReport{Offset: 0})
+	for i := 0; i < 2; i++ {
+		if eventId := <-removed; eventId != outbox[i].EventId {
+			t.Errorf("Removed %v, want event %d", eventId.Hex(), i)
+		}
+	}
+
+	resolvers[2](brocker.DeliveryReport{Err: errors.New("leader not available")})
+	if err := <-result; err == nil {
+		t.Error("Failed delivery was not reported")
+	}
+	if len(removed) != 0 {
+		t.Error("Failed event was removed from the outbox")
+	}
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"os/signal"
 	"syscall"
 
 	"github.com/go-co-op/gocron/v2"
+	"github.com/steadfastie/gokube/data/brocker"
 	infra "github.com/steadfastie/gokube/outbox/infrastructure"
 	"github.com/steadfastie/gokube/outbox/job"
 	"go.uber.org/zap"
@@ -46,11 +48,14 @@ func main() {
 		gocron.NewTask(
 			func() {
 				http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-					if infra.CheckConnections(r.Context()) {
+					healthy, broker := infra.CheckConnections(r.Context())
+					w.Header().Set("Content-Type", "application/json")
+					if healthy {
 						w.WriteHeader(http.StatusOK)
 					} else {
 						w.WriteHeader(http.StatusInternalServerError)
 					}
+					json.NewEncoder(w).Encode(map[string]brocker.Health{"broker": broker})
 				})
 				http.ListenAndServe(":8080", nil)
 			},